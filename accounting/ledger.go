@@ -0,0 +1,451 @@
+/*
+Accounting of positions, cost basis and profit and loss from our own fills.
+
+A Ledger consumes the trades and orders that were filled on an account,
+and keeps a position for each symbol bought, made of lots with a cost
+basis in the currency it was bought with. The counter of a pair is taken
+as cash unless the ledger holds it, so buying btc with usd adds a btc lot
+costing the usd spent. Selling a position for the currency its cost is in
+is matched against lots first in first out, last in first out, or at the
+average cost, which realizes a profit or loss. Trading one held symbol for
+another, like buying ltc with held btc, books both legs: the btc lots are
+reduced and their cost basis is carried into the ltc lot, so nothing is
+realized until the position is sold back for its cost currency. The lots
+that remain are marked to market data for the unrealized profit or loss.
+Fees on buys are added to the cost basis and fees on sales are taken from
+the proceeds.
+*/
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// how lots are matched when a position is reduced
+type Method string
+
+const (
+	FIFO    Method = "fifo"
+	LIFO    Method = "lifo"
+	Average Method = "average"
+)
+
+// amounts smaller than this are treated as zero
+const epsilon = 1e-9
+
+// an amount of a symbol acquired at once, cost includes fees
+type Lot struct {
+	Acquired time.Time
+	Amount   float64
+	Cost     float64
+}
+
+// the price paid per unit of the symbol
+func (l *Lot) Price() float64 {
+	if l.Amount == 0 {
+		return 0
+	}
+	return l.Cost / l.Amount
+}
+
+// an amount of a symbol that was sold for its cost currency, matched
+// against a lot
+type Disposal struct {
+	Symbol, Currency   b.Symbol
+	Amount             float64
+	Acquired, Disposed time.Time
+	Proceeds, Cost     float64
+}
+
+// the gain or loss on a disposal
+func (d *Disposal) Gain() float64 {
+	return d.Proceeds - d.Cost
+}
+
+// the holdings of a symbol, with a cost in currency
+type Position struct {
+	Symbol     b.Symbol
+	Currency   b.Symbol
+	Amount     float64
+	Cost       float64
+	Lots       []Lot
+	Realized   float64
+	Unrealized float64
+	Fees       float64
+	Mark       float64
+	Marked     time.Time
+}
+
+// the average price paid for the position
+func (p *Position) Price() float64 {
+	if p.Amount == 0 {
+		return 0
+	}
+	return p.Cost / p.Amount
+}
+
+// the realized and unrealized profit of the position
+func (p *Position) PnL() float64 {
+	return p.Realized + p.Unrealized
+}
+
+func (p *Position) String() string {
+	return fmt.Sprintf("%s %.8f @ %.8f %s, realized %.8f, unrealized %.8f, fees %.8f",
+		p.Symbol, p.Amount, p.Price(), p.Currency, p.Realized, p.Unrealized, p.Fees)
+}
+
+type Ledger struct {
+	method    Method
+	fee       float64
+	mutex     sync.Mutex
+	positions map[b.Symbol]*Position
+	marks     map[b.Pair]b.MarketData
+	orders    map[string]b.Order
+	disposals []Disposal
+	deposits  map[b.Symbol]float64
+}
+
+// creates a ledger. accepts a method of fifo, lifo or average (defaulting
+// to fifo) and a fee as the fraction charged on trades added without one
+func NewLedger(config map[string]interface{}) (*Ledger, error) {
+	l := &Ledger{
+		method:    FIFO,
+		positions: map[b.Symbol]*Position{},
+		marks:     map[b.Pair]b.MarketData{},
+		orders:    map[string]b.Order{},
+		deposits:  map[b.Symbol]float64{},
+	}
+
+	if method, ok := config["method"].(string); ok && method != "" {
+		l.method = Method(method)
+	} else if method, ok := config["method"].(Method); ok {
+		l.method = method
+	}
+
+	if l.method != FIFO && l.method != LIFO && l.method != Average {
+		return nil, errors.New("Unknown cost basis method " + string(l.method))
+	}
+
+	if fee, ok := config["fee"].(float64); ok {
+		l.fee = fee
+	}
+
+	return l, nil
+}
+
+// adds an opening lot of the base of a pair costed in the counter, e.g for
+// balances held before the ledger
+func (l *Ledger) AddLot(pair b.Pair, amount float64, price float64, acquired time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.acquire(pair.Base, pair.Counter, amount, amount*price, acquired)
+	return err
+}
+
+// adds a fill, charging the configured fee on its value
+func (l *Ledger) AddTrade(trade b.Trade) error {
+	return l.AddFill(trade, trade.Amount*trade.Rate*l.fee)
+}
+
+// adds a fill with a fee in the counter currency
+func (l *Ledger) AddFill(trade b.Trade, fee float64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.fill(trade.Type, trade.Pair, trade.Amount, trade.Rate, fee, trade.Timestamp)
+}
+
+// adds an order, accounting for what has filled since the order was last
+// added at rate, the average price of those fills as a core.OrderUpdate
+// reports it. a rate of 0 uses the order's rate, which market orders don't
+// have. the order's fee is taken as being in the counter currency
+func (l *Ledger) AddOrder(order b.Order, rate float64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	previous := l.orders[order.Id]
+	filled := (order.Amount - order.Remains) - (previous.Amount - previous.Remains)
+	fee := order.Fee - previous.Fee
+	if filled <= epsilon {
+		return nil
+	}
+
+	if rate <= 0 {
+		rate = order.Rate
+	}
+	if rate <= 0 {
+		return errors.New("No fill price for order " + order.Id)
+	}
+
+	if err := l.fill(order.Type, order.Pair, filled, rate, fee, order.Timestamp); err != nil {
+		return err
+	}
+
+	if order.Id != "" {
+		l.orders[order.Id] = order
+	}
+	return nil
+}
+
+// adds a deposit or a withdrawal. withdrawals of a symbol that's held
+// reduce its position at cost without realizing anything
+func (l *Ledger) AddTransaction(tx b.Transaction) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.deposits[tx.Symbol] += tx.Amount
+	if p, ok := l.positions[tx.Symbol]; ok && tx.Amount < 0 && p.Amount > 0 {
+		l.reduce(p, math.Min(-tx.Amount, p.Amount))
+	}
+	return nil
+}
+
+// marks the position in the base of a pair to the last price of market
+// data, when the position is costed in the counter
+func (l *Ledger) Mark(data b.MarketData) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.marks[data.Pair] = data
+	if p, ok := l.positions[data.Pair.Base]; ok {
+		l.mark(p)
+	}
+}
+
+// returns the position in a symbol
+func (l *Ledger) Position(symbol b.Symbol) Position {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if p, ok := l.positions[symbol]; ok {
+		return copyPosition(p)
+	}
+	return Position{Symbol: symbol}
+}
+
+// returns all positions, ordered by symbol
+func (l *Ledger) Positions() []Position {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	positions := []Position{}
+	for _, symbol := range l.symbols() {
+		positions = append(positions, copyPosition(l.positions[symbol]))
+	}
+	return positions
+}
+
+// returns the disposals in the order they happened
+func (l *Ledger) Disposals() []Disposal {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]Disposal{}, l.disposals...)
+}
+
+// returns the net deposits of each symbol
+func (l *Ledger) Deposits() map[b.Symbol]float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	deposits := map[b.Symbol]float64{}
+	for symbol, amount := range l.deposits {
+		deposits[symbol] = amount
+	}
+	return deposits
+}
+
+// returns the realized and unrealized profit and the fees of all positions
+// by cost currency
+func (l *Ledger) PnL() (realized, unrealized, fees map[b.Symbol]float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	realized, unrealized, fees = map[b.Symbol]float64{}, map[b.Symbol]float64{}, map[b.Symbol]float64{}
+	for _, p := range l.positions {
+		realized[p.Currency] += p.Realized
+		unrealized[p.Currency] += p.Unrealized
+		fees[p.Currency] += p.Fees
+	}
+	return
+}
+
+// books both legs of a fill. the counter is spent on buys and received on
+// sells, and is only booked against a position when one is held
+func (l *Ledger) fill(t b.TradeType, pair b.Pair, amount float64, rate float64, fee float64, timestamp time.Time) error {
+	if amount <= 0 || rate <= 0 {
+		return errors.New("Fills need a positive amount and rate")
+	}
+
+	switch t {
+	case b.Buy:
+		spent := amount*rate + fee
+		counter, held := l.positions[pair.Counter]
+		if !held || counter.Amount <= epsilon {
+			p, err := l.acquire(pair.Base, pair.Counter, amount, spent, timestamp)
+			if err != nil {
+				return err
+			}
+			p.Fees += fee
+			return nil
+		}
+		return l.exchange(counter, spent, pair.Base, amount, 0, timestamp)
+	case b.Sell:
+		p, held := l.positions[pair.Base]
+		if !held {
+			return fmt.Errorf("Sold %.8f %s but none is held", amount, pair.Base)
+		}
+		return l.exchange(p, amount, pair.Counter, amount*rate-fee, fee, timestamp)
+	default:
+		return errors.New("Unknown trade type " + string(t))
+	}
+}
+
+// trades an amount of a held position for an amount of another symbol. if
+// that's the position's cost currency the lots spent are disposed of for
+// a gain or loss, otherwise their cost is moved into a lot of the symbol
+// received. the fee is in the symbol received
+func (l *Ledger) exchange(from *Position, spent float64, symbol b.Symbol, amount float64, fee float64, timestamp time.Time) error {
+	if spent > from.Amount+epsilon {
+		return fmt.Errorf("Sold %.8f %s but only %.8f is held", spent, from.Symbol, from.Amount)
+	}
+
+	if symbol == from.Currency {
+		for _, lot := range l.reduce(from, spent) {
+			share := lot.Amount / spent
+			d := Disposal{from.Symbol, from.Currency, lot.Amount, lot.Acquired, timestamp, amount * share, lot.Cost}
+			from.Realized += d.Gain()
+			l.disposals = append(l.disposals, d)
+		}
+		from.Fees += fee
+		return nil
+	}
+
+	if err := l.costedIn(symbol, from.Currency); err != nil {
+		return err
+	}
+
+	cost := 0.0
+	for _, lot := range l.reduce(from, math.Min(spent, from.Amount)) {
+		cost += lot.Cost
+	}
+
+	_, err := l.acquire(symbol, from.Currency, amount, cost, timestamp)
+	return err
+}
+
+// returns an error if a symbol is held with a cost in another currency,
+// as its lots can't be pooled
+func (l *Ledger) costedIn(symbol b.Symbol, currency b.Symbol) error {
+	if p, ok := l.positions[symbol]; ok && len(p.Lots) > 0 && p.Currency != currency {
+		return fmt.Errorf("%s is held at a cost in %s, not %s", symbol, p.Currency, currency)
+	}
+	return nil
+}
+
+// adds a lot to a position, pooling it into a single lot for average cost
+func (l *Ledger) acquire(symbol b.Symbol, currency b.Symbol, amount float64, cost float64, acquired time.Time) (*Position, error) {
+	if err := l.costedIn(symbol, currency); err != nil {
+		return nil, err
+	}
+
+	p, ok := l.positions[symbol]
+	if !ok {
+		p = &Position{Symbol: symbol}
+		l.positions[symbol] = p
+	}
+	p.Currency = currency
+
+	if l.method == Average && len(p.Lots) > 0 {
+		p.Lots[0].Amount += amount
+		p.Lots[0].Cost += cost
+	} else {
+		p.Lots = append(p.Lots, Lot{acquired, amount, cost})
+	}
+
+	p.Amount += amount
+	p.Cost += cost
+	l.mark(p)
+	return p, nil
+}
+
+// removes an amount from a position's lots by the ledger's method, and
+// returns the parts of the lots that were removed
+func (l *Ledger) reduce(p *Position, amount float64) []Lot {
+	removed := []Lot{}
+	for amount > epsilon && len(p.Lots) > 0 {
+		i := 0
+		if l.method == LIFO {
+			i = len(p.Lots) - 1
+		}
+
+		lot := &p.Lots[i]
+		taken := math.Min(amount, lot.Amount)
+		cost := lot.Price() * taken
+		removed = append(removed, Lot{lot.Acquired, taken, cost})
+
+		lot.Amount -= taken
+		lot.Cost -= cost
+		if lot.Amount <= epsilon {
+			p.Lots = append(p.Lots[:i], p.Lots[i+1:]...)
+		}
+		amount -= taken
+	}
+
+	p.Amount, p.Cost = 0, 0
+	for _, lot := range p.Lots {
+		p.Amount += lot.Amount
+		p.Cost += lot.Cost
+	}
+	l.mark(p)
+	return removed
+}
+
+// updates the unrealized profit of a position from the market data of its
+// symbol in its cost currency
+func (l *Ledger) mark(p *Position) {
+	data, ok := l.marks[b.Pair{Base: p.Symbol, Counter: p.Currency}]
+	if !ok {
+		return
+	}
+
+	price := data.Last
+	if price <= 0 && data.Buy > 0 && data.Sell > 0 {
+		price = (data.Buy + data.Sell) / 2
+	}
+	if price <= 0 {
+		return
+	}
+
+	p.Mark, p.Marked = price, data.Updated
+	p.Unrealized = p.Amount*price - p.Cost
+}
+
+func (l *Ledger) symbols() []b.Symbol {
+	symbols := []b.Symbol{}
+	for symbol, _ := range l.positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Sort(bySymbol(symbols))
+	return symbols
+}
+
+func copyPosition(p *Position) Position {
+	c := *p
+	c.Lots = append([]Lot{}, p.Lots...)
+	return c
+}
+
+type bySymbol []b.Symbol
+
+func (s bySymbol) Len() int           { return len(s) }
+func (s bySymbol) Less(i, j int) bool { return s[i] < s[j] }
+func (s bySymbol) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,155 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func trade(t babel.TradeType, amount float64, rate float64, day int) babel.Trade {
+	return babel.Trade{
+		Pair:      babel.BTC_USD,
+		Type:      t,
+		Amount:    amount,
+		Rate:      rate,
+		Timestamp: time.Date(2014, 1, day, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func ledger(method Method) *Ledger {
+	l, err := NewLedger(map[string]interface{}{"method": method})
+	So(err, ShouldBeNil)
+	So(l.AddTrade(trade(babel.Buy, 1, 100, 1)), ShouldBeNil)
+	So(l.AddTrade(trade(babel.Buy, 1, 200, 2)), ShouldBeNil)
+	So(l.AddTrade(trade(babel.Sell, 1, 250, 3)), ShouldBeNil)
+	return l
+}
+
+func TestLedgerSpec(t *testing.T) {
+	Convey("Subject: Cost Basis Ledger", t, func() {
+
+		Convey(`FIFO should sell the earliest lots first`, func() {
+			l := ledger(FIFO)
+			p := l.Position(babel.BTC)
+			So(p.Realized, ShouldEqual, 150)
+			So(p.Amount, ShouldEqual, 1)
+			So(p.Price(), ShouldEqual, 200)
+
+			disposals := l.Disposals()
+			So(len(disposals), ShouldEqual, 1)
+			So(disposals[0].Acquired.Day(), ShouldEqual, 1)
+			So(disposals[0].Gain(), ShouldEqual, 150)
+		})
+
+		Convey(`LIFO should sell the latest lots first`, func() {
+			p := ledger(LIFO).Position(babel.BTC)
+			So(p.Realized, ShouldEqual, 50)
+			So(p.Price(), ShouldEqual, 100)
+		})
+
+		Convey(`Average should sell at the average cost`, func() {
+			p := ledger(Average).Position(babel.BTC)
+			So(p.Realized, ShouldEqual, 100)
+			So(p.Price(), ShouldEqual, 150)
+			So(len(p.Lots), ShouldEqual, 1)
+		})
+
+		Convey(`Sales across lots should be split into disposals`, func() {
+			l := ledger(FIFO)
+			So(l.AddTrade(trade(babel.Buy, 1, 300, 4)), ShouldBeNil)
+			So(l.AddTrade(trade(babel.Sell, 1.5, 400, 5)), ShouldBeNil)
+
+			disposals := l.Disposals()
+			So(len(disposals), ShouldEqual, 3)
+			So(disposals[1].Amount, ShouldEqual, 1)
+			So(disposals[1].Proceeds, ShouldAlmostEqual, 400)
+			So(disposals[2].Amount, ShouldEqual, 0.5)
+			So(disposals[2].Cost, ShouldAlmostEqual, 150)
+		})
+
+		Convey(`Positions should be marked to market data`, func() {
+			l := ledger(FIFO)
+			l.Mark(babel.MarketData{Pair: babel.BTC_USD, Last: 260})
+			p := l.Position(babel.BTC)
+			So(p.Unrealized, ShouldEqual, 60)
+			So(p.PnL(), ShouldEqual, 210)
+
+			realized, unrealized, _ := l.PnL()
+			So(realized[babel.USD], ShouldEqual, 150)
+			So(unrealized[babel.USD], ShouldEqual, 60)
+		})
+
+		Convey(`Fees should be added to cost and taken from proceeds`, func() {
+			l, _ := NewLedger(map[string]interface{}{"fee": 0.01})
+			So(l.AddTrade(trade(babel.Buy, 1, 100, 1)), ShouldBeNil)
+			So(l.Position(babel.BTC).Cost, ShouldEqual, 101)
+
+			So(l.AddFill(trade(babel.Sell, 1, 200, 2), 2), ShouldBeNil)
+			p := l.Position(babel.BTC)
+			So(p.Realized, ShouldEqual, 97)
+			So(p.Fees, ShouldEqual, 3)
+		})
+
+		Convey(`Orders should only account for what filled since they were added`, func() {
+			l, _ := NewLedger(map[string]interface{}{})
+			order := babel.Order{Id: "1", Pair: babel.BTC_USD, Type: babel.Buy, Amount: 2, Remains: 2, Rate: 100}
+			So(l.AddOrder(order, 0), ShouldBeNil)
+			So(l.Position(babel.BTC).Amount, ShouldEqual, 0)
+
+			order.Remains = 1
+			So(l.AddOrder(order, 0), ShouldBeNil)
+			order.Remains = 0
+			So(l.AddOrder(order, 0), ShouldBeNil)
+			So(l.AddOrder(order, 0), ShouldBeNil)
+			So(l.Position(babel.BTC).Amount, ShouldEqual, 2)
+		})
+
+		Convey(`Market orders should be booked at their fill price`, func() {
+			l, _ := NewLedger(map[string]interface{}{})
+			order := babel.Order{Id: "1", Pair: babel.BTC_USD, Type: babel.Buy, Amount: 2, Remains: 0, Rate: -1}
+			So(l.AddOrder(order, 0), ShouldNotBeNil)
+			So(l.AddOrder(order, 105), ShouldBeNil)
+			So(l.Position(babel.BTC).Cost, ShouldEqual, 210)
+		})
+
+		Convey(`Trades between held symbols should carry the cost basis`, func() {
+			l := ledger(FIFO)
+			buy := babel.Trade{Pair: babel.LTC_BTC, Type: babel.Buy, Amount: 40, Rate: 0.02, Timestamp: time.Now()}
+			So(l.AddTrade(buy), ShouldBeNil)
+
+			So(l.Position(babel.BTC).Amount, ShouldAlmostEqual, 0.2)
+			ltc := l.Position(babel.LTC)
+			So(ltc.Amount, ShouldEqual, 40)
+			So(ltc.Currency, ShouldEqual, babel.USD)
+			So(ltc.Cost, ShouldAlmostEqual, 160)
+			So(len(l.Disposals()), ShouldEqual, 1)
+
+			sell := babel.Trade{Pair: babel.LTC_USD, Type: babel.Sell, Amount: 40, Rate: 5, Timestamp: time.Now()}
+			So(l.AddTrade(sell), ShouldBeNil)
+			So(l.Position(babel.LTC).Realized, ShouldAlmostEqual, 40)
+			So(len(l.Disposals()), ShouldEqual, 2)
+		})
+
+		Convey(`Selling more than is held should fail`, func() {
+			l := ledger(FIFO)
+			So(l.AddTrade(trade(babel.Sell, 2, 100, 4)), ShouldNotBeNil)
+			So(l.Position(babel.BTC).Amount, ShouldEqual, 1)
+		})
+
+		Convey(`Withdrawals should reduce positions at cost`, func() {
+			l := ledger(FIFO)
+			So(l.AddTransaction(babel.Transaction{Symbol: babel.BTC, Amount: -0.5}), ShouldBeNil)
+			p := l.Position(babel.BTC)
+			So(p.Amount, ShouldEqual, 0.5)
+			So(p.Realized, ShouldEqual, 150)
+			So(l.Deposits()[babel.BTC], ShouldEqual, -0.5)
+		})
+
+		Convey(`Unknown methods should fail`, func() {
+			_, err := NewLedger(map[string]interface{}{"method": "hifo"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
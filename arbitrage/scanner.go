@@ -0,0 +1,332 @@
+/*
+Detection of arbitrage between exchanges, and within one exchange.
+
+The Scanner compares the order books of a pair on several venues, and
+finds where buying on one venue and selling on another is profitable
+after each venue's fees, walking the books so the amount is limited to
+the depth that is actually available. Venues that implement
+core.FeeSchedule report their own fees, otherwise the fees config is used.
+
+Triangular finds cycles of trades within a single exchange that end with
+more of a currency than they started with, e.g btc->ltc->ftc->btc.
+*/
+package arbitrage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// an exchange that the scanner watches, the name identifies it in opportunities
+type Venue struct {
+	Name     string
+	Exchange b.Exchange
+}
+
+// buying an amount on one venue and selling it on another, prices are the
+// average over the levels used and the limits are the worst of them.
+// costs and proceeds are in the counter currency after fees
+type Opportunity struct {
+	Pair                b.Pair
+	Buy, Sell           string
+	Amount              float64
+	BuyPrice, SellPrice float64
+	BuyLimit, SellLimit float64
+	Cost, Proceeds      float64
+	Profit, Return      float64
+	Time                time.Time
+}
+
+func (o *Opportunity) String() string {
+	return fmt.Sprintf("%s buy %.4f on %s @ %.4f, sell on %s @ %.4f, profit %.4f (%.2f%%)",
+		o.Pair.String(), o.Amount, o.Buy, o.BuyPrice, o.Sell, o.SellPrice, o.Profit, o.Return*100)
+}
+
+type Scanner struct {
+	venues     []Venue
+	config     map[string]interface{}
+	fees       map[string]float64
+	defaultFee float64
+	minReturn  float64
+	minProfit  float64
+	maxAmount  float64
+	depth      int
+	clock      b.Clock
+	stop       chan bool
+	stopped    sync.Once
+}
+
+// creates a scanner of venues. accepts fees as a map of venue name to fee,
+// a default fee, min_return, min_profit and max_amount floats, a book depth
+// int and a clock as config
+func NewScanner(venues []Venue, config map[string]interface{}) *Scanner {
+	s := &Scanner{
+		venues:     venues,
+		config:     config,
+		fees:       map[string]float64{},
+		defaultFee: b.ConfigFloat(config, "fee", 0.002),
+		minReturn:  b.ConfigFloat(config, "min_return", 0),
+		minProfit:  b.ConfigFloat(config, "min_profit", 0),
+		maxAmount:  b.ConfigFloat(config, "max_amount", 0),
+		depth:      50,
+		clock:      b.ConfigClock(config),
+		stop:       make(chan bool),
+	}
+
+	if fees, ok := config["fees"].(map[string]float64); ok {
+		s.fees = fees
+	}
+
+	if depth, ok := config["depth"].(int); ok {
+		s.depth = depth
+	}
+
+	return s
+}
+
+// returns the opportunities for a pair across all venues, most profitable first
+func (s *Scanner) Scan(pair b.Pair) ([]Opportunity, error) {
+	type result struct {
+		venue Venue
+		book  b.OrderBook
+		fee   float64
+		err   error
+	}
+
+	results := make(chan result, len(s.venues))
+	for _, venue := range s.venues {
+		go func(venue Venue) {
+			// drivers without order books may panic, which skips the venue
+			defer func() {
+				if r := recover(); r != nil {
+					results <- result{venue: venue, err: fmt.Errorf("%v", r)}
+				}
+			}()
+
+			book, err := s.book(venue, pair)
+			results <- result{venue, book, s.fee(venue, pair), err}
+		}(venue)
+	}
+
+	books := []result{}
+	for _ = range s.venues {
+		r := <-results
+		if r.err != nil {
+			log.Printf("Skipping %s: %v", r.venue.Name, r.err)
+			continue
+		}
+		books = append(books, r)
+	}
+
+	if len(books) < 2 {
+		return nil, errors.New("Need books from at least 2 venues for " + pair.String())
+	}
+
+	now := s.clock.Now()
+	opportunities := []Opportunity{}
+	for _, buy := range books {
+		for _, sell := range books {
+			if buy.venue.Name == sell.venue.Name {
+				continue
+			}
+
+			o := match(buy.book, sell.book, buy.fee, sell.fee, s.maxAmount)
+			if o.Amount > 0 && o.Return >= s.minReturn && o.Profit >= s.minProfit {
+				o.Pair, o.Buy, o.Sell, o.Time = pair, buy.venue.Name, sell.venue.Name, now
+				opportunities = append(opportunities, o)
+			}
+		}
+	}
+
+	sort.Sort(byProfit(opportunities))
+	return opportunities, nil
+}
+
+// scans a pair every interval until Stop, writing opportunities to a channel
+func (s *Scanner) Watch(pair b.Pair, interval time.Duration, channel chan<- Opportunity) error {
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		opportunities, err := s.Scan(pair)
+		if err != nil {
+			log.Printf("Failed to scan %s: %v", pair.String(), err)
+		}
+		for _, o := range opportunities {
+			channel <- o
+		}
+
+		select {
+		case <-ticker.C():
+		case <-s.stop:
+			return nil
+		}
+	}
+}
+
+// stops a running Watch
+func (s *Scanner) Stop() {
+	s.stopped.Do(func() {
+		close(s.stop)
+	})
+}
+
+// places both legs of an opportunity as limit orders, reducing the amount
+// to what the balances on each venue allow. if the sell can't be placed the
+// buy is cancelled, whatever of it has already filled is left unhedged
+func (s *Scanner) Execute(o Opportunity) (b.Order, b.Order, error) {
+	buyVenue, sellVenue := s.venue(o.Buy), s.venue(o.Sell)
+	if buyVenue == nil || sellVenue == nil {
+		return b.Order{}, b.Order{}, errors.New("Unknown venue in opportunity")
+	}
+
+	counter, err := buyVenue.Account().Balance([]b.Symbol{o.Pair.Counter})
+	if err != nil {
+		return b.Order{}, b.Order{}, err
+	}
+
+	base, err := sellVenue.Account().Balance([]b.Symbol{o.Pair.Base})
+	if err != nil {
+		return b.Order{}, b.Order{}, err
+	}
+
+	amount := math.Min(o.Amount, math.Min(counter[o.Pair.Counter]/(o.Cost/o.Amount), base[o.Pair.Base]))
+	if amount <= 0 {
+		return b.Order{}, b.Order{}, errors.New("Insufficient balance to execute " + o.String())
+	}
+
+	buy, err := buyVenue.Account().Trade(b.Buy, o.Pair, amount, o.BuyLimit)
+	if err != nil {
+		return b.Order{}, b.Order{}, err
+	}
+
+	sell, err := sellVenue.Account().Trade(b.Sell, o.Pair, amount, o.SellLimit)
+	if err != nil {
+		if cancelErr := buyVenue.Account().CancelOrder(buy); cancelErr != nil {
+			return buy, b.Order{}, fmt.Errorf("Bought on %s but failed to sell on %s: %v, and failed to cancel the buy: %v",
+				o.Buy, o.Sell, err, cancelErr)
+		} else if buy.Remains < buy.Amount {
+			return buy, b.Order{}, fmt.Errorf("Bought %.8f on %s but failed to sell on %s: %v, cancelled the rest of the buy",
+				buy.Amount-buy.Remains, o.Buy, o.Sell, err)
+		}
+		return b.Order{}, b.Order{}, fmt.Errorf("Failed to sell on %s, cancelled the buy on %s: %v", o.Sell, o.Buy, err)
+	}
+
+	return buy, sell, nil
+}
+
+// returns the order book of a venue, falling back to the top of book from
+// market data with max_amount available when there's no order book
+func (s *Scanner) book(venue Venue, pair b.Pair) (b.OrderBook, error) {
+	book, err := venue.Exchange.Account().OrderBook(pair, s.depth)
+	if err == nil {
+		return book, nil
+	} else if s.maxAmount <= 0 {
+		return book, err
+	}
+
+	data, err := venue.Exchange.MarketData(pair)
+	if err != nil {
+		return book, err
+	}
+
+	book = b.OrderBook{}
+	if data.Sell > 0 {
+		book.Asks = append(book.Asks, b.Level{Price: data.Sell, Amount: s.maxAmount})
+	}
+	if data.Buy > 0 {
+		book.Bids = append(book.Bids, b.Level{Price: data.Buy, Amount: s.maxAmount})
+	}
+	return book, nil
+}
+
+// returns the fee for a venue, from config first and then the venue
+func (s *Scanner) fee(venue Venue, pair b.Pair) float64 {
+	if fee, ok := s.fees[venue.Name]; ok {
+		return fee
+	}
+	if schedule, ok := venue.Exchange.(b.FeeSchedule); ok {
+		if fee, err := schedule.Fee(pair); err == nil {
+			return fee
+		}
+	}
+	return s.defaultFee
+}
+
+func (s *Scanner) venue(name string) b.Exchange {
+	for _, venue := range s.venues {
+		if venue.Name == name {
+			return venue.Exchange
+		}
+	}
+	return nil
+}
+
+// walks the asks of one book and the bids of another while buying and
+// selling is profitable after fees
+func match(buyBook b.OrderBook, sellBook b.OrderBook, buyFee float64, sellFee float64, maxAmount float64) Opportunity {
+	asks := buyBook.Sorted().Asks
+	bids := sellBook.Sorted().Bids
+
+	o := Opportunity{}
+	i, j := 0, 0
+	askRemains, bidRemains := 0.0, 0.0
+	if len(asks) > 0 && len(bids) > 0 {
+		askRemains, bidRemains = asks[0].Amount, bids[0].Amount
+	}
+
+	for i < len(asks) && j < len(bids) {
+		cost := asks[i].Price * (1 + buyFee)
+		proceeds := bids[j].Price * (1 - sellFee)
+		if proceeds <= cost {
+			break
+		}
+
+		amount := math.Min(askRemains, bidRemains)
+		if maxAmount > 0 {
+			amount = math.Min(amount, maxAmount-o.Amount)
+		}
+		if amount <= 0 {
+			break
+		}
+
+		o.Amount += amount
+		o.Cost += amount * cost
+		o.Proceeds += amount * proceeds
+		o.BuyPrice += amount * asks[i].Price
+		o.SellPrice += amount * bids[j].Price
+		o.BuyLimit, o.SellLimit = asks[i].Price, bids[j].Price
+
+		if askRemains -= amount; askRemains <= 0 {
+			if i++; i < len(asks) {
+				askRemains = asks[i].Amount
+			}
+		}
+		if bidRemains -= amount; bidRemains <= 0 {
+			if j++; j < len(bids) {
+				bidRemains = bids[j].Amount
+			}
+		}
+	}
+
+	if o.Amount > 0 {
+		o.BuyPrice /= o.Amount
+		o.SellPrice /= o.Amount
+		o.Profit = o.Proceeds - o.Cost
+		o.Return = o.Profit / o.Cost
+	}
+	return o
+}
+
+type byProfit []Opportunity
+
+func (o byProfit) Len() int           { return len(o) }
+func (o byProfit) Less(i, j int) bool { return o[i].Profit > o[j].Profit }
+func (o byProfit) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
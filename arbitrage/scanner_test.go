@@ -0,0 +1,139 @@
+package arbitrage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a venue whose driver doesn't implement order books
+type noBook struct {
+	*fake.Driver
+}
+
+func (n noBook) Account() babel.ExchangeAccount {
+	return n
+}
+
+func (n noBook) OrderBook(pair babel.Pair, limit int) (babel.OrderBook, error) {
+	panic("Not implemented")
+}
+
+func TestScannerSpec(t *testing.T) {
+	Convey("Subject: Arbitrage Scanner", t, func() {
+		cheap := fake.New("cheap", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 1000},
+		}).(*fake.Driver)
+		cheap.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{101, 1}, {100, 1}},
+			Bids: []babel.Level{{99, 1}},
+		})
+
+		dear := fake.New("dear", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.BTC: 1},
+		}).(*fake.Driver)
+		dear.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{104, 1}},
+			Bids: []babel.Level{{103, 1.5}, {100, 5}},
+		})
+
+		venues := []Venue{{"cheap", cheap}, {"dear", dear}}
+
+		Convey(`Opportunities should be limited by the available depth`, func() {
+			scanner := NewScanner(venues, map[string]interface{}{"fee": 0.0})
+			opportunities, err := scanner.Scan(babel.BTC_USD)
+			So(err, ShouldBeNil)
+			So(len(opportunities), ShouldEqual, 1)
+
+			o := opportunities[0]
+			So(o.Buy, ShouldEqual, "cheap")
+			So(o.Sell, ShouldEqual, "dear")
+			So(o.Amount, ShouldEqual, 1.5)
+			So(o.BuyLimit, ShouldEqual, 101)
+			So(o.SellPrice, ShouldEqual, 103)
+			So(o.Profit, ShouldAlmostEqual, 4)
+		})
+
+		Convey(`Fees should reduce the opportunity`, func() {
+			scanner := NewScanner(venues, map[string]interface{}{
+				"fees": map[string]float64{"cheap": 0.01, "dear": 0.01},
+			})
+			opportunities, _ := scanner.Scan(babel.BTC_USD)
+			So(len(opportunities), ShouldEqual, 1)
+			So(opportunities[0].Amount, ShouldEqual, 1)
+			So(opportunities[0].Profit, ShouldAlmostEqual, 0.97)
+
+			scanner = NewScanner(venues, map[string]interface{}{"fee": 0.0, "min_return": 0.05})
+			opportunities, _ = scanner.Scan(babel.BTC_USD)
+			So(len(opportunities), ShouldEqual, 0)
+		})
+
+		Convey(`Venue fee schedules should be used`, func() {
+			feeVenue := fake.New("fee", map[string]interface{}{"fee": 0.05}).(*fake.Driver)
+			feeVenue.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Bids: []babel.Level{{103, 1}},
+			})
+
+			scanner := NewScanner([]Venue{{"cheap", cheap}, {"fee", feeVenue}}, map[string]interface{}{"fee": 0.0})
+			opportunities, _ := scanner.Scan(babel.BTC_USD)
+			So(len(opportunities), ShouldEqual, 0)
+		})
+
+		Convey(`Executing should place both legs within balances`, func() {
+			scanner := NewScanner(venues, map[string]interface{}{"fee": 0.0})
+			opportunities, _ := scanner.Scan(babel.BTC_USD)
+
+			buy, sell, err := scanner.Execute(opportunities[0])
+			So(err, ShouldBeNil)
+			So(buy.Amount, ShouldEqual, 1)
+			So(sell.Amount, ShouldEqual, 1)
+
+			balances, _ := cheap.Balance([]babel.Symbol{babel.USD, babel.BTC})
+			So(balances[babel.USD], ShouldEqual, 900)
+			So(balances[babel.BTC], ShouldEqual, 1)
+
+			balances, _ = dear.Balance([]babel.Symbol{babel.USD, babel.BTC})
+			So(balances[babel.USD], ShouldEqual, 103)
+			So(balances[babel.BTC], ShouldEqual, 0)
+		})
+
+		Convey(`Venues that panic fetching books should be skipped`, func() {
+			broken := noBook{fake.New("broken", map[string]interface{}{}).(*fake.Driver)}
+			scanner := NewScanner(append(venues, Venue{"broken", broken}), map[string]interface{}{"fee": 0.0})
+
+			opportunities, err := scanner.Scan(babel.BTC_USD)
+			So(err, ShouldBeNil)
+			So(len(opportunities), ShouldEqual, 1)
+		})
+
+		Convey(`A buy should be cancelled if the sell fails`, func() {
+			scanner := NewScanner(venues, map[string]interface{}{"fee": 0.0})
+			dear.FailNext("Trade", errors.New("Exchange unavailable"))
+
+			o := Opportunity{Pair: babel.BTC_USD, Buy: "cheap", Sell: "dear", Amount: 1,
+				Cost: 95, BuyLimit: 95, SellLimit: 103}
+			buy, _, err := scanner.Execute(o)
+			So(err, ShouldNotBeNil)
+			So(buy.Id, ShouldEqual, "")
+
+			orders, _ := cheap.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+			balances, _ := cheap.Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1000)
+		})
+
+		Convey(`Stopping should end a watch`, func() {
+			scanner := NewScanner(venues, map[string]interface{}{"fee": 0.0})
+			done := make(chan error)
+			go func() { done <- scanner.Watch(babel.BTC_USD, time.Hour, make(chan Opportunity, 10)) }()
+
+			scanner.Stop()
+			scanner.Stop()
+			So(<-done, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,333 @@
+package arbitrage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// one trade in a cycle, converting From into To at a rate after fees
+type Leg struct {
+	Pair     b.Pair
+	Type     b.TradeType
+	From, To b.Symbol
+	Price    float64
+	Rate     float64
+}
+
+// a sequence of trades within one exchange that ends in the currency it
+// started with. the amount is in the first currency, limited by the best
+// level of each book, and the return is after fees
+type Cycle struct {
+	Legs   []Leg
+	Amount float64
+	Profit float64
+	Return float64
+	Time   time.Time
+}
+
+func (c *Cycle) String() string {
+	symbols := []string{}
+	for _, leg := range c.Legs {
+		symbols = append(symbols, string(leg.From))
+	}
+	symbols = append(symbols, string(c.Legs[0].From))
+
+	return fmt.Sprintf("%s %.4f %s for %.6f profit (%.2f%%)",
+		strings.Join(symbols, "->"), c.Amount, c.Legs[0].From, c.Profit, c.Return*100)
+}
+
+// finds cycles of three trades within an exchange, like btc->ltc->ftc->btc
+type Triangular struct {
+	exchange    b.Exchange
+	config      map[string]interface{}
+	start       []b.Symbol
+	defaultFee  float64
+	minReturn   float64
+	concurrency int
+	clock       b.Clock
+	stop        chan bool
+	stopped     sync.Once
+}
+
+// an edge in the currency graph
+type edge struct {
+	pair  b.Pair
+	t     b.TradeType
+	to    b.Symbol
+	price float64
+	rate  float64
+}
+
+// creates a detector for an exchange. accepts start as a slice of the
+// symbols that cycles should start from, a default fee, min_return,
+// concurrency for the number of markets fetched at once, and a clock
+func NewTriangular(exchange b.Exchange, config map[string]interface{}) *Triangular {
+	t := &Triangular{
+		exchange:    exchange,
+		config:      config,
+		defaultFee:  b.ConfigFloat(config, "fee", 0.002),
+		minReturn:   b.ConfigFloat(config, "min_return", 0),
+		concurrency: 8,
+		clock:       b.ConfigClock(config),
+		stop:        make(chan bool),
+	}
+
+	if start, ok := config["start"].([]b.Symbol); ok {
+		t.start = start
+	}
+
+	if concurrency, ok := config["concurrency"].(int); ok && concurrency > 0 {
+		t.concurrency = concurrency
+	}
+
+	return t
+}
+
+// builds the currency graph from the exchange's market data, and returns
+// the profitable cycles after checking them against the order books, in
+// order of return
+func (t *Triangular) Scan() ([]Cycle, error) {
+	pairs, err := t.exchange.Pairs()
+	if err != nil {
+		return nil, err
+	}
+
+	data := t.marketData(pairs)
+	if len(data) == 0 && len(pairs) > 0 {
+		return nil, errors.New("No market data for any pair")
+	}
+
+	graph := t.graph(data)
+	candidates := t.cycles(graph)
+
+	cycles := []Cycle{}
+	for _, candidate := range candidates {
+		cycle, err := t.deepen(candidate)
+		if err != nil {
+			log.Printf("Skipping cycle %s: %v", candidate.String(), err)
+			continue
+		}
+		if cycle.Return >= t.minReturn && cycle.Amount > 0 {
+			cycles = append(cycles, cycle)
+		}
+	}
+
+	sort.Sort(byReturn(cycles))
+	return cycles, nil
+}
+
+// scans every interval until Stop, writing cycles to a channel
+func (t *Triangular) Watch(interval time.Duration, channel chan<- Cycle) error {
+	ticker := t.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cycles, err := t.Scan()
+		if err != nil {
+			log.Printf("Failed to scan for cycles: %v", err)
+		}
+		for _, cycle := range cycles {
+			channel <- cycle
+		}
+
+		select {
+		case <-ticker.C():
+		case <-t.stop:
+			return nil
+		}
+	}
+}
+
+// stops a running Watch
+func (t *Triangular) Stop() {
+	t.stopped.Do(func() {
+		close(t.stop)
+	})
+}
+
+// fetches market data for pairs, a few at a time. pairs that fail, or that
+// the driver panics on, are left out
+func (t *Triangular) marketData(pairs []b.Pair) []b.MarketData {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	data := []b.MarketData{}
+	sem := make(chan bool, t.concurrency)
+
+	for _, pair := range pairs {
+		wg.Add(1)
+		sem <- true
+		go func(pair b.Pair) {
+			defer func() { <-sem; wg.Done() }()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Failed to fetch market data for %s: %v", pair.String(), r)
+				}
+			}()
+
+			md, err := t.exchange.MarketData(pair)
+			if err != nil {
+				log.Printf("Failed to fetch market data for %s: %v", pair.String(), err)
+				return
+			}
+
+			mutex.Lock()
+			data = append(data, md)
+			mutex.Unlock()
+		}(pair)
+	}
+
+	wg.Wait()
+	return data
+}
+
+// builds edges from each currency, selling the base at the bid and buying
+// it at the ask
+func (t *Triangular) graph(data []b.MarketData) map[b.Symbol][]edge {
+	graph := map[b.Symbol][]edge{}
+	for _, md := range data {
+		fee := t.fee(md.Pair)
+		if md.Buy > 0 {
+			graph[md.Pair.Base] = append(graph[md.Pair.Base],
+				edge{md.Pair, b.Sell, md.Pair.Counter, md.Buy, md.Buy * (1 - fee)})
+		}
+		if md.Sell > 0 {
+			graph[md.Pair.Counter] = append(graph[md.Pair.Counter],
+				edge{md.Pair, b.Buy, md.Pair.Base, md.Sell, (1 / md.Sell) * (1 - fee)})
+		}
+	}
+	return graph
+}
+
+// returns the cycles of three legs with a rate above one, each cycle is
+// returned once starting from a start symbol, or its lowest symbol
+func (t *Triangular) cycles(graph map[b.Symbol][]edge) []Cycle {
+	cycles := []Cycle{}
+	for a, edges := range graph {
+		for _, ab := range edges {
+			for _, bc := range graph[ab.to] {
+				if bc.to == a {
+					continue
+				}
+				for _, ca := range graph[bc.to] {
+					if ca.to != a {
+						continue
+					}
+
+					symbols := []b.Symbol{a, ab.to, bc.to}
+					if !t.isStart(a, symbols) {
+						continue
+					}
+
+					rate := ab.rate * bc.rate * ca.rate
+					if rate <= 1 {
+						continue
+					}
+
+					cycles = append(cycles, Cycle{
+						Legs:   []Leg{leg(a, ab), leg(ab.to, bc), leg(bc.to, ca)},
+						Return: rate - 1,
+						Time:   t.clock.Now(),
+					})
+				}
+			}
+		}
+	}
+	return cycles
+}
+
+// returns true if a cycle of symbols should be reported from symbol
+func (t *Triangular) isStart(symbol b.Symbol, symbols []b.Symbol) bool {
+	for _, start := range t.start {
+		for _, s := range symbols {
+			if s == start {
+				return symbol == start
+			}
+		}
+	}
+
+	for _, s := range symbols {
+		if s < symbol {
+			return false
+		}
+	}
+	return true
+}
+
+// reprices a cycle from the best level of each order book, and limits
+// the amount to what those levels can fill. drivers without order books
+// may panic, which is returned as an error
+func (t *Triangular) deepen(cycle Cycle) (deepened Cycle, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			deepened, err = cycle, fmt.Errorf("%v", r)
+		}
+	}()
+
+	rate, amount := 1.0, math.Inf(1)
+
+	// the legs are shared with the cycle that was passed in
+	legs := append([]Leg{}, cycle.Legs...)
+	for i, leg := range legs {
+		book, err := t.exchange.Account().OrderBook(leg.Pair, 1)
+		if err != nil {
+			return cycle, err
+		}
+
+		// capacity is in the currency being spent on the leg
+		fee, capacity := t.fee(leg.Pair), 0.0
+		if leg.Type == b.Sell {
+			bid, ok := book.BestBid()
+			if !ok {
+				return cycle, errors.New("No bids for " + leg.Pair.String())
+			}
+			leg.Price = bid.Price
+			leg.Rate = leg.Price * (1 - fee)
+			capacity = bid.Amount
+		} else {
+			ask, ok := book.BestAsk()
+			if !ok {
+				return cycle, errors.New("No asks for " + leg.Pair.String())
+			}
+			leg.Price = ask.Price
+			leg.Rate = (1 / leg.Price) * (1 - fee)
+			capacity = ask.Amount * leg.Price
+		}
+
+		amount = math.Min(amount, capacity/rate)
+		rate *= leg.Rate
+		legs[i] = leg
+	}
+
+	cycle.Legs = legs
+	cycle.Amount = amount
+	cycle.Return = rate - 1
+	cycle.Profit = amount * cycle.Return
+	return cycle, nil
+}
+
+func (t *Triangular) fee(pair b.Pair) float64 {
+	if schedule, ok := t.exchange.(b.FeeSchedule); ok {
+		if fee, err := schedule.Fee(pair); err == nil {
+			return fee
+		}
+	}
+	return t.defaultFee
+}
+
+func leg(from b.Symbol, e edge) Leg {
+	return Leg{Pair: e.pair, Type: e.t, From: from, To: e.to, Price: e.price, Rate: e.rate}
+}
+
+type byReturn []Cycle
+
+func (c byReturn) Len() int           { return len(c) }
+func (c byReturn) Less(i, j int) bool { return c[i].Return > c[j].Return }
+func (c byReturn) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,109 @@
+package arbitrage
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a driver that doesn't implement market data
+type noMarkets struct {
+	*fake.Driver
+}
+
+func (n noMarkets) MarketData(pair babel.Pair) (babel.MarketData, error) {
+	panic("Not implemented")
+}
+
+func TestTriangularSpec(t *testing.T) {
+	Convey("Subject: Triangular Arbitrage", t, func() {
+		exchange := fake.New("fake", map[string]interface{}{
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 100, Sell: 101},
+				{Pair: babel.LTC_USD, Buy: 10, Sell: 10.1},
+				{Pair: babel.LTC_BTC, Buy: 0.12, Sell: 0.121},
+			},
+		}).(*fake.Driver)
+		exchange.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Bids: []babel.Level{{100, 2}},
+			Asks: []babel.Level{{101, 2}},
+		})
+		exchange.SetOrderBook(babel.LTC_USD, babel.OrderBook{
+			Bids: []babel.Level{{10, 5}},
+			Asks: []babel.Level{{10.1, 5}},
+		})
+		exchange.SetOrderBook(babel.LTC_BTC, babel.OrderBook{
+			Bids: []babel.Level{{0.12, 30}},
+			Asks: []babel.Level{{0.121, 30}},
+		})
+
+		Convey(`Profitable cycles should be found once and limited by depth`, func() {
+			cycles, err := NewTriangular(exchange, map[string]interface{}{}).Scan()
+			So(err, ShouldBeNil)
+			So(len(cycles), ShouldEqual, 1)
+
+			c := cycles[0]
+			So(c.Legs[0].From, ShouldEqual, babel.BTC)
+			So(c.Legs[0].Type, ShouldEqual, babel.Sell)
+			So(c.Legs[1].Type, ShouldEqual, babel.Buy)
+			So(c.Legs[2].To, ShouldEqual, babel.BTC)
+			So(c.Return, ShouldAlmostEqual, 100/10.1*0.12-1)
+			So(c.Amount, ShouldAlmostEqual, 0.505)
+		})
+
+		Convey(`Cycles should start from the start symbols`, func() {
+			cycles, _ := NewTriangular(exchange, map[string]interface{}{
+				"start": []babel.Symbol{babel.USD},
+			}).Scan()
+			So(len(cycles), ShouldEqual, 1)
+			So(cycles[0].Legs[0].From, ShouldEqual, babel.USD)
+			So(cycles[0].Amount, ShouldAlmostEqual, 50.5)
+		})
+
+		Convey(`Repricing a cycle shouldn't change the cycle it came from`, func() {
+			candidate := Cycle{Legs: []Leg{
+				{Pair: babel.BTC_USD, Type: babel.Sell, From: babel.BTC, To: babel.USD, Price: 1, Rate: 1},
+			}}
+			cycle, err := NewTriangular(exchange, map[string]interface{}{}).deepen(candidate)
+			So(err, ShouldBeNil)
+			So(cycle.Legs[0].Price, ShouldEqual, 100)
+			So(candidate.Legs[0].Price, ShouldEqual, 1)
+		})
+
+		Convey(`Fees should remove unprofitable cycles`, func() {
+			expensive := fake.New("fake", map[string]interface{}{
+				"fee": 0.1,
+				"market_data": []babel.MarketData{
+					{Pair: babel.BTC_USD, Buy: 100, Sell: 101},
+					{Pair: babel.LTC_USD, Buy: 10, Sell: 10.1},
+					{Pair: babel.LTC_BTC, Buy: 0.12, Sell: 0.121},
+				},
+			})
+			cycles, err := NewTriangular(expensive, map[string]interface{}{}).Scan()
+			So(err, ShouldBeNil)
+			So(len(cycles), ShouldEqual, 0)
+		})
+
+		Convey(`Drivers that panic should fail the scan instead of crashing`, func() {
+			cycles, err := NewTriangular(noBook{exchange}, map[string]interface{}{}).Scan()
+			So(err, ShouldBeNil)
+			So(len(cycles), ShouldEqual, 0)
+
+			_, err = NewTriangular(noMarkets{exchange}, map[string]interface{}{}).Scan()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Stopping should end a watch`, func() {
+			triangular := NewTriangular(exchange, map[string]interface{}{})
+			done := make(chan error)
+			go func() { done <- triangular.Watch(time.Hour, make(chan Cycle, 10)) }()
+
+			triangular.Stop()
+			triangular.Stop()
+			So(<-done, ShouldBeNil)
+		})
+	})
+}
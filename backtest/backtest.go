@@ -0,0 +1,163 @@
+/*
+Backtesting of trading strategies against historical trades.
+
+Trades from an exchange's TradeHistory are replayed in order through a
+simulated exchange, which uses the trade timestamps as its clock. The
+strategy trades through the simulated exchange's account, with fees
+and order latency modelled, and a Report of the results is returned.
+*/
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Config struct {
+	Pair     b.Pair
+	Balances map[b.Symbol]float64
+	Fee      float64
+	Latency  time.Duration
+
+	// the period that returns are sampled over for the sharpe ratio
+	Interval time.Duration
+}
+
+// the results of a backtest, amounts are in the counter currency of the pair
+type Report struct {
+	Pair                   b.Pair
+	Start, End             time.Time
+	StartEquity, EndEquity float64
+	PnL, Return            float64
+	MaxDrawdown            float64
+	Sharpe                 float64
+	Balances               map[b.Symbol]float64
+	Trades                 []b.Trade
+}
+
+type Backtest struct {
+	config   Config
+	strategy Strategy
+	exchange *Exchange
+}
+
+// creates a backtest of a strategy
+func New(config Config, strategy Strategy) *Backtest {
+	if config.Interval == 0 {
+		config.Interval = 24 * time.Hour
+	}
+
+	return &Backtest{
+		config:   config,
+		strategy: strategy,
+		exchange: newExchange(config),
+	}
+}
+
+// returns the simulated exchange the strategy trades against
+func (bt *Backtest) Exchange() *Exchange {
+	return bt.exchange
+}
+
+// replays trades from the channel until it's closed, trades must be in
+// timestamp order and trades for other pairs are ignored
+func (bt *Backtest) Run(trades <-chan b.Trade) (*Report, error) {
+	ex := bt.exchange
+	report := &Report{Pair: bt.config.Pair}
+
+	var peak, sample float64
+	var nextSample time.Time
+	returns := []float64{}
+
+	for trade := range trades {
+		if trade.Pair != bt.config.Pair {
+			continue
+		} else if trade.Timestamp.Before(ex.now) {
+			return nil, fmt.Errorf("Trade %s is out of order", trade.String())
+		}
+
+		ex.Step(trade)
+		if report.Start.IsZero() {
+			report.Start, report.StartEquity = trade.Timestamp, ex.equity()
+			peak, sample = report.StartEquity, report.StartEquity
+			nextSample = trade.Timestamp.Add(bt.config.Interval)
+		}
+
+		bt.strategy.OnTrade(trade, ex)
+		equity := ex.equity()
+
+		if equity > peak {
+			peak = equity
+		} else if peak > 0 && (peak-equity)/peak > report.MaxDrawdown {
+			report.MaxDrawdown = (peak - equity) / peak
+		}
+
+		for !trade.Timestamp.Before(nextSample) {
+			if sample != 0 {
+				returns = append(returns, equity/sample-1)
+			}
+			sample = equity
+			nextSample = nextSample.Add(bt.config.Interval)
+		}
+
+		report.End, report.EndEquity = trade.Timestamp, equity
+	}
+
+	if report.Start.IsZero() {
+		return nil, errors.New("No trades for " + bt.config.Pair.String())
+	}
+
+	report.PnL = report.EndEquity - report.StartEquity
+	if report.StartEquity != 0 {
+		report.Return = report.PnL / report.StartEquity
+	}
+	report.Sharpe = sharpe(returns, bt.config.Interval)
+	report.Balances, _ = ex.Balance([]b.Symbol{})
+	report.Trades = append([]b.Trade{}, ex.fills...)
+
+	return report, nil
+}
+
+// the annualized sharpe ratio of periodic returns, assuming no risk free rate
+func sharpe(returns []float64, interval time.Duration) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+
+	var mean, variance float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(returns) - 1)
+
+	if variance == 0 {
+		return 0
+	}
+
+	periods := float64(365*24*time.Hour) / float64(interval)
+	return mean / math.Sqrt(variance) * math.Sqrt(periods)
+}
+
+// returns a summary of the report
+func (r *Report) String() string {
+	lines := []string{
+		fmt.Sprintf("Pair:         %s", r.Pair.String()),
+		fmt.Sprintf("Period:       %s to %s", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339)),
+		fmt.Sprintf("Equity:       %.4f => %.4f %s", r.StartEquity, r.EndEquity, r.Pair.Counter),
+		fmt.Sprintf("PnL:          %.4f %s (%.2f%%)", r.PnL, r.Pair.Counter, r.Return*100),
+		fmt.Sprintf("Max Drawdown: %.2f%%", r.MaxDrawdown*100),
+		fmt.Sprintf("Sharpe:       %.4f", r.Sharpe),
+		fmt.Sprintf("Trades:       %d", len(r.Trades)),
+	}
+	return strings.Join(lines, "\n")
+}
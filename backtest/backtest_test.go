@@ -0,0 +1,147 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// buys with the entire balance on the first trade
+type buyAndHold struct {
+	bought bool
+}
+
+func (s *buyAndHold) OnTrade(trade babel.Trade, exchange babel.Exchange) {
+	if !s.bought {
+		exchange.Account().Trade(babel.Buy, trade.Pair, -1, -1)
+		s.bought = true
+	}
+}
+
+// places a single limit order on the first trade
+type limitStrategy struct {
+	t      babel.TradeType
+	amount float64
+	rate   float64
+	placed bool
+}
+
+func (s *limitStrategy) OnTrade(trade babel.Trade, exchange babel.Exchange) {
+	if !s.placed {
+		exchange.Account().Trade(s.t, trade.Pair, s.amount, s.rate)
+		s.placed = true
+	}
+}
+
+func replay(prices ...float64) <-chan babel.Trade {
+	channel := make(chan babel.Trade, len(prices))
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, price := range prices {
+		channel <- babel.Trade{
+			Pair:      babel.BTC_USD,
+			Amount:    1,
+			Rate:      price,
+			Timestamp: start.Add(time.Duration(i) * time.Hour),
+		}
+	}
+	close(channel)
+	return channel
+}
+
+func TestBacktestSpec(t *testing.T) {
+	Convey("Subject: Backtest", t, func() {
+		config := Config{
+			Pair:     babel.BTC_USD,
+			Balances: map[babel.Symbol]float64{babel.USD: 1000},
+			Interval: time.Hour,
+		}
+
+		Convey(`Buy and hold should track the price`, func() {
+			report, err := New(config, &buyAndHold{}).Run(replay(100, 120, 90, 150))
+			So(err, ShouldBeNil)
+			So(len(report.Trades), ShouldEqual, 1)
+			So(report.Balances[babel.BTC], ShouldEqual, 10)
+			So(report.PnL, ShouldEqual, 500)
+			So(report.MaxDrawdown, ShouldEqual, 0.25)
+			So(report.Sharpe, ShouldBeGreaterThan, 0)
+		})
+
+		Convey(`Fees should reduce the amount bought`, func() {
+			config.Fee = 0.01
+			report, _ := New(config, &buyAndHold{}).Run(replay(100, 100))
+			So(report.Balances[babel.USD], ShouldAlmostEqual, 0, 0.0001)
+			So(report.PnL, ShouldBeLessThan, 0)
+		})
+
+		Convey(`Limit orders should fill when trades cross them`, func() {
+			strategy := &limitStrategy{babel.Buy, 2, 95, false}
+
+			bt := New(config, strategy)
+			report, _ := bt.Run(replay(100, 98, 94, 99))
+			So(len(report.Trades), ShouldEqual, 1)
+			So(report.Trades[0].Rate, ShouldEqual, 95)
+			So(report.Trades[0].Timestamp.Hour(), ShouldEqual, 2)
+			So(report.Balances[babel.USD], ShouldEqual, 810)
+
+			orders, _ := bt.Exchange().Account().Orders(0)
+			So(len(orders), ShouldEqual, 1)
+			So(orders[0].Remains, ShouldEqual, 1)
+		})
+
+		Convey(`Latency should delay orders reaching the market`, func() {
+			config.Latency = 90 * time.Minute
+			report, _ := New(config, &buyAndHold{}).Run(replay(100, 110, 125))
+			So(report.Trades[0].Rate, ShouldEqual, 125)
+		})
+
+		Convey(`Pending market orders should reserve their funds`, func() {
+			config.Latency = 90 * time.Minute
+			config.Balances[babel.BTC] = 1
+			bt := New(config, &limitStrategy{babel.Sell, 1, -1, false})
+			bt.Run(replay(100))
+
+			account := bt.Exchange().Account()
+			_, err := account.Trade(babel.Sell, babel.BTC_USD, 1, -1)
+			So(err, ShouldNotBeNil)
+
+			orders, _ := account.Orders(0)
+			So(account.CancelOrder(orders[0]), ShouldBeNil)
+			balances, _ := account.Balance([]babel.Symbol{babel.BTC})
+			So(balances[babel.BTC], ShouldEqual, 1)
+		})
+
+		Convey(`Resting orders should reserve their funds until cancelled`, func() {
+			bt := New(config, &limitStrategy{babel.Buy, 8, 90, false})
+			bt.Run(replay(100, 95))
+
+			account := bt.Exchange().Account()
+			_, err := account.Trade(babel.Buy, babel.BTC_USD, 4, 90)
+			So(err, ShouldNotBeNil)
+
+			orders, _ := account.Orders(0)
+			So(account.CancelOrder(orders[0]), ShouldBeNil)
+			balances, _ := account.Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1000)
+		})
+
+		Convey(`Invalid rates should be rejected`, func() {
+			bt := New(config, &buyAndHold{})
+			bt.Run(replay(100))
+
+			_, err := bt.Exchange().Account().Trade(babel.Buy, babel.BTC_USD, 1, 0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Out of order trades should fail`, func() {
+			channel := make(chan babel.Trade, 2)
+			channel <- babel.Trade{Pair: babel.BTC_USD, Amount: 1, Rate: 1, Timestamp: time.Unix(100, 0)}
+			channel <- babel.Trade{Pair: babel.BTC_USD, Amount: 1, Rate: 1, Timestamp: time.Unix(50, 0)}
+			close(channel)
+
+			_, err := New(config, &buyAndHold{}).Run(channel)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
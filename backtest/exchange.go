@@ -0,0 +1,382 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/matching"
+)
+
+// a simulated exchange for a single pair, driven by historical trades.
+// time only moves forward as trades are replayed through Step
+type Exchange struct {
+	pair     b.Pair
+	now      time.Time
+	last     b.MarketData
+	window   []b.Trade
+	volume   float64
+	balances map[b.Symbol]float64
+	reserved map[b.Symbol]float64
+	quotes   map[string]float64
+	engine   *matching.Engine
+	pending  []b.Order
+	fills    []b.Trade
+	fee      float64
+	latency  time.Duration
+	nextId   int
+}
+
+func newExchange(config Config) *Exchange {
+	e := &Exchange{
+		pair:     config.Pair,
+		balances: map[b.Symbol]float64{},
+		reserved: map[b.Symbol]float64{},
+		quotes:   map[string]float64{},
+		engine:   matching.New("backtest", config.Pair),
+		fee:      config.Fee,
+		latency:  config.Latency,
+	}
+
+	for symbol, amount := range config.Balances {
+		e.balances[symbol] = amount
+	}
+
+	return e
+}
+
+// returns the current simulated time
+func (e *Exchange) Now() time.Time {
+	return e.now
+}
+
+// advances the simulation to a historical trade, activating orders whose
+// latency has elapsed and filling resting orders the trade crosses
+func (e *Exchange) Step(trade b.Trade) {
+	e.now = trade.Timestamp
+	e.activate(trade.Rate)
+	e.matchTrade(trade)
+
+	// volume is over a rolling 24 hours, like most exchange tickers
+	e.window = append(e.window, trade)
+	e.volume += trade.Amount
+	cutoff := 0
+	for cutoff < len(e.window) && e.window[cutoff].Timestamp.Before(e.now.Add(-24*time.Hour)) {
+		e.volume -= e.window[cutoff].Amount
+		cutoff++
+	}
+	e.window = e.window[cutoff:]
+
+	e.last = b.MarketData{
+		Pair:    e.pair,
+		Buy:     trade.Rate,
+		Sell:    trade.Rate,
+		Last:    trade.Rate,
+		Volume:  e.volume,
+		Updated: trade.Timestamp,
+	}
+}
+
+// submits orders to the market once the latency has passed
+func (e *Exchange) activate(price float64) {
+	if price <= 0 {
+		return
+	}
+
+	pending := []b.Order{}
+	for _, order := range e.pending {
+		if order.Timestamp.Add(e.latency).After(e.now) {
+			pending = append(pending, order)
+			continue
+		}
+
+		// market and marketable orders take liquidity at the current price
+		if order.Rate == -1 {
+			e.reserve(order, -order.Remains)
+			delete(e.quotes, order.Id)
+			amount := order.Remains
+			if order.Type == b.Buy && e.balances[order.Pair.Counter] < amount*price {
+				amount = e.balances[order.Pair.Counter] / price
+				log.Printf("Order %s reduced to %.8f, insufficient %s balance at %.8f",
+					order.Id, amount, order.Pair.Counter, price)
+			}
+			if amount > 0 {
+				e.fill(order, price, amount)
+			}
+			continue
+		} else if crosses(order.Type, price, order.Rate) {
+			e.reserve(order, -order.Remains)
+			e.fill(order, price, order.Remains)
+			continue
+		}
+
+		fills, _, err := e.engine.Submit(order)
+		if err != nil {
+			log.Printf("Dropping order %s: %v", order.Id, err)
+			e.reserve(order, -order.Remains)
+			continue
+		}
+
+		// orders of the account can only match each other
+		for _, f := range fills {
+			e.reserve(f.Maker, -f.Amount)
+			e.fill(f.Maker, f.Rate, f.Amount)
+			e.reserve(f.Taker, -f.Amount)
+			e.fill(f.Taker, f.Rate, f.Amount)
+		}
+	}
+	e.pending = pending
+}
+
+// fills resting orders that a historical trade would have taken, trades
+// without a type could have been either side so both are checked
+func (e *Exchange) matchTrade(trade b.Trade) {
+	sides := []b.TradeType{trade.Type}
+	if trade.Type != b.Buy && trade.Type != b.Sell {
+		sides = []b.TradeType{b.Buy, b.Sell}
+	}
+
+	for _, t := range sides {
+		e.nextId++
+		fills, taker, err := e.engine.Submit(b.Order{
+			Id:        "public-" + strconv.Itoa(e.nextId),
+			Pair:      e.pair,
+			Type:      t,
+			Timestamp: e.now,
+			Amount:    trade.Amount,
+			Rate:      trade.Rate,
+		})
+		if err != nil {
+			log.Printf("Skipping trade %s: %v", trade.Id, err)
+			return
+		}
+		if taker.Remains > 0 {
+			e.engine.Cancel(taker.Id)
+		}
+		for _, f := range fills {
+			e.reserve(f.Maker, -f.Amount)
+			e.fill(f.Maker, f.Rate, f.Amount)
+		}
+	}
+}
+
+// moves the funds for amount of an order out of the balance while it's
+// pending or resting, or back into it when amount is negative. market
+// orders are reserved at the price they were submitted at
+func (e *Exchange) reserve(order b.Order, amount float64) {
+	rate := order.Rate
+	if rate == -1 {
+		rate = e.quotes[order.Id]
+	}
+
+	if order.Type == b.Buy {
+		e.balances[order.Pair.Counter] -= amount * rate
+		e.reserved[order.Pair.Counter] += amount * rate
+	} else {
+		e.balances[order.Pair.Base] -= amount
+		e.reserved[order.Pair.Base] += amount
+	}
+}
+
+// executes amount of an order at a price, updating balances and fills
+func (e *Exchange) fill(order b.Order, price float64, amount float64) {
+	fee := amount * price * e.fee
+	if order.Type == b.Buy {
+		e.balances[order.Pair.Counter] -= amount * price
+		e.balances[order.Pair.Base] += amount - amount*e.fee
+	} else {
+		e.balances[order.Pair.Base] -= amount
+		e.balances[order.Pair.Counter] += amount*price - fee
+	}
+
+	e.fills = append(e.fills, b.Trade{
+		Id:        fmt.Sprintf("%s-%d", order.Id, len(e.fills)),
+		Pair:      order.Pair,
+		Amount:    amount,
+		Rate:      price,
+		Timestamp: e.now,
+		Type:      order.Type,
+		Exchange:  "backtest",
+	})
+}
+
+// the value of the account in the counter currency at the last price,
+// including funds reserved by open orders
+func (e *Exchange) equity() float64 {
+	counter := e.balances[e.pair.Counter] + e.reserved[e.pair.Counter]
+	base := e.balances[e.pair.Base] + e.reserved[e.pair.Base]
+	return counter + base*e.last.Last
+}
+
+func (e *Exchange) MarketData(pair b.Pair) (b.MarketData, error) {
+	if pair != e.pair {
+		return b.MarketData{}, errors.New("Unknown pair " + pair.String())
+	} else if e.last.Updated.IsZero() {
+		return b.MarketData{}, errors.New("No market data yet for " + pair.String())
+	}
+	return e.last, nil
+}
+
+func (e *Exchange) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
+	return errors.New("Ticker isn't available in a backtest")
+}
+
+func (e *Exchange) Pairs() ([]b.Pair, error) {
+	return []b.Pair{e.pair}, nil
+}
+
+// returns the trades in the last 24 hours of the simulation
+func (e *Exchange) TradeHistory(pairs []b.Pair, after time.Time, limit int, channel chan<- b.Trade) error {
+	trades := []b.Trade{}
+	if b.ContainsPair(e.pair, pairs) {
+		for _, trade := range e.window {
+			if trade.Timestamp.After(after) {
+				trades = append(trades, trade)
+			}
+		}
+	}
+
+	if limit > 0 && len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+
+	go func() {
+		for _, trade := range trades {
+			channel <- trade
+		}
+		close(channel)
+	}()
+
+	return nil
+}
+
+func (e *Exchange) Account() b.ExchangeAccount {
+	return e
+}
+
+func (e *Exchange) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	balances := map[b.Symbol]float64{}
+	for symbol, amount := range e.balances {
+		if len(symbols) == 0 || b.ContainsSymbol(symbol, symbols) {
+			balances[symbol] = amount
+		}
+	}
+	return balances, nil
+}
+
+func (e *Exchange) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	if pair != e.pair {
+		return b.Order{}, errors.New("Unknown pair " + pair.String())
+	} else if t != b.Buy && t != b.Sell {
+		return b.Order{}, errors.New("Unknown trade type " + string(t))
+	} else if rate <= 0 && rate != -1 {
+		return b.Order{}, errors.New("Invalid order rate")
+	}
+
+	price := rate
+	if rate == -1 {
+		price = e.last.Last
+	}
+
+	if amount == -1 {
+		if t == b.Sell {
+			amount = e.balances[pair.Base]
+		} else if price > 0 {
+			amount = e.balances[pair.Counter] / price
+		}
+	}
+
+	if amount <= 0 {
+		return b.Order{}, errors.New("Invalid order amount")
+	} else if t == b.Buy && e.balances[pair.Counter] < amount*price {
+		return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Counter)
+	} else if t == b.Sell && e.balances[pair.Base] < amount {
+		return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Base)
+	}
+
+	e.nextId++
+	order := b.Order{
+		Id:        strconv.Itoa(e.nextId),
+		Pair:      pair,
+		Type:      t,
+		Timestamp: e.now,
+		Amount:    amount,
+		Remains:   amount,
+		Rate:      rate,
+	}
+
+	if rate == -1 {
+		e.quotes[order.Id] = price
+	}
+	e.reserve(order, order.Remains)
+	e.pending = append(e.pending, order)
+	if e.latency == 0 {
+		e.activate(e.last.Last)
+		if resting, ok := e.engine.Order(order.Id); ok {
+			order = resting
+		} else if len(e.pending) == 0 || e.pending[len(e.pending)-1].Id != order.Id {
+			order.Remains = 0
+		}
+	}
+
+	return order, nil
+}
+
+func (e *Exchange) Orders(limit int) ([]b.Order, error) {
+	orders := append(append([]b.Order{}, e.pending...), e.engine.Orders()...)
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (e *Exchange) CancelOrder(order b.Order) error {
+	for i, o := range e.pending {
+		if o.Id == order.Id {
+			e.reserve(o, -o.Remains)
+			delete(e.quotes, o.Id)
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			return nil
+		}
+	}
+
+	cancelled, err := e.engine.Cancel(order.Id)
+	if err == nil {
+		e.reserve(cancelled, -cancelled.Remains)
+	}
+	return err
+}
+
+// returns the simulated fills of one of the account's orders
+func (e *Exchange) OrderFills(id string) ([]b.Trade, error) {
+	return b.FillsOf(id, e.fills), nil
+}
+
+// returns the funds held back from the balances by pending and resting orders
+func (e *Exchange) Reserved() (map[b.Symbol]float64, error) {
+	reserved := map[b.Symbol]float64{}
+	for symbol, amount := range e.reserved {
+		reserved[symbol] = amount
+	}
+	return reserved, nil
+}
+
+func (e *Exchange) Transactions(limit int) ([]b.Transaction, error) {
+	return []b.Transaction{}, nil
+}
+
+// returns the book of the account's own resting orders
+func (e *Exchange) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	return e.engine.Book(limit), nil
+}
+
+// returns true if a trade at price would fill an order at rate
+func crosses(t b.TradeType, price float64, rate float64) bool {
+	if t == b.Buy {
+		return price <= rate
+	}
+	return price >= rate
+}
@@ -0,0 +1,12 @@
+package backtest
+
+import (
+	b "github.com/lox/babelcoin/core"
+)
+
+// a trading strategy that can be backtested, see strategy.Runtime for
+// running strategies written against the strategy package
+type Strategy interface {
+	// called for each historical trade after the exchange has processed it
+	OnTrade(trade b.Trade, exchange b.Exchange)
+}
@@ -0,0 +1,194 @@
+/*
+Aggregation of trades into OHLCV candles.
+
+Trades can arrive late or out of order, so candles are held open until
+trades have been seen past the end of the candle plus an allowed
+lateness. Trades arriving after their candle was emitted are dropped.
+Intervals with no trades are emitted as flat candles at the previous
+close, so the candle stream has no gaps.
+*/
+package candles
+
+import (
+	"sort"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Aggregator struct {
+	interval time.Duration
+	lateness time.Duration
+	pairs    map[b.Pair]*series
+	dropped  int
+}
+
+// the open candles for a pair, keyed by start time
+type series struct {
+	open      map[int64]*bucket
+	watermark time.Time
+	emitted   time.Time
+	last      *b.Candle
+}
+
+type bucket struct {
+	candle              b.Candle
+	openTime, closeTime time.Time
+	notional            float64
+	keys                map[string]bool
+}
+
+// creates an aggregator of candles of interval, waiting for late trades
+// up to lateness after a candle ends before it's emitted
+func NewAggregator(interval time.Duration, lateness time.Duration) *Aggregator {
+	return &Aggregator{
+		interval: interval,
+		lateness: lateness,
+		pairs:    map[b.Pair]*series{},
+	}
+}
+
+// aggregates trades from a channel into candles until it's closed, then
+// flushes open candles and closes the candles channel
+func Aggregate(trades <-chan b.Trade, interval time.Duration, lateness time.Duration, candles chan<- b.Candle) {
+	a := NewAggregator(interval, lateness)
+	for trade := range trades {
+		for _, candle := range a.Add(trade) {
+			candles <- candle
+		}
+	}
+	for _, candle := range a.Flush() {
+		candles <- candle
+	}
+	close(candles)
+}
+
+// adds a trade, returning any candles that are complete
+func (a *Aggregator) Add(trade b.Trade) []b.Candle {
+	s, ok := a.pairs[trade.Pair]
+	if !ok {
+		s = &series{open: map[int64]*bucket{}}
+		a.pairs[trade.Pair] = s
+	}
+
+	start := trade.Timestamp.Truncate(a.interval)
+	if !s.emitted.IsZero() && start.Before(s.emitted) {
+		a.dropped++
+		return nil
+	}
+
+	bk, ok := s.open[start.UnixNano()]
+	if !ok {
+		bk = &bucket{
+			candle: b.Candle{
+				Pair:     trade.Pair,
+				Start:    start,
+				Interval: a.interval,
+				Open:     trade.Rate,
+				High:     trade.Rate,
+				Low:      trade.Rate,
+			},
+			openTime: trade.Timestamp,
+			keys:     map[string]bool{},
+		}
+		s.open[start.UnixNano()] = bk
+	}
+
+	// pollers re-fetch recent trades, so drop any we've already counted
+	if bk.keys[trade.Key()] {
+		return nil
+	}
+	bk.keys[trade.Key()] = true
+
+	bk.add(trade)
+
+	if trade.Timestamp.After(s.watermark) {
+		s.watermark = trade.Timestamp
+	}
+
+	return a.emit(s, s.watermark.Add(-a.lateness))
+}
+
+// emits all open candles, e.g when the trade stream ends
+func (a *Aggregator) Flush() []b.Candle {
+	candles := []b.Candle{}
+	for _, s := range a.pairs {
+		candles = append(candles, a.emit(s, s.watermark.Add(a.interval))...)
+	}
+	return candles
+}
+
+// returns the number of trades dropped for arriving too late
+func (a *Aggregator) Dropped() int {
+	return a.dropped
+}
+
+// emits candles for a series that end at or before a time, filling gaps
+func (a *Aggregator) emit(s *series, before time.Time) []b.Candle {
+	starts := []int64{}
+	for start, bk := range s.open {
+		if !bk.candle.Start.Add(a.interval).After(before) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Sort(int64s(starts))
+
+	candles := []b.Candle{}
+	for _, start := range starts {
+		bk := s.open[start]
+		delete(s.open, start)
+
+		if s.last != nil {
+			for t := s.emitted; t.Before(bk.candle.Start); t = t.Add(a.interval) {
+				candles = append(candles, b.Candle{
+					Pair:     s.last.Pair,
+					Start:    t,
+					Interval: a.interval,
+					Open:     s.last.Close,
+					High:     s.last.Close,
+					Low:      s.last.Close,
+					Close:    s.last.Close,
+					VWAP:     s.last.Close,
+				})
+			}
+		}
+
+		candle := bk.candle
+		candles = append(candles, candle)
+		s.last = &candle
+		s.emitted = candle.Start.Add(a.interval)
+	}
+
+	return candles
+}
+
+// adds a trade to a candle, open and close are by timestamp so that out
+// of order trades are handled
+func (bk *bucket) add(trade b.Trade) {
+	c := &bk.candle
+	if trade.Timestamp.Before(bk.openTime) {
+		c.Open, bk.openTime = trade.Rate, trade.Timestamp
+	}
+	if !trade.Timestamp.Before(bk.closeTime) {
+		c.Close, bk.closeTime = trade.Rate, trade.Timestamp
+	}
+	if trade.Rate > c.High {
+		c.High = trade.Rate
+	}
+	if trade.Rate < c.Low {
+		c.Low = trade.Rate
+	}
+
+	c.Volume += trade.Amount
+	c.Trades++
+	bk.notional += trade.Amount * trade.Rate
+	if c.Volume > 0 {
+		c.VWAP = bk.notional / c.Volume
+	}
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,107 @@
+package candles
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var start = time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func trade(id string, minutes float64, rate float64, amount float64) babel.Trade {
+	return babel.Trade{
+		Id:        id,
+		Pair:      babel.BTC_USD,
+		Rate:      rate,
+		Amount:    amount,
+		Timestamp: start.Add(time.Duration(minutes * float64(time.Minute))),
+	}
+}
+
+func TestAggregatorSpec(t *testing.T) {
+	Convey("Subject: Candle Aggregator", t, func() {
+		a := NewAggregator(time.Minute, 0)
+
+		Convey(`Trades should be aggregated into OHLCV candles`, func() {
+			So(a.Add(trade("1", 0.1, 100, 1)), ShouldBeEmpty)
+			So(a.Add(trade("2", 0.5, 110, 2)), ShouldBeEmpty)
+			So(a.Add(trade("3", 0.9, 90, 1)), ShouldBeEmpty)
+
+			candles := a.Add(trade("4", 1.2, 95, 1))
+			So(len(candles), ShouldEqual, 1)
+
+			c := candles[0]
+			So(c.Start, ShouldResemble, start)
+			So(c.Open, ShouldEqual, 100)
+			So(c.High, ShouldEqual, 110)
+			So(c.Low, ShouldEqual, 90)
+			So(c.Close, ShouldEqual, 90)
+			So(c.Volume, ShouldEqual, 4)
+			So(c.VWAP, ShouldEqual, 102.5)
+			So(c.Trades, ShouldEqual, 3)
+		})
+
+		Convey(`Empty intervals should be filled with flat candles`, func() {
+			candles := a.Add(trade("1", 0.5, 100, 1))
+			candles = append(candles, a.Add(trade("2", 3.5, 120, 1))...)
+			candles = append(candles, a.Flush()...)
+
+			So(len(candles), ShouldEqual, 4)
+			So(candles[1].Open, ShouldEqual, 100)
+			So(candles[1].Close, ShouldEqual, 100)
+			So(candles[1].Trades, ShouldEqual, 0)
+			So(candles[3].Close, ShouldEqual, 120)
+		})
+
+		Convey(`Out of order trades within the lateness should be included`, func() {
+			a = NewAggregator(time.Minute, 30*time.Second)
+
+			a.Add(trade("1", 0.5, 100, 1))
+			So(a.Add(trade("2", 1.2, 105, 1)), ShouldBeEmpty)
+			a.Add(trade("3", 0.1, 98, 1))
+
+			candles := a.Add(trade("4", 1.6, 106, 1))
+			So(len(candles), ShouldEqual, 1)
+			So(candles[0].Open, ShouldEqual, 98)
+			So(candles[0].Close, ShouldEqual, 100)
+			So(candles[0].Trades, ShouldEqual, 2)
+
+			So(a.Add(trade("5", 0.2, 99, 1)), ShouldBeEmpty)
+			So(a.Dropped(), ShouldEqual, 1)
+		})
+
+		Convey(`Duplicate trades should only be counted once`, func() {
+			a.Add(trade("1", 0.5, 100, 1))
+			a.Add(trade("1", 0.5, 100, 1))
+			candles := a.Flush()
+			So(candles[0].Trades, ShouldEqual, 1)
+		})
+
+		Convey(`Duplicate trades without ids should only be counted once`, func() {
+			a.Add(trade("", 0.5, 100, 1))
+			a.Add(trade("", 0.6, 101, 2))
+			a.Add(trade("", 0.5, 100, 1))
+			candles := a.Flush()
+			So(candles[0].Trades, ShouldEqual, 2)
+			So(candles[0].Volume, ShouldEqual, 3)
+		})
+
+		Convey(`Trades from a channel should be aggregated`, func() {
+			trades := make(chan babel.Trade, 3)
+			candles := make(chan babel.Candle, 10)
+			trades <- trade("1", 0.5, 100, 1)
+			trades <- trade("2", 1.5, 101, 1)
+			trades <- trade("3", 2.5, 102, 1)
+			close(trades)
+
+			Aggregate(trades, time.Minute, 0, candles)
+			results := []babel.Candle{}
+			for c := range candles {
+				results = append(results, c)
+			}
+			So(len(results), ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,489 @@
+/*
+Stop and trailing orders emulated on top of exchanges that only take
+limit and market orders.
+
+A Manager holds conditional orders and watches the Ticker of each pair
+they're in. When the price crosses an order's trigger it's placed with
+ExchangeAccount.Trade, as a market order or a limit order for stop
+limits. Sells are triggered by the bid and buys by the ask. The orders
+are saved to a state file after every change so pending triggers survive
+a restart. Orders are saved as triggering before they're placed, so a
+restart never places them again, and orders left triggering by a crash
+are logged to be checked on the exchange.
+*/
+package conditional
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// the kind of conditional order
+type Kind string
+
+const (
+	// places a market order when the price moves against a position
+	StopLoss Kind = "stop_loss"
+
+	// places a market order when the price moves in favour of a position
+	TakeProfit Kind = "take_profit"
+
+	// places a limit order at Limit when the price moves against a position
+	StopLimit Kind = "stop_limit"
+
+	// a stop loss that follows the best price by the Trail fraction
+	TrailingStop Kind = "trailing_stop"
+)
+
+type State string
+
+const (
+	Pending    State = "pending"
+	Triggering State = "triggering"
+	Triggered  State = "triggered"
+	Failed     State = "failed"
+	Cancelled  State = "cancelled"
+)
+
+// an order that's placed when the price reaches a trigger. for trailing
+// stops the trigger is moved as the best price seen moves
+type Order struct {
+	Id        string
+	Kind      Kind
+	Pair      b.Pair
+	Type      b.TradeType
+	Amount    float64
+	Trigger   float64
+	Limit     float64
+	Trail     float64
+	Best      float64
+	State     State
+	Created   time.Time
+	Triggered time.Time
+	Placed    b.Order
+	Error     string
+}
+
+func (o *Order) String() string {
+	return fmt.Sprintf("%s %s %s %.8f %s @ %.8f (%s)",
+		o.Id, o.Kind, o.Type, o.Amount, o.Pair.String(), o.Trigger, o.State)
+}
+
+type Manager struct {
+	exchange  b.Exchange
+	config    map[string]interface{}
+	clock     b.Clock
+	stateFile string
+	mutex     sync.Mutex
+	state     *state
+	watching  map[b.Pair]*ticker
+	running   bool
+	stopped   bool
+	done      chan bool
+}
+
+// a ticker being watched, stop ends the goroutine reading it
+type ticker struct {
+	channel chan b.MarketData
+	stop    chan bool
+}
+
+// the orders, persisted between runs
+type state struct {
+	Orders []Order
+	NextId int
+}
+
+// creates a manager of conditional orders on an exchange. accepts a
+// state_file to persist orders to and a clock as config
+func NewManager(exchange b.Exchange, config map[string]interface{}) (*Manager, error) {
+	m := &Manager{
+		exchange: exchange,
+		config:   config,
+		clock:    b.ExchangeClock(exchange),
+		state:    &state{},
+		watching: map[b.Pair]*ticker{},
+		done:     make(chan bool),
+	}
+
+	if clock, ok := config["clock"].(b.Clock); ok {
+		m.clock = clock
+	}
+
+	if file, ok := config["state_file"].(string); ok && file != "" {
+		m.stateFile = file
+		if err := m.loadState(); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	for _, order := range m.state.Orders {
+		if order.State == Triggering {
+			log.Printf("Order %s may have been placed before a restart, check the exchange", order.String())
+		}
+	}
+
+	return m, nil
+}
+
+// adds a conditional order, watching its pair if the manager is running
+func (m *Manager) Add(order Order) (Order, error) {
+	if err := validate(order); err != nil {
+		return Order{}, err
+	}
+
+	m.mutex.Lock()
+	m.state.NextId++
+	order.Id = fmt.Sprintf("c%d", m.state.NextId)
+	order.State = Pending
+	order.Created = m.clock.Now()
+	m.state.Orders = append(m.state.Orders, order)
+	err := m.saveState()
+	running := m.running
+	m.mutex.Unlock()
+
+	if err != nil {
+		return order, err
+	}
+
+	if running {
+		return order, m.watch(order.Pair)
+	}
+	return order, nil
+}
+
+// cancels a pending conditional order
+func (m *Manager) Cancel(id string) error {
+	m.mutex.Lock()
+	for i, order := range m.state.Orders {
+		if order.Id == id {
+			if order.State != Pending {
+				m.mutex.Unlock()
+				return errors.New("Order " + id + " is " + string(order.State))
+			}
+			m.state.Orders[i].State = Cancelled
+			err := m.saveState()
+			t := m.idle(order.Pair)
+			m.mutex.Unlock()
+
+			m.stopTicker(order.Pair, t)
+			return err
+		}
+	}
+	m.mutex.Unlock()
+	return errors.New("Unknown order " + id)
+}
+
+// returns all conditional orders, including ones that have finished
+func (m *Manager) Orders() []Order {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]Order{}, m.state.Orders...)
+}
+
+// returns the pending conditional orders
+func (m *Manager) Pending() []Order {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pending := []Order{}
+	for _, order := range m.state.Orders {
+		if order.State == Pending {
+			pending = append(pending, order)
+		}
+	}
+	return pending
+}
+
+// watches the ticker of each pair with pending orders, and of pairs that
+// orders are added for later
+func (m *Manager) Start() error {
+	m.mutex.Lock()
+	if m.stopped {
+		m.mutex.Unlock()
+		return errors.New("Manager has been stopped")
+	}
+	m.running = true
+	pairs := []b.Pair{}
+	for _, order := range m.state.Orders {
+		if order.State == Pending && !b.ContainsPair(order.Pair, pairs) {
+			pairs = append(pairs, order.Pair)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, pair := range pairs {
+		if err := m.watch(pair); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stops acting on market data and stops the tickers watched, a stopped
+// manager can't be started again
+func (m *Manager) Stop() {
+	m.mutex.Lock()
+	tickers := m.watching
+	if !m.stopped {
+		m.running, m.stopped = false, true
+		m.watching = map[b.Pair]*ticker{}
+		close(m.done)
+	}
+	m.mutex.Unlock()
+
+	for pair, t := range tickers {
+		m.stopTicker(pair, t)
+	}
+}
+
+// checks the pending orders in a pair against market data, placing the
+// ones that trigger, and returns them
+func (m *Manager) Update(data b.MarketData) []Order {
+	m.mutex.Lock()
+	triggering := []Order{}
+	changed := false
+
+	for i := range m.state.Orders {
+		order := &m.state.Orders[i]
+		if order.State != Pending || order.Pair != data.Pair {
+			continue
+		}
+
+		price := data.Buy
+		if order.Type == b.Buy {
+			price = data.Sell
+		}
+		if price <= 0 {
+			price = data.Last
+		}
+		if price <= 0 {
+			continue
+		}
+
+		if order.Kind == TrailingStop && trail(order, price) {
+			changed = true
+		}
+
+		if !fires(order, price) {
+			continue
+		}
+
+		order.State, order.Triggered = Triggering, m.clock.Now()
+		triggering = append(triggering, *order)
+		changed = true
+	}
+
+	if changed {
+		m.save()
+	}
+	m.mutex.Unlock()
+
+	// orders are placed without holding the lock, as it's a round trip
+	triggered := []Order{}
+	for _, order := range triggering {
+		m.place(&order)
+		triggered = append(triggered, order)
+	}
+
+	if len(triggered) > 0 {
+		m.mutex.Lock()
+		for _, order := range triggered {
+			for i := range m.state.Orders {
+				if m.state.Orders[i].Id == order.Id {
+					m.state.Orders[i] = order
+				}
+			}
+		}
+		m.save()
+		t := m.idle(data.Pair)
+		m.mutex.Unlock()
+
+		m.stopTicker(data.Pair, t)
+	}
+	return triggered
+}
+
+// stops watching a pair if it has no pending orders, and returns its ticker
+// to be stopped once the mutex is released. the mutex must be held
+func (m *Manager) idle(pair b.Pair) *ticker {
+	for _, order := range m.state.Orders {
+		if order.State == Pending && order.Pair == pair {
+			return nil
+		}
+	}
+
+	t := m.watching[pair]
+	delete(m.watching, pair)
+	return t
+}
+
+// saves the orders, logging failures as there's no caller to return them
+// to. the mutex must be held
+func (m *Manager) save() {
+	if err := m.saveState(); err != nil {
+		log.Printf("Failed to save conditional orders: %v", err)
+	}
+}
+
+// places a triggered order on the exchange
+func (m *Manager) place(order *Order) {
+	rate := -1.0
+	if order.Kind == StopLimit {
+		rate = order.Limit
+	}
+
+	placed, err := m.exchange.Account().Trade(order.Type, order.Pair, order.Amount, rate)
+	if err != nil {
+		order.State, order.Error = Failed, err.Error()
+		log.Printf("Failed to place triggered order %s: %v", order.String(), err)
+		return
+	}
+
+	order.State, order.Placed = Triggered, placed
+	log.Printf("Triggered %s as order %s", order.String(), placed.Id)
+}
+
+func (m *Manager) watch(pair b.Pair) error {
+	t := &ticker{channel: make(chan b.MarketData, 10), stop: make(chan bool)}
+
+	m.mutex.Lock()
+	if _, ok := m.watching[pair]; ok {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.watching[pair] = t
+	m.mutex.Unlock()
+
+	if err := m.exchange.Ticker(pair, t.channel); err != nil {
+		m.mutex.Lock()
+		delete(m.watching, pair)
+		m.mutex.Unlock()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case data := <-t.channel:
+				// keep draining once stopped so the ticker never blocks
+				select {
+				case <-m.done:
+				default:
+					m.Update(data)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stops a ticker that's no longer watched. exchanges that can't stop
+// tickers keep sending, so the channel is drained unless the exchange stops it
+func (m *Manager) stopTicker(pair b.Pair, t *ticker) {
+	if t == nil {
+		return
+	}
+	if err := b.StopTicker(m.exchange, pair, t.channel); err != nil {
+		log.Printf("Failed to stop the ticker for %s: %v", pair.String(), err)
+		return
+	}
+	if _, ok := m.exchange.(b.TickerStopper); ok {
+		close(t.stop)
+	}
+}
+
+// moves the best price and the trigger of a trailing stop, and returns
+// true if they moved
+func trail(order *Order, price float64) bool {
+	if order.Type == b.Sell && price > order.Best {
+		order.Best = price
+		order.Trigger = price * (1 - order.Trail)
+		return true
+	} else if order.Type == b.Buy && (order.Best == 0 || price < order.Best) {
+		order.Best = price
+		order.Trigger = price * (1 + order.Trail)
+		return true
+	}
+	return false
+}
+
+// returns true if an order triggers at a price. stops close a position
+// when the price moves against it, take profits when it moves in favour
+func fires(order *Order, price float64) bool {
+	if order.Kind == TakeProfit {
+		if order.Type == b.Sell {
+			return price >= order.Trigger
+		}
+		return price <= order.Trigger
+	}
+
+	if order.Type == b.Sell {
+		return price <= order.Trigger
+	}
+	return price >= order.Trigger
+}
+
+func validate(order Order) error {
+	if order.Type != b.Buy && order.Type != b.Sell {
+		return errors.New("Unknown trade type " + string(order.Type))
+	}
+	if order.Amount <= 0 && order.Amount != -1 {
+		return errors.New("Invalid order amount")
+	}
+
+	switch order.Kind {
+	case StopLoss, TakeProfit:
+		if order.Trigger <= 0 {
+			return errors.New("A trigger price is needed")
+		}
+	case StopLimit:
+		if order.Trigger <= 0 || order.Limit <= 0 {
+			return errors.New("A trigger and limit price are needed")
+		}
+	case TrailingStop:
+		if order.Trail <= 0 || order.Trail >= 1 {
+			return errors.New("A trail between 0 and 1 is needed")
+		}
+	default:
+		return errors.New("Unknown conditional order kind " + string(order.Kind))
+	}
+	return nil
+}
+
+func (m *Manager) loadState() error {
+	bytes, err := ioutil.ReadFile(m.stateFile)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, m.state)
+}
+
+// writes state to a temp file first, so a crash never leaves it truncated
+func (m *Manager) saveState() error {
+	if m.stateFile == "" {
+		return nil
+	}
+
+	bytes, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(m.stateFile+".tmp", bytes, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(m.stateFile+".tmp", m.stateFile)
+}
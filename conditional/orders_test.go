@@ -0,0 +1,200 @@
+package conditional
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// an account that calls a hook before placing orders
+type hooked struct {
+	*fake.Driver
+	hook func()
+}
+
+func (h *hooked) Account() babel.ExchangeAccount {
+	return h
+}
+
+func (h *hooked) Trade(t babel.TradeType, pair babel.Pair, amount float64, rate float64) (babel.Order, error) {
+	h.hook()
+	return h.Driver.Trade(t, pair, amount, rate)
+}
+
+// an exchange that counts the tickers stopped
+type stopping struct {
+	*fake.Driver
+	mutex sync.Mutex
+	count int
+}
+
+func (s *stopping) StopTicker(pair babel.Pair, channel chan<- babel.MarketData) error {
+	s.mutex.Lock()
+	s.count++
+	s.mutex.Unlock()
+	return s.Driver.StopTicker(pair, channel)
+}
+
+func (s *stopping) stopped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+func TestManagerSpec(t *testing.T) {
+	Convey("Subject: Conditional Orders", t, func() {
+		exchange := fake.New("fake", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 1000, babel.BTC: 5},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+		}).(*fake.Driver)
+
+		dir, _ := ioutil.TempDir("", "conditional")
+		defer os.RemoveAll(dir)
+		config := map[string]interface{}{"state_file": filepath.Join(dir, "orders.json")}
+
+		manager, err := NewManager(exchange, config)
+		So(err, ShouldBeNil)
+
+		market := func(buy, sell float64) babel.MarketData {
+			return babel.MarketData{Pair: babel.BTC_USD, Buy: buy, Sell: sell, Last: (buy + sell) / 2}
+		}
+
+		Convey(`Stop losses should sell at market once the bid falls to the trigger`, func() {
+			order, err := manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			So(err, ShouldBeNil)
+
+			So(len(manager.Update(market(91, 92))), ShouldEqual, 0)
+
+			exchange.SetMarketData(market(89, 90))
+			triggered := manager.Update(market(89, 90))
+			So(len(triggered), ShouldEqual, 1)
+			So(triggered[0].Id, ShouldEqual, order.Id)
+			So(triggered[0].State, ShouldEqual, Triggered)
+			So(triggered[0].Placed.Remains, ShouldEqual, 0)
+
+			balances, _ := exchange.Balance([]babel.Symbol{babel.BTC})
+			So(balances[babel.BTC], ShouldEqual, 4)
+
+			So(len(manager.Update(market(80, 81))), ShouldEqual, 0)
+		})
+
+		Convey(`Take profits should trigger when the price rises`, func() {
+			manager.Add(Order{Kind: TakeProfit, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 110})
+			So(len(manager.Update(market(105, 106))), ShouldEqual, 0)
+			So(len(manager.Update(market(110, 111))), ShouldEqual, 1)
+		})
+
+		Convey(`Stop limits should place a limit order`, func() {
+			manager.Add(Order{Kind: StopLimit, Pair: babel.BTC_USD, Type: babel.Buy, Amount: 1, Trigger: 110, Limit: 80})
+			triggered := manager.Update(market(110, 111))
+			So(len(triggered), ShouldEqual, 1)
+			So(triggered[0].Placed.Rate, ShouldEqual, 80)
+
+			orders, _ := exchange.Orders(0)
+			So(len(orders), ShouldEqual, 1)
+		})
+
+		Convey(`Trailing stops should follow the best price`, func() {
+			manager.Add(Order{Kind: TrailingStop, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trail: 0.1})
+			So(len(manager.Update(market(100, 101))), ShouldEqual, 0)
+			So(len(manager.Update(market(120, 121))), ShouldEqual, 0)
+			So(manager.Pending()[0].Trigger, ShouldAlmostEqual, 108)
+
+			So(len(manager.Update(market(110, 111))), ShouldEqual, 0)
+			So(manager.Pending()[0].Trigger, ShouldAlmostEqual, 108)
+			So(len(manager.Update(market(107, 108))), ShouldEqual, 1)
+		})
+
+		Convey(`Orders that fail to place should be marked failed`, func() {
+			manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 50, Trigger: 90})
+			triggered := manager.Update(market(89, 90))
+			So(triggered[0].State, ShouldEqual, Failed)
+			So(triggered[0].Error, ShouldNotEqual, "")
+		})
+
+		Convey(`Cancelled orders should never trigger`, func() {
+			order, _ := manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			So(manager.Cancel(order.Id), ShouldBeNil)
+			So(len(manager.Update(market(80, 81))), ShouldEqual, 0)
+			So(manager.Cancel(order.Id), ShouldNotBeNil)
+		})
+
+		Convey(`Invalid orders should be rejected`, func() {
+			_, err := manager.Add(Order{Kind: StopLimit, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			So(err, ShouldNotBeNil)
+			_, err = manager.Add(Order{Kind: TrailingStop, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trail: 2})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Pending orders should survive a restart`, func() {
+			manager.Add(Order{Kind: TrailingStop, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trail: 0.1})
+			manager.Update(market(120, 121))
+
+			restarted, err := NewManager(exchange, config)
+			So(err, ShouldBeNil)
+			pending := restarted.Pending()
+			So(len(pending), ShouldEqual, 1)
+			So(pending[0].Best, ShouldEqual, 120)
+
+			order, _ := restarted.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 50})
+			So(order.Id, ShouldEqual, "c2")
+		})
+
+		Convey(`Orders should be saved as triggering before they're placed`, func() {
+			var states []State
+			account := &hooked{Driver: exchange}
+			manager, _ := NewManager(account, config)
+			account.hook = func() {
+				restarted, err := NewManager(exchange, config)
+				So(err, ShouldBeNil)
+				states = append(states, manager.Orders()[0].State, restarted.Orders()[0].State)
+			}
+
+			manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			triggered := manager.Update(market(89, 90))
+			So(states, ShouldResemble, []State{Triggering, Triggering})
+			So(triggered[0].State, ShouldEqual, Triggered)
+
+			restarted, _ := NewManager(exchange, config)
+			So(restarted.Orders()[0].State, ShouldEqual, Triggered)
+			So(len(restarted.Update(market(80, 81))), ShouldEqual, 0)
+		})
+
+		Convey(`Tickers should be stopped once nothing is pending or on stop`, func() {
+			stopping := &stopping{Driver: exchange}
+			manager, _ := NewManager(stopping, map[string]interface{}{})
+			So(manager.Start(), ShouldBeNil)
+
+			order, _ := manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			So(manager.Cancel(order.Id), ShouldBeNil)
+			So(stopping.stopped(), ShouldEqual, 1)
+
+			manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			manager.Stop()
+			So(stopping.stopped(), ShouldEqual, 2)
+		})
+
+		Convey(`Running managers should trigger from the ticker`, func() {
+			So(manager.Start(), ShouldBeNil)
+			defer manager.Stop()
+
+			manager.Add(Order{Kind: StopLoss, Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Trigger: 90})
+			exchange.SetMarketData(market(89, 90))
+
+			deadline := time.Now().Add(time.Second)
+			for len(manager.Pending()) > 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			So(len(manager.Pending()), ShouldEqual, 0)
+		})
+	})
+}
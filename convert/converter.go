@@ -0,0 +1,241 @@
+/*
+Conversion between currencies through the pairs that trade them.
+
+A Converter builds a graph of currencies from the pairs and market data
+of one or more exchanges, and converts amounts along the path with the
+best rate, so a balance can be valued in a currency even when there is
+no pair that trades it directly, e.g ftc to usd through ftc_btc and
+btc_usd.
+*/
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// one conversion in a path, a sell of the base at the bid or a buy of the
+// base at the ask
+type Step struct {
+	Exchange string
+	Pair     b.Pair
+	Type     b.TradeType
+	From, To b.Symbol
+	Rate     float64
+}
+
+func (s *Step) String() string {
+	return fmt.Sprintf("%s %s on %s @ %.8f", s.Type, s.Pair.String(), s.Exchange, s.Rate)
+}
+
+type Converter struct {
+	exchanges map[string]b.Exchange
+	config    map[string]interface{}
+	maxAge    time.Duration
+	maxSteps  int
+	useLast   bool
+	clock     b.Clock
+	mutex     sync.Mutex
+	data      map[string]map[b.Pair]b.MarketData
+	updated   time.Time
+}
+
+// creates a converter over exchanges keyed by name. accepts max_age for how
+// long market data is used before it's refreshed, max_steps in a path,
+// price of "last" to convert at the last price instead of the bid and ask,
+// and a clock as config
+func NewConverter(exchanges map[string]b.Exchange, config map[string]interface{}) *Converter {
+	c := &Converter{
+		exchanges: exchanges,
+		config:    config,
+		maxAge:    time.Minute,
+		maxSteps:  4,
+		clock:     b.ConfigClock(config),
+		data:      map[string]map[b.Pair]b.MarketData{},
+	}
+
+	if maxAge, ok := config["max_age"].(time.Duration); ok {
+		c.maxAge = maxAge
+	}
+
+	if maxSteps, ok := config["max_steps"].(int); ok && maxSteps > 0 {
+		c.maxSteps = maxSteps
+	}
+
+	if price, ok := config["price"].(string); ok {
+		c.useLast = price == "last"
+	}
+
+	return c
+}
+
+// converts an amount from one currency to another along the best path
+func (c *Converter) Convert(amount float64, from b.Symbol, to b.Symbol) (float64, error) {
+	rate, _, err := c.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+// returns the best rate from one currency to another and the path for it,
+// refreshing market data if it's older than max_age
+func (c *Converter) Rate(from b.Symbol, to b.Symbol) (float64, []Step, error) {
+	if from == to {
+		return 1, []Step{}, nil
+	}
+
+	c.mutex.Lock()
+	stale := c.clock.Now().Sub(c.updated) > c.maxAge
+	c.mutex.Unlock()
+
+	if stale {
+		if err := c.Refresh(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bestPath(from, to)
+}
+
+// fetches market data for all pairs of all exchanges
+func (c *Converter) Refresh() error {
+	var err error
+	fetched := 0
+
+	for name, exchange := range c.exchanges {
+		pairs, pairsErr := exchange.Pairs()
+		if pairsErr != nil {
+			err = pairsErr
+			log.Printf("Failed to fetch pairs for %s: %v", name, err)
+			continue
+		}
+
+		for _, pair := range pairs {
+			data, dataErr := exchange.MarketData(pair)
+			if dataErr != nil {
+				log.Printf("Failed to fetch market data for %s on %s: %v", pair.String(), name, dataErr)
+				continue
+			}
+			c.Update(name, data)
+			fetched++
+		}
+	}
+
+	if fetched == 0 && err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.updated = c.clock.Now()
+	c.mutex.Unlock()
+	return nil
+}
+
+// updates the market data of a pair on an exchange, e.g from a ticker
+func (c *Converter) Update(exchange string, data b.MarketData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.data[exchange]; !ok {
+		c.data[exchange] = map[b.Pair]b.MarketData{}
+	}
+	c.data[exchange][data.Pair] = data
+}
+
+// returns the edges out of each currency
+func (c *Converter) graph() map[b.Symbol][]Step {
+	names := []string{}
+	for name, _ := range c.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	graph := map[b.Symbol][]Step{}
+	for _, name := range names {
+		for pair, data := range c.data[name] {
+			bid, ask := data.Buy, data.Sell
+			if c.useLast {
+				bid, ask = data.Last, data.Last
+			}
+
+			if bid > 0 {
+				graph[pair.Base] = append(graph[pair.Base],
+					Step{name, pair, b.Sell, pair.Base, pair.Counter, bid})
+			}
+			if ask > 0 {
+				graph[pair.Counter] = append(graph[pair.Counter],
+					Step{name, pair, b.Buy, pair.Counter, pair.Base, 1 / ask})
+			}
+		}
+	}
+	return graph
+}
+
+// finds the path with the highest rate of at most max_steps, paths never
+// revisit a currency so arbitrage cycles can't inflate a rate
+func (c *Converter) bestPath(from b.Symbol, to b.Symbol) (float64, []Step, error) {
+	type path struct {
+		rate  float64
+		steps []Step
+	}
+
+	graph := c.graph()
+	best := map[b.Symbol]path{from: {1, []Step{}}}
+	frontier := map[b.Symbol]path{from: best[from]}
+
+	for i := 0; i < c.maxSteps && len(frontier) > 0; i++ {
+		next := map[b.Symbol]path{}
+		for symbol, p := range frontier {
+			for _, step := range graph[symbol] {
+				if step.To == from || visits(p.steps, step.To) {
+					continue
+				}
+
+				rate := p.rate * step.Rate
+				if existing, ok := best[step.To]; ok && existing.rate >= rate {
+					continue
+				}
+
+				steps := append(append([]Step{}, p.steps...), step)
+				best[step.To] = path{rate, steps}
+				next[step.To] = best[step.To]
+			}
+		}
+		frontier = next
+	}
+
+	result, ok := best[to]
+	if !ok {
+		return 0, nil, errors.New(fmt.Sprintf("No conversion from %s to %s", from, to))
+	}
+	return result.rate, result.steps, nil
+}
+
+// returns true if a path passes through a currency
+func visits(steps []Step, symbol b.Symbol) bool {
+	for _, step := range steps {
+		if step.From == symbol || step.To == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// describes a path, e.g ftc -> btc -> usd
+func PathString(from b.Symbol, steps []Step) string {
+	symbols := []string{string(from)}
+	for _, step := range steps {
+		symbols = append(symbols, string(step.To))
+	}
+	return strings.Join(symbols, " -> ")
+}
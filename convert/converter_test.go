@@ -0,0 +1,81 @@
+package convert
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var FTC_BTC = babel.Pair{babel.FTC, babel.BTC}
+
+func TestConverterSpec(t *testing.T) {
+	Convey("Subject: Currency Converter", t, func() {
+		btce := fake.New("btce", map[string]interface{}{
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 100, Sell: 101, Last: 100.5},
+				{Pair: babel.LTC_BTC, Buy: 0.02, Sell: 0.021, Last: 0.0205},
+				{Pair: babel.LTC_USD, Buy: 2, Sell: 2.1, Last: 2.05},
+			},
+		}).(*fake.Driver)
+		cryptsy := fake.New("cryptsy", map[string]interface{}{
+			"market_data": []babel.MarketData{
+				{Pair: FTC_BTC, Buy: 0.001, Sell: 0.0011, Last: 0.001},
+			},
+		}).(*fake.Driver)
+
+		clock := babel.NewFakeClock(time.Now())
+		converter := NewConverter(map[string]babel.Exchange{"btce": btce, "cryptsy": cryptsy},
+			map[string]interface{}{"clock": clock})
+
+		Convey(`Amounts should be converted through indirect pairs`, func() {
+			amount, err := converter.Convert(10, babel.FTC, babel.USD)
+			So(err, ShouldBeNil)
+			So(amount, ShouldAlmostEqual, 1)
+
+			rate, steps, _ := converter.Rate(babel.USD, babel.FTC)
+			So(rate, ShouldAlmostEqual, 1/101.0/0.0011)
+			So(PathString(babel.USD, steps), ShouldEqual, "usd -> btc -> ftc")
+			So(steps[1].Exchange, ShouldEqual, "cryptsy")
+		})
+
+		Convey(`Converting to the same currency should be the identity`, func() {
+			amount, err := converter.Convert(5, babel.BTC, babel.BTC)
+			So(err, ShouldBeNil)
+			So(amount, ShouldEqual, 5)
+		})
+
+		Convey(`Unknown currencies should fail`, func() {
+			_, err := converter.Convert(5, babel.BTC, babel.AUD)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`The best rate across exchanges should be used`, func() {
+			converter.Update("bitstamp", babel.MarketData{Pair: babel.BTC_USD, Buy: 105, Sell: 106})
+			rate, steps, err := converter.Rate(babel.BTC, babel.USD)
+			So(err, ShouldBeNil)
+			So(rate, ShouldEqual, 105)
+			So(steps[0].Exchange, ShouldEqual, "bitstamp")
+		})
+
+		Convey(`Market data should be refreshed once it's older than max_age`, func() {
+			converter.Convert(1, babel.BTC, babel.USD)
+			btce.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 200, Sell: 201})
+
+			amount, _ := converter.Convert(1, babel.BTC, babel.USD)
+			So(amount, ShouldEqual, 100)
+
+			clock.Advance(2 * time.Minute)
+			amount, _ = converter.Convert(1, babel.BTC, babel.USD)
+			So(amount, ShouldEqual, 200)
+		})
+
+		Convey(`Conversions can use the last price`, func() {
+			converter := NewConverter(map[string]babel.Exchange{"btce": btce}, map[string]interface{}{"price": "last"})
+			amount, _ := converter.Convert(2, babel.BTC, babel.USD)
+			So(amount, ShouldEqual, 201)
+		})
+	})
+}
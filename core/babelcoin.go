@@ -10,7 +10,9 @@ This interface is subject to change at this stage.
 package babelcoin
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -45,6 +47,16 @@ type MarketData struct {
 	Updated                 time.Time
 }
 
+// open, high, low, close and volume for the trades in an interval
+type Candle struct {
+	Pair                           Pair
+	Start                          time.Time
+	Interval                       time.Duration
+	Open, High, Low, Close, Volume float64
+	VWAP                           float64
+	Trades                         int
+}
+
 // the type of a trade, either buy or sell
 type TradeType string
 
@@ -80,11 +92,16 @@ type Transaction struct {
 	Amount    float64
 }
 
-// the order book showing asks and bids
+// an amount available at a price in an order book
+type Level struct {
+	Price, Amount float64
+}
+
+// the order book showing asks and bids. sequence is the number of the last
+// change in the book, zero if the exchange doesn't number its changes
 type OrderBook struct {
-	Asks, Bids []struct {
-		Price, Amount float64
-	}
+	Asks, Bids []Level
+	Sequence   int64
 }
 
 type Exchange interface {
@@ -128,6 +145,46 @@ type ExchangeAccount interface {
 	OrderBook(pair Pair, limit int) (OrderBook, error)
 }
 
+// implemented by exchanges that know the fee they charge on trades
+type FeeSchedule interface {
+	// the fraction of a trade charged as a fee, e.g 0.002 for 0.2%
+	Fee(pair Pair) (float64, error)
+}
+
+// implemented by exchanges whose tickers can be stopped
+type TickerStopper interface {
+	// stops sending market data to a channel passed to Ticker
+	StopTicker(pair Pair, channel chan<- MarketData) error
+}
+
+// stops a ticker if the exchange supports it, otherwise it keeps running
+func StopTicker(exchange Exchange, pair Pair, channel chan<- MarketData) error {
+	if stopper, ok := exchange.(TickerStopper); ok {
+		return stopper.StopTicker(pair, channel)
+	}
+	return nil
+}
+
+// the limits an exchange places on orders in a pair, zero means no limit.
+// precision is the number of decimal places allowed in a price
+type PairRule struct {
+	MinAmount          float64
+	MinPrice, MaxPrice float64
+	Precision          int
+}
+
+// implemented by exchanges that know the limits on orders in their pairs
+type PairRules interface {
+	Rules(pair Pair) (PairRule, error)
+}
+
+// implemented by accounts whose balances leave out the funds held back for
+// their open orders
+type ReservedFunds interface {
+	// returns the funds held by open orders, by symbol
+	Reserved() (map[Symbol]float64, error)
+}
+
 // a function for creating an Exchange
 type ExchangeFactory func(key string, config map[string]interface{}) Exchange
 
@@ -142,6 +199,26 @@ func ParsePair(pair string) Pair {
 	return Pair{Symbol(strings.ToLower(parts[0])), Symbol(strings.ToLower(parts[1]))}
 }
 
+// parses balances in the form usd:1000,btc:2.5
+func ParseBalances(balances string) (map[Symbol]float64, error) {
+	result := map[Symbol]float64{}
+	for _, part := range strings.Split(balances, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Invalid balance " + part)
+		}
+		amount, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		result[Symbol(strings.ToLower(kv[0]))] = amount
+	}
+	return result, nil
+}
+
 // returns true if the slice contains the given pair
 func ContainsPair(pair Pair, pairs []Pair) bool {
 	for _, p := range pairs {
@@ -152,11 +229,30 @@ func ContainsPair(pair Pair, pairs []Pair) bool {
 	return false
 }
 
+// returns true if the slice contains the given symbol
+func ContainsSymbol(symbol Symbol, symbols []Symbol) bool {
+	for _, s := range symbols {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
 // returns an identity composed of the exchange, pair and tradeid
 func (t *Trade) Identity() string {
 	return fmt.Sprintf("%s:%s:%s", t.Exchange, t.Pair.String(), t.Id)
 }
 
+// returns a key for de-duping trades. trades from sources without ids like
+// bitcoincharts share an identity, so they are keyed by their contents
+func (t *Trade) Key() string {
+	if t.Id == "" {
+		return fmt.Sprintf("%s:%d:%v:%v", t.Identity(), t.Timestamp.UnixNano(), t.Rate, t.Amount)
+	}
+	return t.Identity()
+}
+
 // returns a string version of a trade
 func (t *Trade) String() string {
 	return fmt.Sprintf("%s %s %.5f@%.5f on %s",
@@ -164,6 +260,13 @@ func (t *Trade) String() string {
 		t.Amount, t.Rate, t.Timestamp.Format(time.Stamp))
 }
 
+// returns a string version of a candle
+func (c *Candle) String() string {
+	return fmt.Sprintf("%s %s O:%.5f H:%.5f L:%.5f C:%.5f V:%.5f VWAP:%.5f N:%d",
+		c.Start.Format("2006-01-02T15:04:05"), c.Pair.String(),
+		c.Open, c.High, c.Low, c.Close, c.Volume, c.VWAP, c.Trades)
+}
+
 type TradeSorter struct {
 	Trades []*Trade
 	By     func(t1, t2 *Trade) bool
@@ -0,0 +1,199 @@
+package babelcoin
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// a source of time, so that timing behaviour can be controlled in tests
+// and replays instead of depending on the system clock
+type Clock interface {
+	// returns the current time
+	Now() time.Time
+
+	// returns a ticker that ticks every d
+	NewTicker(d time.Duration) Ticker
+
+	// returns a channel that receives the time after d has passed
+	After(d time.Duration) <-chan time.Time
+
+	// blocks until d has passed
+	Sleep(d time.Duration)
+}
+
+type Ticker interface {
+	// the channel that ticks are delivered on
+	C() <-chan time.Time
+
+	// stops the ticker, no more ticks are delivered
+	Stop()
+}
+
+// returns the clock in the clock config, or the system clock
+func ConfigClock(config map[string]interface{}) Clock {
+	if clock, ok := config["clock"].(Clock); ok {
+		return clock
+	}
+	return RealClock{}
+}
+
+// implemented by exchanges that keep their own time, like drivers that
+// replay data and the drivers that wrap them
+type Clocked interface {
+	// returns the clock the exchange runs on
+	Clock() Clock
+}
+
+// returns the clock of the exchange if it keeps its own time, otherwise the
+// system clock
+func ExchangeClock(exchange Exchange) Clock {
+	if clocked, ok := exchange.(Clocked); ok {
+		return clocked.Clock()
+	}
+	return RealClock{}
+}
+
+// the system clock
+type RealClock struct{}
+
+func (c RealClock) Now() time.Time                         { return time.Now() }
+func (c RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (c RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+func (c RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t realTicker) Stop()               { t.ticker.Stop() }
+
+// a clock that only moves when it's set or advanced, timers and tickers fire
+// as the time passes them. like time.Ticker, ticks are dropped for slow receivers
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at      time.Time
+	period  time.Duration
+	channel chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// moves the clock to t, firing any timers and tickers that are due in order.
+// the clock never moves backwards
+func (c *FakeClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if t.Before(c.now) {
+		return
+	}
+
+	for {
+		sort.Stable(waiters(c.waiters))
+		if len(c.waiters) == 0 || c.waiters[0].at.After(t) {
+			break
+		}
+
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.channel <- w.at:
+		default:
+		}
+
+		// ticks in between would be dropped, so skip to the next after t
+		if w.period > 0 {
+			w.at = w.at.Add((t.Sub(w.at)/w.period + 1) * w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+
+	c.now = t
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.wait(d, 0).channel
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return &fakeTicker{c, c.wait(d, d)}
+}
+
+// the number of timers and tickers waiting to fire, so tests can wait for
+// goroutines to start waiting before advancing the clock
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+func (c *FakeClock) wait(d time.Duration, period time.Duration) *waiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	w := &waiter{at: c.now.Add(d), period: period, channel: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.channel <- c.now
+		return w
+	}
+
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+func (c *FakeClock) remove(w *waiter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	waiter *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.waiter.channel }
+func (t *fakeTicker) Stop()               { t.clock.remove(t.waiter) }
+
+type waiters []*waiter
+
+func (w waiters) Len() int           { return len(w) }
+func (w waiters) Less(i, j int) bool { return w[i].at.Before(w[j].at) }
+func (w waiters) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
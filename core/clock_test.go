@@ -0,0 +1,85 @@
+package babelcoin
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// an exchange with its own clock
+type clockedExchange struct {
+	Exchange
+	clock Clock
+}
+
+func (e clockedExchange) Clock() Clock {
+	return e.clock
+}
+
+func TestFakeClockSpec(t *testing.T) {
+	Convey("Subject: Fake Clock", t, func() {
+		start := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+		clock := NewFakeClock(start)
+
+		Convey(`Time should only move when advanced`, func() {
+			So(clock.Now(), ShouldResemble, start)
+			clock.Advance(time.Minute)
+			So(clock.Now(), ShouldResemble, start.Add(time.Minute))
+
+			clock.Set(start)
+			So(clock.Now(), ShouldResemble, start.Add(time.Minute))
+		})
+
+		Convey(`Timers should fire once their time has passed`, func() {
+			after := clock.After(time.Second)
+			clock.Advance(500 * time.Millisecond)
+			So(len(after), ShouldEqual, 0)
+
+			clock.Advance(500 * time.Millisecond)
+			So(<-after, ShouldResemble, start.Add(time.Second))
+			So(clock.Waiters(), ShouldEqual, 0)
+		})
+
+		Convey(`Sleep should block until the clock is advanced`, func() {
+			done := make(chan bool)
+			go func() {
+				clock.Sleep(time.Second)
+				done <- true
+			}()
+
+			for clock.Waiters() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			clock.Advance(time.Second)
+			So(<-done, ShouldBeTrue)
+		})
+
+		Convey(`Tickers should drop ticks for slow receivers until stopped`, func() {
+			ticker := clock.NewTicker(time.Second)
+			clock.Advance(time.Second)
+			So(<-ticker.C(), ShouldResemble, start.Add(time.Second))
+
+			clock.Advance(time.Hour)
+			So(<-ticker.C(), ShouldResemble, start.Add(2*time.Second))
+			So(len(ticker.C()), ShouldEqual, 0)
+
+			clock.Advance(time.Second)
+			So(<-ticker.C(), ShouldResemble, start.Add(time.Hour+2*time.Second))
+
+			ticker.Stop()
+			clock.Advance(time.Hour)
+			So(len(ticker.C()), ShouldEqual, 0)
+		})
+
+		Convey(`The clock should come from config`, func() {
+			So(ConfigClock(map[string]interface{}{"clock": clock}), ShouldEqual, clock)
+			So(ConfigClock(map[string]interface{}{}), ShouldResemble, RealClock{})
+		})
+
+		Convey(`The clock should come from exchanges that keep their own time`, func() {
+			So(ExchangeClock(clockedExchange{clock: clock}), ShouldEqual, clock)
+			So(ExchangeClock(clockedExchange{}.Exchange), ShouldResemble, RealClock{})
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package babelcoin
+
+import (
+	"strconv"
+	"time"
+)
+
+// returns a float from config, parsing strings, or the default if it's
+// missing or can't be parsed
+func ConfigFloat(config map[string]interface{}, key string, def float64) float64 {
+	switch v := config[key].(type) {
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// returns a duration from config, parsing strings like 5s, or the default
+// if it's missing or can't be parsed
+func ConfigDuration(config map[string]interface{}, key string, def time.Duration) time.Duration {
+	switch v := config[key].(type) {
+	case time.Duration:
+		return v
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
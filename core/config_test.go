@@ -0,0 +1,36 @@
+package babelcoin_test
+
+import (
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigSpec(t *testing.T) {
+	Convey("Subject: Reading config", t, func() {
+		config := map[string]interface{}{
+			"fee": 0.002, "spread": "0.01", "bad": "lots",
+			"interval": 5 * time.Second, "stale": "2m",
+		}
+
+		Convey(`Floats should be read from numbers and strings`, func() {
+			So(babel.ConfigFloat(config, "fee", 1), ShouldEqual, 0.002)
+			So(babel.ConfigFloat(config, "spread", 1), ShouldEqual, 0.01)
+			So(babel.ConfigFloat(config, "bad", 1), ShouldEqual, 1)
+			So(babel.ConfigFloat(config, "missing", 1), ShouldEqual, 1)
+		})
+
+		Convey(`Durations should be read from durations and strings`, func() {
+			So(babel.ConfigDuration(config, "interval", time.Hour), ShouldEqual, 5*time.Second)
+			So(babel.ConfigDuration(config, "stale", time.Hour), ShouldEqual, 2*time.Minute)
+			So(babel.ConfigDuration(config, "bad", time.Hour), ShouldEqual, time.Hour)
+		})
+
+		Convey(`Symbols should be found in lists`, func() {
+			So(babel.ContainsSymbol(babel.BTC, []babel.Symbol{babel.USD, babel.BTC}), ShouldBeTrue)
+			So(babel.ContainsSymbol(babel.LTC, []babel.Symbol{babel.USD, babel.BTC}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,191 @@
+package babelcoin
+
+import (
+	"math"
+	"sort"
+)
+
+// a change to an order book. amount is the new amount at the price, on the
+// bid side for buys and the ask side for sells, and zero removes the level.
+// sequence is zero if the exchange doesn't number its changes
+type BookDelta struct {
+	Pair     Pair
+	Type     TradeType
+	Price    float64
+	Amount   float64
+	Sequence int64
+}
+
+// implemented by exchanges that push changes to their order books as they
+// happen, rather than needing them to be polled
+type OrderBookStreamer interface {
+	OrderBookDeltas(pair Pair, channel chan<- BookDelta) error
+}
+
+// returns the highest bid, false if there are no bids
+func (o *OrderBook) BestBid() (Level, bool) {
+	best, ok := Level{}, false
+	for _, l := range o.Bids {
+		if !ok || l.Price > best.Price {
+			best, ok = l, true
+		}
+	}
+	return best, ok
+}
+
+// returns the lowest ask, false if there are no asks
+func (o *OrderBook) BestAsk() (Level, bool) {
+	best, ok := Level{}, false
+	for _, l := range o.Asks {
+		if !ok || l.Price < best.Price {
+			best, ok = l, true
+		}
+	}
+	return best, ok
+}
+
+// the difference between the best ask and the best bid, zero if either
+// side is empty
+func (o *OrderBook) Spread() float64 {
+	bid, hasBid := o.BestBid()
+	ask, hasAsk := o.BestAsk()
+	if !hasBid || !hasAsk {
+		return 0
+	}
+	return ask.Price - bid.Price
+}
+
+// the price half way between the best bid and ask, zero if either side is empty
+func (o *OrderBook) Mid() float64 {
+	bid, hasBid := o.BestBid()
+	ask, hasAsk := o.BestAsk()
+	if !hasBid || !hasAsk {
+		return 0
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+// returns a copy of the book with asks in ascending and bids in descending
+// order of price, so the best of each side is first
+func (o *OrderBook) Sorted() OrderBook {
+	sorted := OrderBook{
+		Asks: append([]Level{}, o.Asks...),
+		Bids: append([]Level{}, o.Bids...),
+	}
+	SortLevels(sorted.Asks, false)
+	SortLevels(sorted.Bids, true)
+	return sorted
+}
+
+// the amount of the base on each side within a fraction of the mid price,
+// e.g 0.01 for the depth within 1%
+func (o *OrderBook) Depth(fraction float64) (bids float64, asks float64) {
+	mid := o.Mid()
+	if mid == 0 {
+		return 0, 0
+	}
+
+	for _, l := range o.Bids {
+		if l.Price >= mid*(1-fraction) {
+			bids += l.Amount
+		}
+	}
+	for _, l := range o.Asks {
+		if l.Price <= mid*(1+fraction) {
+			asks += l.Amount
+		}
+	}
+	return bids, asks
+}
+
+// walks the side of the book that an order of type t fills against, and
+// returns the cost in the counter of filling amount and how much of it the
+// book could fill
+func (o *OrderBook) Cost(t TradeType, amount float64) (cost float64, filled float64) {
+	sorted := o.Sorted()
+	levels := sorted.Bids
+	if t == Buy {
+		levels = sorted.Asks
+	}
+
+	for _, l := range levels {
+		if filled >= amount {
+			break
+		}
+		take := math.Min(l.Amount, amount-filled)
+		cost += take * l.Price
+		filled += take
+	}
+	return cost, filled
+}
+
+// the average price of filling amount with an order of type t, zero if the
+// side is empty
+func (o *OrderBook) AveragePrice(t TradeType, amount float64) float64 {
+	cost, filled := o.Cost(t, amount)
+	if filled == 0 {
+		return 0
+	}
+	return cost / filled
+}
+
+// the fraction that the average price of filling amount is worse than the
+// best price, zero if the side is empty
+func (o *OrderBook) Slippage(t TradeType, amount float64) float64 {
+	average := o.AveragePrice(t, amount)
+	if average == 0 {
+		return 0
+	}
+
+	if t == Buy {
+		best, _ := o.BestAsk()
+		return (average - best.Price) / best.Price
+	}
+	best, _ := o.BestBid()
+	return (best.Price - average) / best.Price
+}
+
+// the difference between the amount bid and asked over their total, from
+// -1 when there are only asks to 1 when there are only bids. levels limits
+// it to the best levels of each side, 0 uses the whole book
+func (o *OrderBook) Imbalance(levels int) float64 {
+	sorted := o.Sorted()
+	bids, asks := 0.0, 0.0
+
+	for i, l := range sorted.Bids {
+		if levels > 0 && i >= levels {
+			break
+		}
+		bids += l.Amount
+	}
+	for i, l := range sorted.Asks {
+		if levels > 0 && i >= levels {
+			break
+		}
+		asks += l.Amount
+	}
+
+	if bids+asks == 0 {
+		return 0
+	}
+	return (bids - asks) / (bids + asks)
+}
+
+// sorts levels in place by price, ascending for asks and descending for bids
+func SortLevels(levels []Level, descending bool) {
+	sort.Sort(levelSorter{levels, descending})
+}
+
+type levelSorter struct {
+	levels     []Level
+	descending bool
+}
+
+func (l levelSorter) Len() int      { return len(l.levels) }
+func (l levelSorter) Swap(i, j int) { l.levels[i], l.levels[j] = l.levels[j], l.levels[i] }
+func (l levelSorter) Less(i, j int) bool {
+	if l.descending {
+		return l.levels[i].Price > l.levels[j].Price
+	}
+	return l.levels[i].Price < l.levels[j].Price
+}
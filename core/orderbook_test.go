@@ -0,0 +1,71 @@
+package babelcoin
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderBookSpec(t *testing.T) {
+	Convey("Subject: Order Book", t, func() {
+		book := OrderBook{
+			Asks: []Level{{102, 2}, {101, 1}, {110, 5}},
+			Bids: []Level{{98, 3}, {99, 1}, {90, 5}},
+		}
+
+		Convey(`The best levels should be found in any order`, func() {
+			bid, ok := book.BestBid()
+			So(ok, ShouldBeTrue)
+			So(bid, ShouldResemble, Level{99, 1})
+
+			ask, ok := book.BestAsk()
+			So(ok, ShouldBeTrue)
+			So(ask, ShouldResemble, Level{101, 1})
+
+			So(book.Spread(), ShouldEqual, 2)
+			So(book.Mid(), ShouldEqual, 100)
+		})
+
+		Convey(`Empty sides should have no best level or mid`, func() {
+			empty := OrderBook{Bids: book.Bids}
+			_, ok := empty.BestAsk()
+			So(ok, ShouldBeFalse)
+			So(empty.Spread(), ShouldEqual, 0)
+			So(empty.Mid(), ShouldEqual, 0)
+			So(empty.AveragePrice(Buy, 1), ShouldEqual, 0)
+		})
+
+		Convey(`Sorting should copy the book best first`, func() {
+			sorted := book.Sorted()
+			So(sorted.Asks[0].Price, ShouldEqual, 101)
+			So(sorted.Bids[0].Price, ShouldEqual, 99)
+			So(book.Asks[0].Price, ShouldEqual, 102)
+		})
+
+		Convey(`Depth should only count levels near the mid`, func() {
+			bids, asks := book.Depth(0.02)
+			So(bids, ShouldEqual, 4)
+			So(asks, ShouldEqual, 3)
+		})
+
+		Convey(`The cost of filling should walk the book`, func() {
+			cost, filled := book.Cost(Buy, 2)
+			So(cost, ShouldEqual, 101+102)
+			So(filled, ShouldEqual, 2)
+			So(book.AveragePrice(Buy, 2), ShouldEqual, 101.5)
+			So(book.Slippage(Buy, 2), ShouldAlmostEqual, 0.5/101)
+
+			cost, filled = book.Cost(Sell, 100)
+			So(cost, ShouldEqual, 99+294+450)
+			So(filled, ShouldEqual, 9)
+			So(book.Slippage(Sell, 1), ShouldEqual, 0)
+		})
+
+		Convey(`Imbalance should compare the amounts bid and asked`, func() {
+			So(book.Imbalance(1), ShouldEqual, 0)
+			So(book.Imbalance(0), ShouldAlmostEqual, (9.0-8.0)/17.0)
+			So((&OrderBook{Bids: book.Bids}).Imbalance(0), ShouldEqual, 1)
+			So((&OrderBook{}).Imbalance(0), ShouldEqual, 0)
+		})
+	})
+}
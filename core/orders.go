@@ -0,0 +1,139 @@
+package babelcoin
+
+import (
+	"errors"
+	"strings"
+)
+
+// returned by wrappers whose underlying account doesn't keep fills
+var ErrNoFills = errors.New("Fills aren't available for this account")
+
+// implemented by accounts that keep the fills of their own orders, so an
+// order that's no longer open can be told apart from a cancelled one
+type OrderFills interface {
+	// returns the fills of an order, empty if it never traded
+	OrderFills(id string) ([]Trade, error)
+}
+
+// a fill of one of our orders, with the fee charged on it in the counter
+// currency
+type Fill struct {
+	Trade
+	Fee float64
+}
+
+// implemented by accounts that keep the fills of all of their orders
+type FillHistory interface {
+	// returns the fills in the order they happened
+	Fills() ([]Fill, error)
+}
+
+// the progress of a tracked order since it was last seen
+type OrderUpdate struct {
+	Order  Order
+	Filled float64
+	Rate   float64
+	Closed bool
+}
+
+// compares tracked orders with the open orders of an account and works out
+// what has filled since. orders that are no longer open only count as
+// filled when the account's fills say so, accounts that don't keep fills
+// report them closed without a fill. the rate is the average price of the
+// new fills, or the order's rate when the account doesn't keep fills
+func SyncOrders(account ExchangeAccount, tracked map[string]Order, open []Order) ([]OrderUpdate, error) {
+	current := map[string]Order{}
+	for _, order := range open {
+		current[order.Id] = order
+	}
+
+	updates := []OrderUpdate{}
+	for id, order := range tracked {
+		now, ok := current[id]
+		if ok && now.Remains >= order.Remains && now.Remains > 0 {
+			continue
+		}
+
+		fills, err := orderFills(account, id)
+		if err != nil {
+			return nil, err
+		}
+
+		update := OrderUpdate{Order: order, Closed: !ok || now.Remains <= 0}
+		if ok {
+			update.Order = now
+			update.Filled = order.Remains - now.Remains
+			update.Rate = order.Rate
+		}
+
+		if fills != nil {
+			update.Filled, update.Rate = newFills(fills, order.Amount-order.Remains)
+			update.Order.Remains = order.Remains - update.Filled
+		}
+
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+// returns the funds that open limit orders hold back, the counter for buys
+// and the base for sells
+func ReservedBy(orders []Order) map[Symbol]float64 {
+	reserved := map[Symbol]float64{}
+	for _, order := range orders {
+		if order.Type == Buy && order.Rate > 0 {
+			reserved[order.Pair.Counter] += order.Remains * order.Rate
+		} else if order.Type == Sell && order.Rate > 0 {
+			reserved[order.Pair.Base] += order.Remains
+		}
+	}
+	return reserved
+}
+
+// returns the fills of an order with ids of the form <order id>-<n>, as
+// used by the simulated exchanges
+func FillsOf(id string, fills []Trade) []Trade {
+	matched := []Trade{}
+	for _, fill := range fills {
+		if strings.HasPrefix(fill.Id, id+"-") {
+			matched = append(matched, fill)
+		}
+	}
+	return matched
+}
+
+// fetches the fills of an order, nil if the account doesn't keep them
+func orderFills(account ExchangeAccount, id string) ([]Trade, error) {
+	history, ok := account.(OrderFills)
+	if !ok {
+		return nil, nil
+	}
+
+	fills, err := history.OrderFills(id)
+	if err == ErrNoFills {
+		return nil, nil
+	} else if fills == nil && err == nil {
+		fills = []Trade{}
+	}
+	return fills, err
+}
+
+// the amount and average price of the fills after the first seen amount
+func newFills(fills []Trade, seen float64) (float64, float64) {
+	amount, cost := 0.0, 0.0
+	for _, fill := range fills {
+		skip := fill.Amount
+		if seen < skip {
+			skip = seen
+		}
+		seen -= skip
+
+		amount += fill.Amount - skip
+		cost += (fill.Amount - skip) * fill.Rate
+	}
+
+	if amount <= 0 {
+		return 0, 0
+	}
+	return amount, cost / amount
+}
@@ -0,0 +1,68 @@
+package babelcoin_test
+
+import (
+	"testing"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// an account that doesn't keep fills
+type noFills struct {
+	babel.ExchangeAccount
+}
+
+func TestSyncOrdersSpec(t *testing.T) {
+	Convey("Subject: Syncing orders", t, func() {
+		exchange := fake.New("fake", map[string]interface{}{
+			"balances":    map[babel.Symbol]float64{babel.USD: 1000},
+			"market_data": []babel.MarketData{{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100}},
+		}).(*fake.Driver)
+
+		order, err := exchange.Trade(babel.Buy, babel.BTC_USD, 2, 95)
+		So(err, ShouldBeNil)
+		tracked := map[string]babel.Order{order.Id: order}
+
+		Convey(`Filled orders should report the price they filled at`, func() {
+			exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 92, Sell: 94, Last: 93})
+
+			updates, err := babel.SyncOrders(exchange, tracked, []babel.Order{})
+			So(err, ShouldBeNil)
+			So(len(updates), ShouldEqual, 1)
+			So(updates[0].Filled, ShouldEqual, 2)
+			So(updates[0].Rate, ShouldEqual, 94)
+			So(updates[0].Closed, ShouldBeTrue)
+		})
+
+		Convey(`Cancelled orders shouldn't be reported as filled`, func() {
+			So(exchange.CancelOrder(order), ShouldBeNil)
+
+			updates, err := babel.SyncOrders(exchange, tracked, []babel.Order{})
+			So(err, ShouldBeNil)
+			So(updates[0].Filled, ShouldEqual, 0)
+			So(updates[0].Closed, ShouldBeTrue)
+		})
+
+		Convey(`Orders that haven't changed shouldn't be reported`, func() {
+			updates, err := babel.SyncOrders(exchange, tracked, []babel.Order{order})
+			So(err, ShouldBeNil)
+			So(len(updates), ShouldEqual, 0)
+		})
+
+		Convey(`Accounts without fills should use the open orders`, func() {
+			open := order
+			open.Remains = 0.5
+
+			updates, err := babel.SyncOrders(noFills{}, tracked, []babel.Order{open})
+			So(err, ShouldBeNil)
+			So(updates[0].Filled, ShouldEqual, 1.5)
+			So(updates[0].Rate, ShouldEqual, 95)
+			So(updates[0].Closed, ShouldBeFalse)
+
+			updates, _ = babel.SyncOrders(noFills{}, tracked, []babel.Order{})
+			So(updates[0].Filled, ShouldEqual, 0)
+			So(updates[0].Closed, ShouldBeTrue)
+		})
+	})
+}
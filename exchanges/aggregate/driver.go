@@ -0,0 +1,517 @@
+/*
+A composite driver over several exchanges, in the form
+aggregate:<exchange>,<exchange>..., e.g aggregate:btce,bitcoincharts:bitstampUSD.
+
+Market data and tickers are consolidated across the venues that support
+a pair, with the best bid and ask across all of them. Pairs are the union
+of the venues' pairs, trade history is merged in timestamp order with
+each trade tagged with the venue it came from, and order books are merged
+by price. Orders can't be placed through the aggregate, use the account
+of a Venue.
+*/
+package aggregate
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Driver struct {
+	exchange string
+	names    []string
+	venues   map[string]b.Exchange
+	mutex    sync.Mutex
+	pairs    map[string][]b.Pair
+	tickers  map[ticker]*subscription
+}
+
+// a channel receiving consolidated market data for a pair
+type ticker struct {
+	pair    b.Pair
+	channel chan<- b.MarketData
+}
+
+// the venue tickers behind a ticker, done stops the goroutines reading them
+type subscription struct {
+	venues map[string]chan b.MarketData
+	done   chan bool
+}
+
+// creates a new aggregate driver, each venue is created from the comma
+// separated names with a copy of the config
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	parts := strings.SplitN(exchange, ":", 2)
+	if len(parts) != 2 {
+		panic("Exchange name must be in aggregate:xxxx,yyyy format")
+	}
+
+	venues := map[string]b.Exchange{}
+	for _, name := range strings.Split(parts[1], ",") {
+		venueConfig := map[string]interface{}{}
+		for k, v := range config {
+			venueConfig[k] = v
+		}
+
+		venue, err := b.NewExchange(name, venueConfig)
+		if err != nil {
+			panic(err)
+		}
+		venues[name] = venue
+	}
+
+	return Combine(exchange, venues)
+}
+
+// combines existing exchanges into an aggregate, keyed by the name that
+// their trades are tagged with
+func Combine(exchange string, venues map[string]b.Exchange) b.Exchange {
+	d := &Driver{
+		exchange: exchange,
+		venues:   venues,
+		pairs:    map[string][]b.Pair{},
+		tickers:  map[ticker]*subscription{},
+	}
+
+	for name, _ := range venues {
+		d.names = append(d.names, name)
+	}
+	sort.Strings(d.names)
+
+	return d
+}
+
+// returns the names of the venues in the aggregate
+func (d *Driver) Names() []string {
+	return append([]string{}, d.names...)
+}
+
+// returns a venue by name, or nil
+func (d *Driver) Venue(name string) b.Exchange {
+	return d.venues[name]
+}
+
+// returns the market data for a pair from each venue that supports it
+func (d *Driver) MarketDataByVenue(pair b.Pair) (map[string]b.MarketData, error) {
+	type result struct {
+		name string
+		data b.MarketData
+		err  error
+	}
+
+	names := d.supporting(pair)
+	results := make(chan result, len(names))
+	for _, name := range names {
+		go func(name string) {
+			data, err := d.venues[name].MarketData(pair)
+			results <- result{name, data, err}
+		}(name)
+	}
+
+	var err error
+	data := map[string]b.MarketData{}
+	for _ = range names {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		data[r.name] = r.data
+	}
+
+	if len(data) == 0 {
+		if err == nil {
+			err = errors.New("No venues support " + pair.String())
+		}
+		return data, err
+	}
+	return data, nil
+}
+
+// returns market data with the best bid and ask across venues, the volume
+// of all of them and the last price of the most recently updated
+func (d *Driver) MarketData(pair b.Pair) (b.MarketData, error) {
+	data, err := d.MarketDataByVenue(pair)
+	if err != nil {
+		return b.MarketData{}, err
+	}
+	return consolidate(pair, data), nil
+}
+
+func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
+	type update struct {
+		name string
+		data b.MarketData
+	}
+
+	var err error
+	updates := make(chan update, 100)
+	sub := &subscription{venues: map[string]chan b.MarketData{}, done: make(chan bool)}
+
+	for _, name := range d.supporting(pair) {
+		venue := make(chan b.MarketData, 10)
+		if err = d.venues[name].Ticker(pair, venue); err != nil {
+			log.Printf("Failed to subscribe to %s ticker: %v", name, err)
+			continue
+		}
+		sub.venues[name] = venue
+
+		go func(name string) {
+			for {
+				select {
+				case data := <-venue:
+					select {
+					case updates <- update{name, data}:
+					case <-sub.done:
+						return
+					}
+				case <-sub.done:
+					return
+				}
+			}
+		}(name)
+	}
+
+	if len(sub.venues) == 0 {
+		if err == nil {
+			err = errors.New("No venues support " + pair.String())
+		}
+		return err
+	}
+
+	d.mutex.Lock()
+	d.tickers[ticker{pair, channel}] = sub
+	d.mutex.Unlock()
+
+	go func() {
+		latest := map[string]b.MarketData{}
+		for {
+			select {
+			case u := <-updates:
+				latest[u.name] = u.data
+				select {
+				case channel <- consolidate(pair, latest):
+				case <-sub.done:
+					return
+				}
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// stops the venue tickers behind a ticker channel
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	d.mutex.Lock()
+	sub, ok := d.tickers[ticker{pair, channel}]
+	delete(d.tickers, ticker{pair, channel})
+	d.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	var err error
+	for name, venue := range sub.venues {
+		if stopErr := b.StopTicker(d.venues[name], pair, venue); stopErr != nil {
+			err = stopErr
+		}
+	}
+	close(sub.done)
+	return err
+}
+
+// returns the union of the pairs of all venues
+func (d *Driver) Pairs() ([]b.Pair, error) {
+	pairs := []b.Pair{}
+	for _, name := range d.names {
+		venuePairs, err := d.venuePairs(name)
+		if err != nil {
+			return []b.Pair{}, err
+		}
+		for _, pair := range venuePairs {
+			if !b.ContainsPair(pair, pairs) {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// returns trade history from all venues in timestamp order, with the
+// exchange of each trade set to the venue. every venue is waited for, and
+// like other drivers the channel is only closed on success
+func (d *Driver) TradeHistory(pairs []b.Pair, after time.Time, limit int, channel chan<- b.Trade) error {
+	type result struct {
+		trades []*b.Trade
+		err    error
+	}
+
+	results := make(chan result, len(d.names))
+	requests := 0
+
+	for _, name := range d.names {
+		venuePairs := []b.Pair{}
+		for _, pair := range pairs {
+			if d.supports(name, pair) {
+				venuePairs = append(venuePairs, pair)
+			}
+		}
+		if len(venuePairs) == 0 {
+			continue
+		}
+
+		requests++
+		go func(name string, venuePairs []b.Pair) {
+			venue := make(chan b.Trade, 1000)
+			errs := make(chan error, 1)
+			go func() {
+				errs <- d.venues[name].TradeHistory(venuePairs, after, limit, venue)
+			}()
+
+			trades := []*b.Trade{}
+			for {
+				select {
+				case trade, ok := <-venue:
+					if !ok {
+						// wait for the venue to return, it may have failed
+						var err error
+						if errs != nil {
+							err = <-errs
+						}
+						results <- result{trades, err}
+						return
+					}
+					trade.Exchange = name
+					trades = append(trades, &trade)
+				case err := <-errs:
+					// drivers close the channel on success, but not always on failure
+					if err != nil {
+						results <- result{nil, err}
+						return
+					}
+					errs = nil
+				}
+			}
+		}(name, venuePairs)
+	}
+
+	trades := []*b.Trade{}
+	var err error
+	for i := 0; i < requests; i++ {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+		}
+		trades = append(trades, r.trades...)
+	}
+	if err != nil {
+		return err
+	}
+
+	sort.Stable(b.TradeSorter{Trades: trades, By: func(t1, t2 *b.Trade) bool {
+		return t1.Timestamp.Before(t2.Timestamp)
+	}})
+
+	for _, trade := range trades {
+		channel <- *trade
+	}
+	close(channel)
+	return nil
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+// returns the balances of all venues added together
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	balances := map[b.Symbol]float64{}
+	for _, name := range d.names {
+		venue, err := d.venues[name].Account().Balance(symbols)
+		if err != nil {
+			return map[b.Symbol]float64{}, err
+		}
+		for symbol, amount := range venue {
+			balances[symbol] += amount
+		}
+	}
+	return balances, nil
+}
+
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	return b.Order{}, errors.New("Orders can't be placed on an aggregate, use a venue")
+}
+
+// returns the orders of all venues
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	orders := []b.Order{}
+	for _, name := range d.names {
+		venue, err := d.venues[name].Account().Orders(limit)
+		if err != nil {
+			return []b.Order{}, err
+		}
+		orders = append(orders, venue...)
+	}
+	return orders, nil
+}
+
+func (d *Driver) CancelOrder(order b.Order) error {
+	return errors.New("Orders can't be cancelled on an aggregate, use a venue")
+}
+
+// returns the transactions of all venues
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	transactions := []b.Transaction{}
+	for _, name := range d.names {
+		venue, err := d.venues[name].Account().Transactions(limit)
+		if err != nil {
+			return []b.Transaction{}, err
+		}
+		transactions = append(transactions, venue...)
+	}
+	return transactions, nil
+}
+
+// returns the order books of all venues merged by price
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	asks, bids := map[float64]float64{}, map[float64]float64{}
+	fetched := 0
+
+	var err error
+	for _, name := range d.supporting(pair) {
+		book, venueErr := d.venues[name].Account().OrderBook(pair, limit)
+		if venueErr != nil {
+			err = venueErr
+			continue
+		}
+		fetched++
+
+		for _, ask := range book.Asks {
+			asks[ask.Price] += ask.Amount
+		}
+		for _, bid := range book.Bids {
+			bids[bid.Price] += bid.Amount
+		}
+	}
+
+	if fetched == 0 {
+		if err == nil {
+			err = errors.New("No venues support " + pair.String())
+		}
+		return b.OrderBook{}, err
+	}
+
+	book := b.OrderBook{}
+	for _, price := range sortedPrices(asks, false) {
+		book.Asks = append(book.Asks, b.Level{price, asks[price]})
+	}
+	for _, price := range sortedPrices(bids, true) {
+		book.Bids = append(book.Bids, b.Level{price, bids[price]})
+	}
+
+	if limit > 0 && len(book.Asks) > limit {
+		book.Asks = book.Asks[:limit]
+	}
+	if limit > 0 && len(book.Bids) > limit {
+		book.Bids = book.Bids[:limit]
+	}
+	return book, nil
+}
+
+// returns the names of the venues that support a pair
+func (d *Driver) supporting(pair b.Pair) []string {
+	names := []string{}
+	for _, name := range d.names {
+		if d.supports(name, pair) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// returns true if a venue supports a pair, or if it's pairs are unknown
+func (d *Driver) supports(name string, pair b.Pair) bool {
+	pairs, err := d.venuePairs(name)
+	if err != nil {
+		return true
+	}
+	return b.ContainsPair(pair, pairs)
+}
+
+// returns the pairs of a venue, cached after the first success
+func (d *Driver) venuePairs(name string) ([]b.Pair, error) {
+	d.mutex.Lock()
+	pairs, ok := d.pairs[name]
+	d.mutex.Unlock()
+	if ok {
+		return pairs, nil
+	}
+
+	pairs, err := d.venues[name].Pairs()
+	if err != nil {
+		return pairs, err
+	}
+
+	d.mutex.Lock()
+	d.pairs[name] = pairs
+	d.mutex.Unlock()
+	return pairs, nil
+}
+
+// combines market data from venues into the best bid and ask
+func consolidate(pair b.Pair, venues map[string]b.MarketData) b.MarketData {
+	names := []string{}
+	for name, _ := range venues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := b.MarketData{Pair: pair, Sell: math.Inf(1)}
+	for _, name := range names {
+		data := venues[name]
+		if data.Buy > result.Buy {
+			result.Buy = data.Buy
+		}
+		if data.Sell > 0 && data.Sell < result.Sell {
+			result.Sell = data.Sell
+		}
+		if !data.Updated.Before(result.Updated) {
+			result.Last, result.Updated = data.Last, data.Updated
+		}
+		result.Volume += data.Volume
+	}
+
+	if math.IsInf(result.Sell, 1) {
+		result.Sell = 0
+	}
+	return result
+}
+
+// returns the prices of a side of a book, highest first for bids
+func sortedPrices(levels map[float64]float64, descending bool) []float64 {
+	prices := []float64{}
+	for price, _ := range levels {
+		prices = append(prices, price)
+	}
+
+	if descending {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	return prices
+}
+
+func init() {
+	b.AddExchangeFactory("aggregate", b.ExchangeFactory(New))
+}
@@ -0,0 +1,148 @@
+package aggregate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDriverSpec(t *testing.T) {
+	Convey("Subject: Aggregate Driver", t, func() {
+		now := time.Now()
+		btce := fake.New("btce", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 100},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 102, Last: 100, Volume: 10, Updated: now},
+				{Pair: babel.LTC_USD, Buy: 9, Sell: 11, Last: 10, Volume: 5, Updated: now},
+			},
+		}).(*fake.Driver)
+		bitstamp := fake.New("bitstamp", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 50, babel.BTC: 1},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 98, Sell: 101, Last: 99, Volume: 20, Updated: now.Add(-time.Minute)},
+			},
+		}).(*fake.Driver)
+
+		driver := Combine("aggregate", map[string]babel.Exchange{
+			"btce": btce, "bitstamp": bitstamp,
+		}).(*Driver)
+
+		Convey(`Market data should have the best bid and ask across venues`, func() {
+			data, err := driver.MarketData(babel.BTC_USD)
+			So(err, ShouldBeNil)
+			So(data.Buy, ShouldEqual, 99)
+			So(data.Sell, ShouldEqual, 101)
+			So(data.Last, ShouldEqual, 100)
+			So(data.Volume, ShouldEqual, 30)
+
+			_, err = driver.MarketData(babel.Pair{"doge", "btc"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Pairs should be the union of the venues`, func() {
+			pairs, err := driver.Pairs()
+			So(err, ShouldBeNil)
+			So(len(pairs), ShouldEqual, 2)
+		})
+
+		Convey(`Tickers should be merged`, func() {
+			channel := make(chan babel.MarketData, 10)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			<-channel
+			<-channel
+
+			bitstamp.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 100.5, Sell: 101, Updated: now})
+			data := <-channel
+			So(data.Buy, ShouldEqual, 100.5)
+			So(data.Sell, ShouldEqual, 101)
+		})
+
+		Convey(`Stopped tickers should stop the venue tickers`, func() {
+			channel := make(chan babel.MarketData)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			<-channel
+			So(driver.StopTicker(babel.BTC_USD, channel), ShouldBeNil)
+
+			// venue tickers left running would block once their buffers fill
+			sent := make(chan bool)
+			go func() {
+				for i := 0; i < 100; i++ {
+					btce.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 99, Sell: 102, Updated: now})
+				}
+				sent <- true
+			}()
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				So("venue tickers still running", ShouldBeNil)
+			}
+		})
+
+		Convey(`Trade history should be merged in order and tagged`, func() {
+			btce.AddTrades(
+				babel.Trade{Id: "1", Pair: babel.BTC_USD, Timestamp: now.Add(-3 * time.Second)},
+				babel.Trade{Id: "3", Pair: babel.BTC_USD, Timestamp: now.Add(-1 * time.Second)},
+			)
+			bitstamp.AddTrades(babel.Trade{Id: "2", Pair: babel.BTC_USD, Timestamp: now.Add(-2 * time.Second)})
+
+			channel := make(chan babel.Trade, 10)
+			So(driver.TradeHistory([]babel.Pair{babel.BTC_USD}, now.Add(-time.Hour), 0, channel), ShouldBeNil)
+
+			trades := []babel.Trade{}
+			for trade := range channel {
+				trades = append(trades, trade)
+			}
+			So(len(trades), ShouldEqual, 3)
+			So(trades[0].Exchange, ShouldEqual, "btce")
+			So(trades[1].Id, ShouldEqual, "2")
+			So(trades[1].Exchange, ShouldEqual, "bitstamp")
+			So(trades[2].Id, ShouldEqual, "3")
+		})
+
+		Convey(`A failing venue should fail the history without closing the channel`, func() {
+			btce.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Timestamp: now.Add(-time.Second)})
+			bitstamp.FailNext("TradeHistory", errors.New("down"))
+
+			channel := make(chan babel.Trade, 10)
+			So(driver.TradeHistory([]babel.Pair{babel.BTC_USD}, now.Add(-time.Hour), 0, channel), ShouldNotBeNil)
+
+			select {
+			case <-channel:
+				So("channel was written to or closed", ShouldBeNil)
+			default:
+			}
+		})
+
+		Convey(`Order books should be merged by price`, func() {
+			btce.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{102, 1}, {103, 1}},
+				Bids: []babel.Level{{99, 1}},
+			})
+			bitstamp.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{101, 2}, {102, 2}},
+				Bids: []babel.Level{{98, 1}},
+			})
+
+			book, err := driver.Account().OrderBook(babel.BTC_USD, 0)
+			So(err, ShouldBeNil)
+			So(len(book.Asks), ShouldEqual, 3)
+			So(book.Asks[0].Price, ShouldEqual, 101)
+			So(book.Asks[1].Amount, ShouldEqual, 3)
+			So(book.Bids[0].Price, ShouldEqual, 99)
+		})
+
+		Convey(`Balances should be summed and trading refused`, func() {
+			balances, err := driver.Account().Balance([]babel.Symbol{})
+			So(err, ShouldBeNil)
+			So(balances[babel.USD], ShouldEqual, 150)
+			So(balances[babel.BTC], ShouldEqual, 1)
+
+			_, err = driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 100)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -23,6 +23,7 @@ import (
 type Driver struct {
 	exchange string
 	config   map[string]interface{}
+	clock    b.Clock
 }
 
 // creates a new bitcoincharts driver
@@ -34,6 +35,7 @@ func New(exchange string, config map[string]interface{}) b.Exchange {
 	return &Driver{
 		exchange: exchange,
 		config:   config,
+		clock:    b.ConfigClock(config),
 	}
 }
 
@@ -100,9 +102,9 @@ func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
 		duration = time.Duration(5) * time.Second
 	}
 
-	ticker := time.NewTicker(duration)
+	ticker := d.clock.NewTicker(duration)
 	go func() {
-		for _ = range ticker.C {
+		for _ = range ticker.C() {
 			data, err := d.MarketData(pair)
 			if err != nil {
 				panic(err)
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	b "github.com/lox/babelcoin/core"
@@ -27,10 +28,19 @@ type Driver struct {
 	privateApi string
 	pairs      map[b.Pair]pairInfo
 	client     *util.JsonRPCClient
+	clock      b.Clock
+	mutex      sync.Mutex
+	tickers    map[ticker]chan bool
+}
+
+// a channel receiving market data for a pair
+type ticker struct {
+	pair    b.Pair
+	channel chan<- b.MarketData
 }
 
 func New(exchange string, config map[string]interface{}) b.Exchange {
-	driver := &Driver{config: config}
+	driver := &Driver{config: config, clock: b.ConfigClock(config), tickers: map[ticker]chan bool{}}
 
 	if url, ok := config["public_api_url"]; !ok {
 		driver.publicApi = "https://btc-e.com/api/3"
@@ -50,9 +60,10 @@ func New(exchange string, config map[string]interface{}) b.Exchange {
 func (d *Driver) privateApiClient() *util.JsonRPCClient {
 	if d.client == nil {
 		d.client = &util.JsonRPCClient{
-			d.privateApi,
-			d.config["key"].(string),
-			d.config["secret"].(string),
+			Url:    d.privateApi,
+			Key:    d.config["key"].(string),
+			Secret: d.config["secret"].(string),
+			Clock:  d.clock,
 		}
 	}
 	return d.client
@@ -82,7 +93,7 @@ func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
 
 	balances := map[b.Symbol]float64{}
 	for symbol, amount := range resp.Funds {
-		if len(symbols) == 0 || containsSymbol(b.Symbol(symbol), symbols) {
+		if len(symbols) == 0 || b.ContainsSymbol(b.Symbol(symbol), symbols) {
 			balances[b.Symbol(symbol)] = amount
 		}
 	}
@@ -96,7 +107,27 @@ func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
 		duration = time.Duration(5) * time.Second
 	}
 
-	return util.MarketDataPoller(d, pair, duration, channel)
+	stop := make(chan bool)
+	if err := util.MarketDataPoller(d.clock, d, pair, duration, channel, stop); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.tickers[ticker{pair, channel}] = stop
+	d.mutex.Unlock()
+	return nil
+}
+
+// stops polling market data for a ticker channel
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if stop, ok := d.tickers[ticker{pair, channel}]; ok {
+		close(stop)
+		delete(d.tickers, ticker{pair, channel})
+	}
+	return nil
 }
 
 func (d *Driver) pairInfo() (map[b.Pair]pairInfo, error) {
@@ -121,6 +152,34 @@ func (d *Driver) pairInfo() (map[b.Pair]pairInfo, error) {
 	return d.pairs, nil
 }
 
+// returns the fee for a pair, btce lists fees as a percentage
+func (d *Driver) Fee(pair b.Pair) (float64, error) {
+	info, err := d.pairInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	p, ok := info[pair]
+	if !ok {
+		return 0, errors.New("Unknown pair " + pair.String())
+	}
+	return p.Fee / 100, nil
+}
+
+// returns the limits on orders in a pair
+func (d *Driver) Rules(pair b.Pair) (b.PairRule, error) {
+	info, err := d.pairInfo()
+	if err != nil {
+		return b.PairRule{}, err
+	}
+
+	p, ok := info[pair]
+	if !ok {
+		return b.PairRule{}, errors.New("Unknown pair " + pair.String())
+	}
+	return b.PairRule{MinAmount: p.MinAmount, MinPrice: p.MinPrice, MaxPrice: p.MaxPrice, Precision: p.Precision}, nil
+}
+
 func (d *Driver) Pairs() ([]b.Pair, error) {
 	pairs := []b.Pair{}
 	info, err := d.pairInfo()
@@ -188,7 +247,33 @@ func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
 }
 
 func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
-	panic("Not implemented")
+	var resp map[string]struct {
+		Asks, Bids [][2]float64
+	}
+
+	url := fmt.Sprintf("%s/depth/%s", d.publicApi, pair.String())
+	if limit > 0 {
+		url = fmt.Sprintf("%s?limit=%d", url, limit)
+	}
+
+	if err := util.HttpGetJson(url, &resp); err != nil {
+		return b.OrderBook{}, publicApiError(err)
+	}
+
+	depth, ok := resp[pair.String()]
+	if !ok {
+		return b.OrderBook{}, errors.New("No order book returned for " + pair.String())
+	}
+
+	// levels are returned as [price, amount]
+	book := b.OrderBook{Asks: []b.Level{}, Bids: []b.Level{}}
+	for _, l := range depth.Asks {
+		book.Asks = append(book.Asks, b.Level{Price: l[0], Amount: l[1]})
+	}
+	for _, l := range depth.Bids {
+		book.Bids = append(book.Bids, b.Level{Price: l[0], Amount: l[1]})
+	}
+	return book, nil
 }
 
 func (d *Driver) Account() b.ExchangeAccount {
@@ -353,16 +438,6 @@ func publicApiError(err *util.HttpError) error {
 	return errors.New("API Error: " + er.Error)
 }
 
-// checks if a Symbol is in a slice of Symbols
-func containsSymbol(a b.Symbol, list []b.Symbol) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
-	}
-	return false
-}
-
 // flattens an array of Pairs into a string
 func flattenPairs(pairs []b.Pair) string {
 	var pairStrings = []string{}
@@ -43,9 +43,10 @@ func New(exchange string, config map[string]interface{}) b.Exchange {
 		exchange: exchange,
 		config:   config,
 		client: &util.JsonRPCClient{
-			config["private_api_url"].(string),
-			config["key"].(string),
-			config["secret"].(string),
+			Url:    config["private_api_url"].(string),
+			Key:    config["key"].(string),
+			Secret: config["secret"].(string),
+			Clock:  b.ConfigClock(config),
 		},
 	}
 }
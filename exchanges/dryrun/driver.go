@@ -0,0 +1,275 @@
+/*
+A driver that reads live data from an exchange but never places orders.
+
+The dry run driver wraps another exchange, in the form dryrun:<exchange>,
+e.g dryrun:btce. Trades are checked against the pair's rules and the
+balances, logged, and returned as orders that never fill. Reads, like
+balances and market data, pass through to the wrapped exchange.
+*/
+package dryrun
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Driver struct {
+	b.Exchange
+	exchange string
+	clock    b.Clock
+	mutex    sync.Mutex
+	orders   []b.Order
+	nextId   int
+}
+
+// in the form dryrun:btce
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	parts := strings.SplitN(exchange, ":", 2)
+	if len(parts) != 2 {
+		panic("Exchange name must be in dryrun:xxxx format")
+	}
+
+	live, err := b.NewExchange(parts[1], config)
+	if err != nil {
+		panic(err)
+	}
+
+	return Wrap(exchange, live, config)
+}
+
+// wraps an exchange so that orders are only simulated, accepts a clock as
+// config, otherwise the wrapped exchange's clock is used
+func Wrap(exchange string, live b.Exchange, config map[string]interface{}) *Driver {
+	d := &Driver{Exchange: live, exchange: exchange, clock: b.ExchangeClock(live)}
+	if clock, ok := config["clock"].(b.Clock); ok {
+		d.clock = clock
+	}
+	return d
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+func (d *Driver) Clock() b.Clock {
+	return d.clock
+}
+
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	return b.StopTicker(d.Exchange, pair, channel)
+}
+
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	return d.Exchange.Account().Balance(symbols)
+}
+
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	return d.Exchange.Account().Transactions(limit)
+}
+
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	return d.Exchange.Account().OrderBook(pair, limit)
+}
+
+// returns the fee of the wrapped exchange if it knows it
+func (d *Driver) Fee(pair b.Pair) (float64, error) {
+	if schedule, ok := d.Exchange.(b.FeeSchedule); ok {
+		return schedule.Fee(pair)
+	}
+	return 0, errors.New("Exchange doesn't provide fees")
+}
+
+// returns the open orders on the exchange followed by the simulated ones
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	orders, err := d.Exchange.Account().Orders(limit)
+	if err != nil {
+		return orders, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	orders = append(orders, d.orders...)
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+// checks and logs an order, returning it unfilled without placing it
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.validate(t, pair, amount, rate); err != nil {
+		log.Printf("Dry run rejected %s %s %.8f @ %.8f: %v", t, pair.String(), amount, rate, err)
+		return b.Order{}, err
+	}
+
+	if amount == -1 {
+		var err error
+		if amount, err = d.wholeBalance(t, pair, rate); err != nil {
+			return b.Order{}, err
+		}
+	}
+
+	d.nextId++
+	order := b.Order{
+		Id:        fmt.Sprintf("dryrun-%d", d.nextId),
+		Pair:      pair,
+		Type:      t,
+		Timestamp: d.clock.Now(),
+		Amount:    amount,
+		Remains:   amount,
+		Rate:      rate,
+	}
+
+	log.Printf("Dry run %s %s %.8f @ %.8f as order %s", t, pair.String(), amount, rate, order.Id)
+	if rate != -1 {
+		d.orders = append(d.orders, order)
+	}
+	return order, nil
+}
+
+// removes a simulated order, orders on the exchange are only logged
+func (d *Driver) CancelOrder(order b.Order) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	log.Printf("Dry run cancel of order %s", order.Id)
+	for i, o := range d.orders {
+		if o.Id == order.Id {
+			d.orders = append(d.orders[:i], d.orders[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// checks an order against the pair's rules and the balance that isn't
+// already used by simulated orders
+func (d *Driver) validate(t b.TradeType, pair b.Pair, amount float64, rate float64) error {
+	if t != b.Buy && t != b.Sell {
+		return errors.New("Unknown trade type " + string(t))
+	}
+	if amount <= 0 && amount != -1 {
+		return errors.New("Invalid order amount")
+	}
+	if rate <= 0 && rate != -1 {
+		return errors.New("Invalid order rate")
+	}
+
+	pairs, err := d.Pairs()
+	if err != nil {
+		return err
+	}
+	if !b.ContainsPair(pair, pairs) {
+		return errors.New("Unknown pair " + pair.String())
+	}
+
+	if rules, ok := d.Exchange.(b.PairRules); ok {
+		rule, err := rules.Rules(pair)
+		if err != nil {
+			return err
+		}
+		if err := checkRule(rule, amount, rate); err != nil {
+			return err
+		}
+	}
+
+	price := rate
+	if rate == -1 {
+		data, err := d.MarketData(pair)
+		if err != nil {
+			return err
+		}
+		if price = data.Buy; t == b.Buy {
+			price = data.Sell
+		}
+	}
+	if amount == -1 {
+		return nil
+	}
+
+	balances, err := d.available(pair)
+	if err != nil {
+		return err
+	}
+	if t == b.Buy && balances[pair.Counter] < amount*price {
+		return fmt.Errorf("Insufficient %s balance", pair.Counter)
+	} else if t == b.Sell && balances[pair.Base] < amount {
+		return fmt.Errorf("Insufficient %s balance", pair.Base)
+	}
+	return nil
+}
+
+// returns the balances of a pair less what simulated orders would use
+func (d *Driver) available(pair b.Pair) (map[b.Symbol]float64, error) {
+	balances, err := d.Exchange.Account().Balance([]b.Symbol{pair.Base, pair.Counter})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range d.orders {
+		if order.Type == b.Buy {
+			balances[order.Pair.Counter] -= order.Remains * order.Rate
+		} else {
+			balances[order.Pair.Base] -= order.Remains
+		}
+	}
+	return balances, nil
+}
+
+func (d *Driver) wholeBalance(t b.TradeType, pair b.Pair, rate float64) (float64, error) {
+	balances, err := d.available(pair)
+	if err != nil {
+		return 0, err
+	}
+	if t == b.Sell {
+		return balances[pair.Base], nil
+	}
+
+	if rate == -1 {
+		data, err := d.MarketData(pair)
+		if err != nil {
+			return 0, err
+		}
+		rate = data.Sell
+	}
+	if rate <= 0 {
+		return 0, errors.New("No price to size order for " + pair.String())
+	}
+	return balances[pair.Counter] / rate, nil
+}
+
+func checkRule(rule b.PairRule, amount float64, rate float64) error {
+	if amount != -1 && rule.MinAmount > 0 && amount < rule.MinAmount {
+		return fmt.Errorf("Amount %.8f is below the minimum of %.8f", amount, rule.MinAmount)
+	}
+	if rate == -1 {
+		return nil
+	}
+	if rule.MinPrice > 0 && rate < rule.MinPrice {
+		return fmt.Errorf("Rate %.8f is below the minimum of %.8f", rate, rule.MinPrice)
+	}
+	if rule.MaxPrice > 0 && rate > rule.MaxPrice {
+		return fmt.Errorf("Rate %.8f is above the maximum of %.8f", rate, rule.MaxPrice)
+	}
+	if rule.Precision > 0 {
+		scale := math.Pow10(rule.Precision)
+		if math.Abs(rate*scale-math.Floor(rate*scale+0.5)) > 1e-6 {
+			return fmt.Errorf("Rate %v has more than %d decimal places", rate, rule.Precision)
+		}
+	}
+	return nil
+}
+
+func init() {
+	b.AddExchangeFactory("dryrun", b.ExchangeFactory(New))
+}
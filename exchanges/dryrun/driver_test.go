@@ -0,0 +1,86 @@
+package dryrun
+
+import (
+	"testing"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a fake exchange with limits on its pairs
+type rulesExchange struct {
+	*fake.Driver
+}
+
+func (e rulesExchange) Rules(pair babel.Pair) (babel.PairRule, error) {
+	return babel.PairRule{MinAmount: 0.1, MinPrice: 1, MaxPrice: 1000, Precision: 3}, nil
+}
+
+func TestDryRunSpec(t *testing.T) {
+	Convey("Subject: Dry Run Driver", t, func() {
+		live := fake.New("fake", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 1000, babel.BTC: 1},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+		}).(*fake.Driver)
+
+		driver := Wrap("dryrun:fake", rulesExchange{live}, map[string]interface{}{})
+
+		Convey(`Orders should be simulated and not placed`, func() {
+			order, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 100)
+			So(err, ShouldBeNil)
+			So(order.Id, ShouldEqual, "dryrun-1")
+			So(order.Remains, ShouldEqual, 1)
+
+			placed, _ := live.Orders(0)
+			So(len(placed), ShouldEqual, 0)
+
+			orders, _ := driver.Account().Orders(0)
+			So(len(orders), ShouldEqual, 1)
+
+			So(driver.Account().CancelOrder(order), ShouldBeNil)
+			orders, _ = driver.Account().Orders(0)
+			So(len(orders), ShouldEqual, 0)
+		})
+
+		Convey(`Reads should pass through`, func() {
+			balances, err := driver.Account().Balance([]babel.Symbol{babel.USD})
+			So(err, ShouldBeNil)
+			So(balances[babel.USD], ShouldEqual, 1000)
+		})
+
+		Convey(`Orders should be checked against the pair rules`, func() {
+			_, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 0.01, 100)
+			So(err, ShouldNotBeNil)
+			_, err = driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 99.0001)
+			So(err, ShouldNotBeNil)
+			_, err = driver.Account().Trade(babel.Buy, babel.LTC_BTC, 1, 0.02)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Simulated orders should use up the balance`, func() {
+			_, err := driver.Account().Trade(babel.Sell, babel.BTC_USD, 0.6, 110)
+			So(err, ShouldBeNil)
+			_, err = driver.Account().Trade(babel.Sell, babel.BTC_USD, 0.6, 110)
+			So(err, ShouldNotBeNil)
+
+			order, err := driver.Account().Trade(babel.Sell, babel.BTC_USD, -1, 110)
+			So(err, ShouldBeNil)
+			So(order.Amount, ShouldAlmostEqual, 0.4)
+		})
+
+		Convey(`Drivers should be created by name`, func() {
+			driver := New("dryrun:fake", map[string]interface{}{
+				"balances": map[babel.Symbol]float64{babel.USD: 1000},
+				"market_data": []babel.MarketData{
+					{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+				},
+			})
+			order, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 100)
+			So(err, ShouldBeNil)
+			So(order.Id, ShouldEqual, "dryrun-1")
+		})
+	})
+}
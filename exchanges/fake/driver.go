@@ -0,0 +1,620 @@
+/*
+An in-memory exchange driver for testing code built on babelcoin
+without touching a real exchange.
+
+Market data, order books, public trades and balances are all scripted
+via the methods on Driver. Orders placed through the ExchangeAccount are
+matched against the scripted order book with a matching.Engine, and
+resting orders fill when the market data crosses them. Funds for resting
+orders are reserved from the balance until they fill or are cancelled.
+Failures or latency can be injected per method.
+*/
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/matching"
+)
+
+// the prefix of the ids of orders that make up a scripted order book
+const scripted = "book-"
+
+type Driver struct {
+	exchange     string
+	config       map[string]interface{}
+	mutex        sync.Mutex
+	marketData   map[b.Pair]b.MarketData
+	engines      map[b.Pair]*matching.Engine
+	trades       []b.Trade
+	balances     map[b.Symbol]float64
+	fees         map[string]float64
+	transactions []b.Transaction
+	tickers      map[b.Pair][]chan<- b.MarketData
+	failures     map[string][]error
+	latency      time.Duration
+	clock        b.Clock
+	fee          float64
+	nextId       int
+	nextBookId   int
+}
+
+// creates a new fake driver, accepts balances, market_data, fee, latency
+// and clock config
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	d := &Driver{
+		exchange:   exchange,
+		config:     config,
+		marketData: map[b.Pair]b.MarketData{},
+		engines:    map[b.Pair]*matching.Engine{},
+		balances:   map[b.Symbol]float64{},
+		fees:       map[string]float64{},
+		tickers:    map[b.Pair][]chan<- b.MarketData{},
+		failures:   map[string][]error{},
+		clock:      b.ConfigClock(config),
+	}
+
+	if balances, ok := config["balances"].(map[b.Symbol]float64); ok {
+		for symbol, amount := range balances {
+			d.balances[symbol] = amount
+		}
+	}
+
+	if data, ok := config["market_data"].([]b.MarketData); ok {
+		for _, md := range data {
+			d.marketData[md.Pair] = md
+		}
+	}
+
+	if fee, ok := config["fee"].(float64); ok {
+		d.fee = fee
+	}
+
+	if latency, ok := config["latency"].(time.Duration); ok {
+		d.latency = latency
+	}
+
+	return d
+}
+
+// sets the market data for a pair and pushes it to any tickers, resting
+// orders that the new prices cross are filled
+func (d *Driver) SetMarketData(data b.MarketData) {
+	if data.Updated.IsZero() {
+		data.Updated = d.clock.Now()
+	}
+
+	d.mutex.Lock()
+	d.marketData[data.Pair] = data
+	d.matchOrders(data.Pair)
+	channels := append([]chan<- b.MarketData{}, d.tickers[data.Pair]...)
+	d.mutex.Unlock()
+
+	for _, channel := range channels {
+		channel <- data
+	}
+}
+
+// sets the order book for a pair that orders are filled against, replacing
+// what's left of the last one. resting orders in the account are kept
+func (d *Driver) SetOrderBook(pair b.Pair, book b.OrderBook) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	engine := d.engine(pair)
+	for _, order := range engine.Orders() {
+		if strings.HasPrefix(order.Id, scripted) {
+			engine.Cancel(order.Id)
+		}
+	}
+
+	for t, levels := range map[b.TradeType][]b.Level{b.Sell: book.Asks, b.Buy: book.Bids} {
+		for _, l := range levels {
+			if l.Amount <= 0 || l.Price <= 0 {
+				continue
+			}
+			d.nextBookId++
+			engine.Add(b.Order{
+				Id:     scripted + strconv.Itoa(d.nextBookId),
+				Type:   t,
+				Amount: l.Amount,
+				Rate:   l.Price,
+			})
+		}
+	}
+}
+
+// sets the balance of a symbol in the account
+func (d *Driver) SetBalance(symbol b.Symbol, amount float64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.balances[symbol] = amount
+}
+
+// adds public trades to the trade history
+func (d *Driver) AddTrades(trades ...b.Trade) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, trade := range trades {
+		if trade.Exchange == "" {
+			trade.Exchange = d.exchange
+		}
+		d.trades = append(d.trades, trade)
+	}
+}
+
+// adds a transaction to the account
+func (d *Driver) AddTransaction(tx b.Transaction) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.transactions = append(d.transactions, tx)
+}
+
+// causes the next call to the named method (e.g "Trade") to return err,
+// repeated calls queue up further failures
+func (d *Driver) FailNext(method string, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.failures[method] = append(d.failures[method], err)
+}
+
+// sets a delay applied to every call against the driver
+func (d *Driver) SetLatency(latency time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.latency = latency
+}
+
+// applies latency and returns any injected failure for the method
+func (d *Driver) call(method string) error {
+	d.mutex.Lock()
+	latency := d.latency
+	var err error
+	if failures := d.failures[method]; len(failures) > 0 {
+		err, d.failures[method] = failures[0], failures[1:]
+	}
+	d.mutex.Unlock()
+
+	if latency > 0 {
+		d.clock.Sleep(latency)
+	}
+	return err
+}
+
+func (d *Driver) MarketData(pair b.Pair) (b.MarketData, error) {
+	if err := d.call("MarketData"); err != nil {
+		return b.MarketData{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	data, ok := d.marketData[pair]
+	if !ok {
+		return b.MarketData{}, errors.New("Unknown pair " + pair.String())
+	}
+	return data, nil
+}
+
+func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
+	if err := d.call("Ticker"); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.tickers[pair] = append(d.tickers[pair], channel)
+	data, ok := d.marketData[pair]
+	d.mutex.Unlock()
+
+	if ok {
+		channel <- data
+	}
+	return nil
+}
+
+// stops sending market data to a ticker channel
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	channels := []chan<- b.MarketData{}
+	for _, c := range d.tickers[pair] {
+		if c != channel {
+			channels = append(channels, c)
+		}
+	}
+	d.tickers[pair] = channels
+	return nil
+}
+
+func (d *Driver) Pairs() ([]b.Pair, error) {
+	if err := d.call("Pairs"); err != nil {
+		return []b.Pair{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	pairs := []b.Pair{}
+	for pair, _ := range d.marketData {
+		pairs = append(pairs, pair)
+	}
+	for pair, _ := range d.engines {
+		if !b.ContainsPair(pair, pairs) {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
+
+func (d *Driver) TradeHistory(pairs []b.Pair, after time.Time, limit int, channel chan<- b.Trade) error {
+	if err := d.call("TradeHistory"); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	trades := []b.Trade{}
+	for _, trade := range d.trades {
+		if b.ContainsPair(trade.Pair, pairs) && trade.Timestamp.After(after) {
+			trades = append(trades, trade)
+		}
+	}
+	d.mutex.Unlock()
+
+	if limit > 0 && len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+
+	for _, trade := range trades {
+		channel <- trade
+	}
+
+	close(channel)
+	return nil
+}
+
+// returns the fee from the fee config, the same for all pairs
+func (d *Driver) Fee(pair b.Pair) (float64, error) {
+	return d.fee, nil
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	if err := d.call("Balance"); err != nil {
+		return map[b.Symbol]float64{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	balances := map[b.Symbol]float64{}
+	for symbol, amount := range d.balances {
+		if len(symbols) == 0 || b.ContainsSymbol(symbol, symbols) {
+			balances[symbol] = amount
+		}
+	}
+	return balances, nil
+}
+
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	if err := d.call("Trade"); err != nil {
+		return b.Order{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if t != b.Buy && t != b.Sell {
+		return b.Order{}, errors.New("Unknown trade type " + string(t))
+	} else if rate <= 0 && rate != -1 {
+		return b.Order{}, errors.New("Invalid order rate")
+	}
+
+	engine := d.engine(pair)
+	book := engine.Book(0)
+	data, _ := d.marketData[pair]
+
+	// market orders fill against the book first, and then at the market price
+	market := data.Buy
+	if t == b.Buy {
+		market = data.Sell
+	}
+
+	if rate == -1 {
+		_, hasBid := book.BestBid()
+		_, hasAsk := book.BestAsk()
+		if market <= 0 && ((t == b.Buy && !hasAsk) || (t == b.Sell && !hasBid)) {
+			return b.Order{}, errors.New("No market to trade " + pair.String())
+		}
+	}
+
+	if amount == -1 {
+		if t == b.Sell {
+			amount = d.balances[pair.Base]
+		} else if rate != -1 {
+			amount = d.balances[pair.Counter] / rate
+		} else {
+			amount = affordable(book.Asks, d.balances[pair.Counter], market)
+		}
+	}
+
+	if amount <= 0 {
+		return b.Order{}, errors.New("Invalid order amount")
+	}
+
+	if t == b.Buy {
+		cost := amount * rate
+		if rate == -1 {
+			var filled float64
+			cost, filled = book.Cost(b.Buy, amount)
+			if filled < amount && market > 0 {
+				cost += (amount - filled) * market
+			}
+		}
+		if d.balances[pair.Counter] < cost {
+			return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Counter)
+		}
+	} else if d.balances[pair.Base] < amount {
+		return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Base)
+	}
+
+	d.nextId++
+	fills, order, err := engine.Submit(b.Order{
+		Id:        strconv.Itoa(d.nextId),
+		Pair:      pair,
+		Type:      t,
+		Timestamp: d.clock.Now(),
+		Amount:    amount,
+		Rate:      rate,
+	})
+	if err != nil {
+		return b.Order{}, err
+	}
+
+	for _, f := range fills {
+		d.fill(order, f.Rate, f.Amount)
+		if !strings.HasPrefix(f.Maker.Id, scripted) {
+			d.reserve(f.Maker, -f.Amount)
+			d.fill(f.Maker, f.Rate, f.Amount)
+		}
+	}
+
+	// market orders never rest on the book, fill the rest at the market price
+	if rate == -1 && order.Remains > 0 && market > 0 {
+		d.fill(order, market, order.Remains)
+		order.Remains = 0
+	}
+
+	if _, resting := engine.Order(order.Id); resting {
+		d.reserve(order, order.Remains)
+		d.matchOrders(pair)
+		if o, ok := engine.Order(order.Id); ok {
+			order = o
+		} else {
+			order.Remains = 0
+		}
+	}
+
+	order.Fee = d.fees[order.Id]
+	return order, nil
+}
+
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	if err := d.call("Orders"); err != nil {
+		return []b.Order{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	orders := d.resting()
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (d *Driver) CancelOrder(order b.Order) error {
+	if err := d.call("CancelOrder"); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !strings.HasPrefix(order.Id, scripted) {
+		for _, engine := range d.engines {
+			if cancelled, err := engine.Cancel(order.Id); err == nil {
+				d.reserve(cancelled, -cancelled.Remains)
+				delete(d.fees, order.Id)
+				return nil
+			}
+		}
+	}
+	return errors.New("Unknown order " + order.Id)
+}
+
+// returns the fills of one of the account's orders
+func (d *Driver) OrderFills(id string) ([]b.Trade, error) {
+	if err := d.call("OrderFills"); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return b.FillsOf(id, d.trades), nil
+}
+
+// returns the funds held back from the balances by resting orders
+func (d *Driver) Reserved() (map[b.Symbol]float64, error) {
+	if err := d.call("Reserved"); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return b.ReservedBy(d.resting()), nil
+}
+
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	if err := d.call("Transactions"); err != nil {
+		return []b.Transaction{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	transactions := append([]b.Transaction{}, d.transactions...)
+	if limit > 0 && len(transactions) > limit {
+		transactions = transactions[len(transactions)-limit:]
+	}
+	return transactions, nil
+}
+
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	if err := d.call("OrderBook"); err != nil {
+		return b.OrderBook{}, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if engine, ok := d.engines[pair]; ok {
+		return engine.Book(limit), nil
+	}
+	return b.OrderBook{}, nil
+}
+
+// returns the matching engine for a pair, creating it if needed
+func (d *Driver) engine(pair b.Pair) *matching.Engine {
+	engine, ok := d.engines[pair]
+	if !ok {
+		engine = matching.New(d.exchange, pair)
+		engine.Clock = d.clock
+		d.engines[pair] = engine
+	}
+	return engine
+}
+
+// returns the account's resting orders in the order they were placed
+func (d *Driver) resting() []b.Order {
+	orders := []b.Order{}
+	for _, engine := range d.engines {
+		for _, order := range engine.Orders() {
+			if !strings.HasPrefix(order.Id, scripted) {
+				order.Fee = d.fees[order.Id]
+				orders = append(orders, order)
+			}
+		}
+	}
+	sort.Sort(byId(orders))
+	return orders
+}
+
+// fills resting orders for a pair that the current market data crosses
+func (d *Driver) matchOrders(pair b.Pair) {
+	data, ok := d.marketData[pair]
+	engine, hasEngine := d.engines[pair]
+	if !ok || !hasEngine {
+		return
+	}
+
+	for _, order := range d.resting() {
+		if order.Pair != pair {
+			continue
+		}
+
+		price := 0.0
+		if order.Type == b.Buy && data.Sell > 0 && data.Sell <= order.Rate {
+			price = data.Sell
+		} else if order.Type == b.Sell && data.Buy > 0 && data.Buy >= order.Rate {
+			price = data.Buy
+		}
+
+		if price > 0 {
+			engine.Cancel(order.Id)
+			d.reserve(order, -order.Remains)
+			d.fill(order, price, order.Remains)
+			delete(d.fees, order.Id)
+		}
+	}
+}
+
+// moves the funds for amount of a resting order out of the balance, or back
+// into it when amount is negative
+func (d *Driver) reserve(order b.Order, amount float64) {
+	if order.Type == b.Buy {
+		d.balances[order.Pair.Counter] -= amount * order.Rate
+	} else {
+		d.balances[order.Pair.Base] -= amount
+	}
+}
+
+// executes amount of an order at a price, updating balances and history
+func (d *Driver) fill(order b.Order, price float64, amount float64) {
+	fee := amount * price * d.fee
+	if order.Type == b.Buy {
+		d.balances[order.Pair.Counter] -= amount * price
+		d.balances[order.Pair.Base] += amount - amount*d.fee
+	} else {
+		d.balances[order.Pair.Base] -= amount
+		d.balances[order.Pair.Counter] += amount*price - fee
+	}
+	d.fees[order.Id] += fee
+
+	d.trades = append(d.trades, b.Trade{
+		Id:        fmt.Sprintf("%s-%d", order.Id, len(d.trades)),
+		Pair:      order.Pair,
+		Amount:    amount,
+		Rate:      price,
+		Timestamp: d.clock.Now(),
+		Type:      order.Type,
+		Exchange:  d.exchange,
+	})
+}
+
+// the amount that a budget buys walking the asks, and then at the market
+// price once they're exhausted
+func affordable(asks []b.Level, budget float64, market float64) float64 {
+	sorted := b.OrderBook{Asks: asks}
+	amount := 0.0
+	for _, l := range sorted.Sorted().Asks {
+		if cost := l.Amount * l.Price; cost < budget {
+			amount += l.Amount
+			budget -= cost
+		} else {
+			return amount + budget/l.Price
+		}
+	}
+	if market > 0 {
+		amount += budget / market
+	}
+	return amount
+}
+
+// sorts orders by their numeric id, the order they were placed in
+type byId []b.Order
+
+func (o byId) Len() int      { return len(o) }
+func (o byId) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o byId) Less(i, j int) bool {
+	if len(o[i].Id) != len(o[j].Id) {
+		return len(o[i].Id) < len(o[j].Id)
+	}
+	return o[i].Id < o[j].Id
+}
+
+func init() {
+	b.AddExchangeFactory("fake", b.ExchangeFactory(New))
+}
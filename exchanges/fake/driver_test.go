@@ -0,0 +1,144 @@
+package fake
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDriverSpec(t *testing.T) {
+	Convey("Subject: Fake Driver", t, func() {
+		driver := New("fake", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 1000, babel.BTC: 2},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+		}).(*Driver)
+
+		Convey(`Market data should be scripted`, func() {
+			data, err := driver.MarketData(babel.BTC_USD)
+			So(err, ShouldBeNil)
+			So(data.Last, ShouldEqual, 100)
+
+			_, err = driver.MarketData(babel.LTC_USD)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Tickers should receive scripted updates`, func() {
+			channel := make(chan babel.MarketData, 2)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			driver.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Last: 105})
+
+			So((<-channel).Last, ShouldEqual, 100)
+			So((<-channel).Last, ShouldEqual, 105)
+		})
+
+		Convey(`Market orders should walk the order book`, func() {
+			driver.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{101, 1}, {102, 1}},
+			})
+
+			order, err := driver.Trade(babel.Buy, babel.BTC_USD, 1.5, -1)
+			So(err, ShouldBeNil)
+			So(order.Remains, ShouldEqual, 0)
+
+			balances, _ := driver.Balance([]babel.Symbol{babel.USD, babel.BTC})
+			So(balances[babel.BTC], ShouldEqual, 3.5)
+			So(balances[babel.USD], ShouldEqual, 1000-101-51)
+		})
+
+		Convey(`Limit orders should rest until the market crosses them`, func() {
+			order, err := driver.Trade(babel.Sell, babel.BTC_USD, 1, 110)
+			So(err, ShouldBeNil)
+			So(order.Remains, ShouldEqual, 1)
+
+			orders, _ := driver.Orders(0)
+			So(len(orders), ShouldEqual, 1)
+
+			driver.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 111, Sell: 112})
+			orders, _ = driver.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+
+			balances, _ := driver.Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1111)
+		})
+
+		Convey(`Market orders without market data should be priced from the book`, func() {
+			driver.SetOrderBook(babel.LTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{10, 50}, {20, 100}},
+			})
+
+			_, err := driver.Trade(babel.Buy, babel.LTC_USD, 80, -1)
+			So(err, ShouldNotBeNil)
+
+			order, err := driver.Trade(babel.Buy, babel.LTC_USD, -1, -1)
+			So(err, ShouldBeNil)
+			So(order.Amount, ShouldEqual, 75)
+
+			balances, _ := driver.Balance([]babel.Symbol{babel.USD, babel.LTC})
+			So(balances[babel.USD], ShouldAlmostEqual, 0)
+			So(balances[babel.LTC], ShouldEqual, 75)
+		})
+
+		Convey(`Resting orders should reserve their funds until cancelled`, func() {
+			order, err := driver.Trade(babel.Buy, babel.BTC_USD, 5, 90)
+			So(err, ShouldBeNil)
+
+			balances, _ := driver.Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 550)
+
+			_, err = driver.Trade(babel.Buy, babel.BTC_USD, 7, 90)
+			So(err, ShouldNotBeNil)
+
+			So(driver.CancelOrder(order), ShouldBeNil)
+			balances, _ = driver.Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1000)
+		})
+
+		Convey(`Resting orders should be part of the order book`, func() {
+			driver.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{102, 1}},
+				Bids: []babel.Level{{98, 1}},
+			})
+			driver.Trade(babel.Buy, babel.BTC_USD, 1, 98)
+
+			book, _ := driver.OrderBook(babel.BTC_USD, 0)
+			So(book.Bids, ShouldResemble, []babel.Level{{98, 2}})
+			So(book.Asks, ShouldResemble, []babel.Level{{102, 1}})
+		})
+
+		Convey(`Orders exceeding the balance should fail`, func() {
+			_, err := driver.Trade(babel.Sell, babel.BTC_USD, 5, 100)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Injected failures should be returned once`, func() {
+			driver.FailNext("Balance", errors.New("boom"))
+
+			_, err := driver.Balance([]babel.Symbol{})
+			So(err, ShouldNotBeNil)
+
+			_, err = driver.Balance([]babel.Symbol{})
+			So(err, ShouldBeNil)
+		})
+
+		Convey(`Trade history should include fills`, func() {
+			driver.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 1, Rate: 100,
+				Timestamp: time.Now(), Type: babel.Buy})
+			driver.Trade(babel.Buy, babel.BTC_USD, 1, -1)
+
+			channel := make(chan babel.Trade, 10)
+			So(driver.TradeHistory([]babel.Pair{babel.BTC_USD}, time.Now().Add(-time.Minute), 100, channel), ShouldBeNil)
+
+			trades := []babel.Trade{}
+			for trade := range channel {
+				trades = append(trades, trade)
+			}
+			So(len(trades), ShouldEqual, 2)
+			So(trades[1].Rate, ShouldEqual, 101)
+		})
+	})
+}
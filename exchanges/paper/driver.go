@@ -0,0 +1,523 @@
+/*
+A paper trading driver that wraps a live exchange, in the form
+paper:<exchange>, e.g paper:btce.
+
+Market data, tickers, trade history and order books come from the live
+exchange, but the account is simulated locally with virtual balances.
+Orders are filled against the live order book when placed, and resting
+limit orders are filled by subsequent public trades, oldest order first,
+up to the amount traded. Funds for resting orders are reserved from the
+balance until they fill or are cancelled. State is persisted to the file
+in the state_file config between runs.
+*/
+package paper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// costs within this of a balance are treated as affordable
+const epsilon = 1e-9
+
+type Driver struct {
+	clock     b.Clock
+	exchange  string
+	config    map[string]interface{}
+	live      b.Exchange
+	mutex     sync.Mutex
+	state     *state
+	stateFile string
+	fee       float64
+	slippage  float64
+}
+
+// the simulated account, persisted between runs
+type state struct {
+	Balances     map[b.Symbol]float64
+	Orders       []b.Order
+	Fills        []b.Trade
+	Fees         map[string]float64
+	Transactions []b.Transaction
+	LastSync     time.Time
+	NextId       int
+}
+
+// creates a new paper driver, the live exchange is created from the
+// remainder of the exchange name
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	parts := strings.SplitN(exchange, ":", 2)
+	if len(parts) != 2 {
+		panic("Exchange name must be in paper:xxxx format")
+	}
+
+	live, err := b.NewExchange(parts[1], config)
+	if err != nil {
+		panic(err)
+	}
+
+	return Wrap(exchange, live, config)
+}
+
+// wraps an existing live exchange in a paper driver, accepts balances,
+// fee, slippage, fill_from, state_file and clock config
+func Wrap(exchange string, live b.Exchange, config map[string]interface{}) b.Exchange {
+	d := &Driver{
+		exchange: exchange,
+		config:   config,
+		live:     live,
+		fee:      b.ConfigFloat(config, "fee", 0.002),
+		slippage: b.ConfigFloat(config, "slippage", 0),
+	}
+
+	if clock, ok := config["clock"].(b.Clock); ok {
+		d.clock = clock
+	} else {
+		d.clock = b.ExchangeClock(live)
+	}
+	d.state = &state{Balances: map[b.Symbol]float64{}, LastSync: d.clock.Now()}
+
+	if file, ok := config["state_file"].(string); ok && file != "" {
+		d.stateFile = file
+	}
+
+	if d.stateFile != "" {
+		if err := d.loadState(); err == nil {
+			return d
+		} else if !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
+
+	switch balances := config["balances"].(type) {
+	case map[b.Symbol]float64:
+		for symbol, amount := range balances {
+			d.state.Balances[symbol] = amount
+		}
+	case string:
+		parsed, err := b.ParseBalances(balances)
+		if err != nil {
+			panic(err)
+		}
+		d.state.Balances = parsed
+	}
+
+	return d
+}
+
+func (d *Driver) MarketData(pair b.Pair) (b.MarketData, error) {
+	return d.live.MarketData(pair)
+}
+
+func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
+	return d.live.Ticker(pair, channel)
+}
+
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	return b.StopTicker(d.live, pair, channel)
+}
+
+// returns the clock of the live exchange, so pollers of a paper traded
+// replay follow the replay
+func (d *Driver) Clock() b.Clock {
+	return d.clock
+}
+
+func (d *Driver) Pairs() ([]b.Pair, error) {
+	return d.live.Pairs()
+}
+
+func (d *Driver) TradeHistory(pairs []b.Pair, after time.Time, limit int, channel chan<- b.Trade) error {
+	return d.live.TradeHistory(pairs, after, limit, channel)
+}
+
+// returns the simulated fee, the same for all pairs
+func (d *Driver) Fee(pair b.Pair) (float64, error) {
+	return d.fee, nil
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	return d.live.Account().OrderBook(pair, limit)
+}
+
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.sync(); err != nil {
+		return map[b.Symbol]float64{}, err
+	}
+
+	balances := map[b.Symbol]float64{}
+	for symbol, amount := range d.state.Balances {
+		if len(symbols) == 0 || b.ContainsSymbol(symbol, symbols) {
+			balances[symbol] = amount
+		}
+	}
+	return balances, nil
+}
+
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if t != b.Buy && t != b.Sell {
+		return b.Order{}, errors.New("Unknown trade type " + string(t))
+	} else if rate <= 0 && rate != -1 {
+		return b.Order{}, errors.New("Invalid order rate")
+	}
+
+	if err := d.sync(); err != nil {
+		return b.Order{}, err
+	}
+
+	levels, err := d.levels(t, pair)
+	if err != nil {
+		return b.Order{}, err
+	}
+
+	if amount == -1 {
+		if t == b.Sell {
+			amount = d.state.Balances[pair.Base]
+		} else if rate != -1 {
+			amount = d.state.Balances[pair.Counter] / rate
+		} else {
+			amount = d.affordable(levels, d.state.Balances[pair.Counter])
+		}
+	}
+
+	if amount <= 0 {
+		return b.Order{}, errors.New("Invalid order amount")
+	}
+
+	d.state.NextId++
+	order := b.Order{
+		Id:        strconv.Itoa(d.state.NextId),
+		Pair:      pair,
+		Type:      t,
+		Timestamp: d.clock.Now(),
+		Amount:    amount,
+		Remains:   amount,
+		Rate:      rate,
+	}
+
+	// work out the fills up front so that balances can be checked
+	type fill struct{ price, amount float64 }
+	fills := []fill{}
+	remains, cost := amount, 0.0
+	for _, level := range levels {
+		if remains <= 0 || (rate != -1 && !crosses(t, level.Price, rate)) {
+			break
+		}
+		filled := math.Min(level.Amount, remains)
+		fills = append(fills, fill{d.slip(t, level.Price), filled})
+		cost += filled * d.slip(t, level.Price)
+		remains -= filled
+	}
+
+	if rate == -1 && remains > 0 {
+		return b.Order{}, fmt.Errorf("Insufficient liquidity to fill %.8f %s", amount, pair.String())
+	} else if rate != -1 {
+		cost += remains * rate
+	}
+
+	if t == b.Buy && cost-d.state.Balances[pair.Counter] > epsilon {
+		return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Counter)
+	} else if t == b.Sell && d.state.Balances[pair.Base] < amount {
+		return b.Order{}, fmt.Errorf("Insufficient %s balance", pair.Base)
+	}
+
+	for _, f := range fills {
+		d.fill(&order, f.price, f.amount)
+	}
+
+	if order.Remains > 0 {
+		d.reserve(order, order.Remains)
+		d.state.Orders = append(d.state.Orders, order)
+	}
+
+	return order, d.saveState()
+}
+
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.sync(); err != nil {
+		return []b.Order{}, err
+	}
+
+	orders := append([]b.Order{}, d.state.Orders...)
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (d *Driver) CancelOrder(order b.Order) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i, o := range d.state.Orders {
+		if o.Id == order.Id {
+			d.reserve(o, -o.Remains)
+			d.state.Orders = append(d.state.Orders[:i], d.state.Orders[i+1:]...)
+			return d.saveState()
+		}
+	}
+	return errors.New("Unknown order " + order.Id)
+}
+
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	transactions := append([]b.Transaction{}, d.state.Transactions...)
+	if limit > 0 && len(transactions) > limit {
+		transactions = transactions[len(transactions)-limit:]
+	}
+	return transactions, nil
+}
+
+// returns the simulated fills of one of the account's orders
+func (d *Driver) OrderFills(id string) ([]b.Trade, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return b.FillsOf(id, d.state.Fills), nil
+}
+
+// returns the funds held back from the balances by resting orders
+func (d *Driver) Reserved() (map[b.Symbol]float64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return b.ReservedBy(d.state.Orders), nil
+}
+
+// returns the simulated fills for the account with their fees, fills
+// saved without a fee are charged the configured fee
+func (d *Driver) Fills() ([]b.Fill, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fills := []b.Fill{}
+	for _, trade := range d.state.Fills {
+		fee, ok := d.state.Fees[trade.Id]
+		if !ok {
+			fee = trade.Amount * trade.Rate * d.fee
+		}
+		fills = append(fills, b.Fill{Trade: trade, Fee: fee})
+	}
+	return fills, nil
+}
+
+// returns the levels of the live market an order of type t would fill
+// against, either from the order book or the ticker
+func (d *Driver) levels(t b.TradeType, pair b.Pair) ([]b.Level, error) {
+	if from, _ := d.config["fill_from"].(string); from != "ticker" {
+		book, err := d.live.Account().OrderBook(pair, 0)
+		if err != nil {
+			return nil, err
+		}
+		book = book.Sorted()
+		if t == b.Buy {
+			return book.Asks, nil
+		}
+		return book.Bids, nil
+	}
+
+	data, err := d.live.MarketData(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ticker has no depth, so assume it's all available at the price
+	price := data.Buy
+	if t == b.Buy {
+		price = data.Sell
+	}
+	return []b.Level{{price, math.Inf(1)}}, nil
+}
+
+// fills resting orders against public trades since the last sync
+func (d *Driver) sync() error {
+	if len(d.state.Orders) == 0 {
+		d.state.LastSync = d.clock.Now()
+		return nil
+	}
+
+	pairs := []b.Pair{}
+	for _, order := range d.state.Orders {
+		if !b.ContainsPair(order.Pair, pairs) {
+			pairs = append(pairs, order.Pair)
+		}
+	}
+
+	var trades = make(chan b.Trade)
+	since := d.state.LastSync
+	go func() {
+		if err := d.live.TradeHistory(pairs, since, 2000, trades); err != nil {
+			close(trades)
+		}
+	}()
+
+	changed := false
+	for trade := range trades {
+		// some exchanges ignore since and return their latest trades, which
+		// have already filled orders if they're before the last sync
+		if !trade.Timestamp.After(since) {
+			continue
+		}
+
+		// a trade only has its amount of liquidity to share between orders
+		available := trade.Amount
+		for i := range d.state.Orders {
+			order := &d.state.Orders[i]
+			if available <= 0 {
+				break
+			}
+			if order.Pair != trade.Pair || order.Remains <= 0 ||
+				!trade.Timestamp.After(order.Timestamp) || !crosses(order.Type, trade.Rate, order.Rate) {
+				continue
+			}
+			amount := math.Min(available, order.Remains)
+			d.reserve(*order, -amount)
+			d.fill(order, order.Rate, amount)
+			available -= amount
+			changed = true
+		}
+		if trade.Timestamp.After(d.state.LastSync) {
+			d.state.LastSync = trade.Timestamp
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	orders := []b.Order{}
+	for _, order := range d.state.Orders {
+		if order.Remains > 0 {
+			orders = append(orders, order)
+		}
+	}
+	d.state.Orders = orders
+
+	return d.saveState()
+}
+
+// moves the funds for amount of a resting order out of the balance, or back
+// into it when amount is negative
+func (d *Driver) reserve(order b.Order, amount float64) {
+	if order.Type == b.Buy {
+		d.state.Balances[order.Pair.Counter] -= amount * order.Rate
+	} else {
+		d.state.Balances[order.Pair.Base] -= amount
+	}
+}
+
+// executes amount of an order at a price, updating balances and fills
+func (d *Driver) fill(order *b.Order, price float64, amount float64) {
+	fee := amount * price * d.fee
+	if order.Type == b.Buy {
+		d.state.Balances[order.Pair.Counter] -= amount * price
+		d.state.Balances[order.Pair.Base] += amount - amount*d.fee
+	} else {
+		d.state.Balances[order.Pair.Base] -= amount
+		d.state.Balances[order.Pair.Counter] += amount*price - fee
+	}
+
+	order.Remains -= amount
+	order.Fee += fee
+
+	id := fmt.Sprintf("%s-%d", order.Id, len(d.state.Fills))
+	if d.state.Fees == nil {
+		d.state.Fees = map[string]float64{}
+	}
+	d.state.Fees[id] = fee
+
+	d.state.Fills = append(d.state.Fills, b.Trade{
+		Id:        id,
+		Pair:      order.Pair,
+		Amount:    amount,
+		Rate:      price,
+		Timestamp: d.clock.Now(),
+		Type:      order.Type,
+		Exchange:  d.exchange,
+	})
+}
+
+// applies the configured slippage against the trader
+// returns the amount that a budget buys walking up the asks after slippage
+func (d *Driver) affordable(asks []b.Level, budget float64) float64 {
+	amount := 0.0
+	for _, l := range asks {
+		price := d.slip(b.Buy, l.Price)
+		if cost := l.Amount * price; cost < budget {
+			amount += l.Amount
+			budget -= cost
+		} else {
+			return amount + budget/price
+		}
+	}
+	return amount
+}
+
+func (d *Driver) slip(t b.TradeType, price float64) float64 {
+	if t == b.Buy {
+		return price * (1 + d.slippage)
+	}
+	return price * (1 - d.slippage)
+}
+
+func (d *Driver) loadState() error {
+	bytes, err := ioutil.ReadFile(d.stateFile)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, d.state)
+}
+
+// writes state to a temp file first, so a crash never leaves it truncated
+func (d *Driver) saveState() error {
+	if d.stateFile == "" {
+		return nil
+	}
+
+	bytes, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(d.stateFile+".tmp", bytes, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(d.stateFile+".tmp", d.stateFile)
+}
+
+// returns true if a trade at price would fill an order at rate
+func crosses(t b.TradeType, price float64, rate float64) bool {
+	if t == b.Buy {
+		return price <= rate
+	}
+	return price >= rate
+}
+
+func init() {
+	b.AddExchangeFactory("paper", b.ExchangeFactory(New))
+}
@@ -0,0 +1,164 @@
+package paper
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a live exchange that returns its latest trades whatever the time asked for
+type latestTrades struct {
+	*fake.Driver
+}
+
+func (l latestTrades) TradeHistory(pairs []babel.Pair, after time.Time, limit int, channel chan<- babel.Trade) error {
+	return l.Driver.TradeHistory(pairs, time.Time{}, limit, channel)
+}
+
+func TestDriverSpec(t *testing.T) {
+	Convey("Subject: Paper Driver", t, func() {
+		live := fake.New("fake", map[string]interface{}{
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+		}).(*fake.Driver)
+		live.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{101, 1}, {102, 5}},
+			Bids: []babel.Level{{99, 1}, {98, 5}},
+		})
+
+		driver := Wrap("paper:fake", live, map[string]interface{}{
+			"balances": "usd:1000,btc:1",
+			"fee":      0.0,
+		})
+
+		Convey(`Market data should come from the live exchange`, func() {
+			data, err := driver.MarketData(babel.BTC_USD)
+			So(err, ShouldBeNil)
+			So(data.Last, ShouldEqual, 100)
+		})
+
+		Convey(`Market orders should fill against the live book`, func() {
+			order, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 2, -1)
+			So(err, ShouldBeNil)
+			So(order.Remains, ShouldEqual, 0)
+
+			balances, _ := driver.Account().Balance([]babel.Symbol{})
+			So(balances[babel.USD], ShouldEqual, 1000-101-102)
+			So(balances[babel.BTC], ShouldEqual, 3)
+
+			live, _ := live.Balance([]babel.Symbol{})
+			So(len(live), ShouldEqual, 0)
+		})
+
+		Convey(`Market buys of the whole balance should walk the book`, func() {
+			driver := Wrap("paper:fake", live, map[string]interface{}{
+				"balances": "usd:300",
+				"fee":      0.0,
+			})
+			order, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, -1, -1)
+			So(err, ShouldBeNil)
+			So(order.Amount, ShouldAlmostEqual, 1+199/102.0)
+
+			balances, _ := driver.Account().Balance([]babel.Symbol{})
+			So(balances[babel.USD], ShouldAlmostEqual, 0)
+		})
+
+		Convey(`Resting orders should fill from public trades`, func() {
+			order, err := driver.Account().Trade(babel.Sell, babel.BTC_USD, 1, 110)
+			So(err, ShouldBeNil)
+			So(order.Remains, ShouldEqual, 1)
+
+			live.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 0.4, Rate: 111,
+				Timestamp: time.Now().Add(time.Second), Type: babel.Buy})
+
+			orders, _ := driver.Account().Orders(0)
+			So(len(orders), ShouldEqual, 1)
+			So(orders[0].Remains, ShouldAlmostEqual, 0.6)
+
+			balances, _ := driver.Account().Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1044)
+		})
+
+		Convey(`A public trade should only fill its amount across resting orders`, func() {
+			driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 95)
+			driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 95)
+
+			live.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 1.5, Rate: 94,
+				Timestamp: time.Now().Add(time.Second), Type: babel.Sell})
+
+			orders, _ := driver.Account().Orders(0)
+			So(len(orders), ShouldEqual, 1)
+			So(orders[0].Remains, ShouldAlmostEqual, 0.5)
+
+			balances, _ := driver.Account().Balance([]babel.Symbol{})
+			So(balances[babel.BTC], ShouldAlmostEqual, 2.5)
+			So(balances[babel.USD], ShouldAlmostEqual, 1000-190)
+		})
+
+		Convey(`Public trades should only fill orders once`, func() {
+			driver := Wrap("paper:fake", latestTrades{live}, map[string]interface{}{
+				"balances": "btc:1",
+				"fee":      0.0,
+			})
+			driver.Account().Trade(babel.Sell, babel.BTC_USD, 1, 110)
+
+			live.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 0.4, Rate: 111,
+				Timestamp: time.Now().Add(time.Second), Type: babel.Buy})
+
+			orders, _ := driver.Account().Orders(0)
+			So(orders[0].Remains, ShouldAlmostEqual, 0.6)
+			orders, _ = driver.Account().Orders(0)
+			So(orders[0].Remains, ShouldAlmostEqual, 0.6)
+		})
+
+		Convey(`Resting orders should reserve their funds until cancelled`, func() {
+			order, _ := driver.Account().Trade(babel.Buy, babel.BTC_USD, 11, 90)
+
+			_, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldNotBeNil)
+
+			So(driver.Account().CancelOrder(order), ShouldBeNil)
+			balances, _ := driver.Account().Balance([]babel.Symbol{babel.USD})
+			So(balances[babel.USD], ShouldEqual, 1000)
+		})
+
+		Convey(`Fills should be kept with the fee charged on them`, func() {
+			charged := Wrap("paper:fake", live, map[string]interface{}{
+				"balances": "usd:1000",
+				"fee":      0.01,
+			})
+			order, err := charged.Account().Trade(babel.Buy, babel.BTC_USD, 2, -1)
+			So(err, ShouldBeNil)
+
+			fills, err := charged.(babel.FillHistory).Fills()
+			So(err, ShouldBeNil)
+			So(len(fills), ShouldEqual, 2)
+			So(fills[0].Fee, ShouldAlmostEqual, 1.01)
+			So(fills[0].Fee+fills[1].Fee, ShouldAlmostEqual, order.Fee)
+		})
+
+		Convey(`State should persist between runs`, func() {
+			dir, _ := ioutil.TempDir("", "paper")
+			defer os.RemoveAll(dir)
+			config := map[string]interface{}{
+				"balances":   "usd:1000",
+				"state_file": dir + "/state.json",
+			}
+
+			first := Wrap("paper:fake", live, config)
+			_, err := first.Account().Trade(babel.Buy, babel.BTC_USD, 1, 50)
+			So(err, ShouldBeNil)
+
+			second := Wrap("paper:fake", live, config)
+			orders, _ := second.Account().Orders(0)
+			So(len(orders), ShouldEqual, 1)
+			So(orders[0].Rate, ShouldEqual, 50)
+		})
+	})
+}
@@ -0,0 +1,314 @@
+/*
+A driver that plays back a recording made by the recorder package, in
+the form replay:<exchange>, e.g replay:btce.
+
+Recorded market data is pushed to tickers, and trades and order books
+become visible through TradeHistory and OrderBook as the playback
+reaches them. Playback starts on first use of the driver and runs against
+a virtual clock, at real time, a multiple of it with the speed config, or
+as fast as the consumers can read tickers with a speed of 0. The driver
+keeps the virtual Clock, so pollers and strategies driven from it follow
+the playback. The account is read-only, wrap the driver with paper
+trading to place orders against a replay.
+*/
+package replay
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/recorder"
+)
+
+type Driver struct {
+	clock      *b.FakeClock
+	exchange   string
+	config     map[string]interface{}
+	dir        string
+	name       string
+	from, to   time.Time
+	speed      float64
+	wall       b.Clock
+	mutex      sync.Mutex
+	marketData map[b.Pair]b.MarketData
+	books      map[b.Pair]b.OrderBook
+	trades     []b.Trade
+	tickers    map[b.Pair][]chan<- b.MarketData
+	start      sync.Once
+	done       chan bool
+	err        error
+}
+
+// creates a new replay driver, accepts dir, from, to and speed config and a
+// wall_clock that playback is paced against. the recording is read from
+// dir, named for the remainder of the exchange name
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	parts := strings.SplitN(exchange, ":", 2)
+	if len(parts) != 2 {
+		panic("Exchange name must be in replay:xxxx format")
+	}
+
+	d := &Driver{
+		exchange:   exchange,
+		config:     config,
+		dir:        "recordings",
+		name:       parts[1],
+		to:         b.ConfigClock(config).Now(),
+		speed:      1,
+		wall:       b.RealClock{},
+		marketData: map[b.Pair]b.MarketData{},
+		books:      map[b.Pair]b.OrderBook{},
+		tickers:    map[b.Pair][]chan<- b.MarketData{},
+		done:       make(chan bool),
+	}
+
+	if dir, ok := config["dir"].(string); ok && dir != "" {
+		d.dir = dir
+	}
+
+	if from, ok := config["from"].(time.Time); ok {
+		d.from = from
+	}
+
+	if to, ok := config["to"].(time.Time); ok {
+		d.to = to
+	}
+
+	switch speed := config["speed"].(type) {
+	case float64:
+		d.speed = speed
+	case string:
+		if f, err := strconv.ParseFloat(speed, 64); err == nil {
+			d.speed = f
+		}
+	}
+
+	if wall, ok := config["wall_clock"].(b.Clock); ok {
+		d.wall = wall
+	}
+
+	d.clock = b.NewFakeClock(d.from)
+	return d
+}
+
+// starts the playback if it hasn't started, this happens on the first use
+// of the driver. call Start after subscribing to tickers for several pairs
+// so none of the recording is missed
+func (d *Driver) Start() {
+	d.start.Do(func() {
+		go d.play()
+	})
+}
+
+// returns the virtual clock that follows the playback
+func (d *Driver) Clock() b.Clock {
+	return d.clock
+}
+
+// returns a channel that is closed when the playback is finished
+func (d *Driver) Done() <-chan bool {
+	return d.done
+}
+
+// returns the error that stopped the playback, if any
+func (d *Driver) Err() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.err
+}
+
+// plays back events, pacing them against the wall clock unless the speed is 0
+func (d *Driver) play() {
+	defer close(d.done)
+
+	events := make(chan recorder.Event, 100)
+	result := make(chan error, 1)
+	go func() {
+		result <- recorder.Replay(d.dir, d.name, d.from, d.to, events)
+	}()
+
+	var first time.Time
+	started := d.wall.Now()
+
+	for event := range events {
+		if d.speed > 0 {
+			if first.IsZero() {
+				first = event.Time
+			}
+			offset := time.Duration(float64(event.Time.Sub(first)) / d.speed)
+			if wait := started.Add(offset).Sub(d.wall.Now()); wait > 0 {
+				d.wall.Sleep(wait)
+			}
+		}
+
+		d.apply(event)
+	}
+
+	if err := <-result; err != nil {
+		d.mutex.Lock()
+		d.err = err
+		d.mutex.Unlock()
+	}
+}
+
+// applies an event and advances the clock to it, so anything woken by the
+// clock sees the event
+func (d *Driver) apply(event recorder.Event) {
+	channels := []chan<- b.MarketData{}
+
+	d.mutex.Lock()
+	switch event.Type {
+	case recorder.TradeEvent:
+		if event.Trade != nil {
+			d.trades = append(d.trades, *event.Trade)
+		}
+	case recorder.OrderBookEvent:
+		if event.OrderBook != nil {
+			d.books[event.Pair] = *event.OrderBook
+		}
+	case recorder.MarketDataEvent:
+		if event.MarketData != nil {
+			d.marketData[event.MarketData.Pair] = *event.MarketData
+			channels = append(channels, d.tickers[event.MarketData.Pair]...)
+		}
+	}
+	d.mutex.Unlock()
+
+	d.clock.Set(event.Time)
+
+	for _, channel := range channels {
+		channel <- *event.MarketData
+	}
+}
+
+func (d *Driver) MarketData(pair b.Pair) (b.MarketData, error) {
+	d.Start()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	data, ok := d.marketData[pair]
+	if !ok {
+		return b.MarketData{}, errors.New("No market data replayed for " + pair.String())
+	}
+	return data, nil
+}
+
+func (d *Driver) Ticker(pair b.Pair, channel chan<- b.MarketData) error {
+	d.mutex.Lock()
+	d.tickers[pair] = append(d.tickers[pair], channel)
+	data, ok := d.marketData[pair]
+	d.mutex.Unlock()
+
+	if ok {
+		channel <- data
+	}
+
+	d.Start()
+	return nil
+}
+
+// stops sending market data to a ticker channel
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	channels := []chan<- b.MarketData{}
+	for _, c := range d.tickers[pair] {
+		if c != channel {
+			channels = append(channels, c)
+		}
+	}
+	d.tickers[pair] = channels
+	return nil
+}
+
+// returns the pairs that have been replayed so far
+func (d *Driver) Pairs() ([]b.Pair, error) {
+	d.Start()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	pairs := []b.Pair{}
+	for pair, _ := range d.marketData {
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// returns the trades that have been replayed so far
+func (d *Driver) TradeHistory(pairs []b.Pair, after time.Time, limit int, channel chan<- b.Trade) error {
+	d.Start()
+	d.mutex.Lock()
+	trades := []b.Trade{}
+	for _, trade := range d.trades {
+		if b.ContainsPair(trade.Pair, pairs) && trade.Timestamp.After(after) {
+			trades = append(trades, trade)
+		}
+	}
+	d.mutex.Unlock()
+
+	if limit > 0 && len(trades) > limit {
+		trades = trades[len(trades)-limit:]
+	}
+
+	for _, trade := range trades {
+		channel <- trade
+	}
+
+	close(channel)
+	return nil
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+var errReadOnly = errors.New("Replay driver has no account, use paper:replay:xxxx to trade")
+
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	return map[b.Symbol]float64{}, errReadOnly
+}
+
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	return b.Order{}, errReadOnly
+}
+
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	return []b.Order{}, nil
+}
+
+func (d *Driver) CancelOrder(order b.Order) error {
+	return errReadOnly
+}
+
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	return []b.Transaction{}, nil
+}
+
+// returns the last replayed order book
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	d.Start()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	book, ok := d.books[pair]
+	if !ok {
+		return b.OrderBook{}, errors.New("No order book replayed for " + pair.String())
+	}
+
+	if limit > 0 && len(book.Asks) > limit {
+		book.Asks = book.Asks[:limit]
+	}
+	if limit > 0 && len(book.Bids) > limit {
+		book.Bids = book.Bids[:limit]
+	}
+	return book, nil
+}
+
+func init() {
+	b.AddExchangeFactory("replay", b.ExchangeFactory(New))
+}
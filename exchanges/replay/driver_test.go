@@ -0,0 +1,118 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/recorder"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// advances the clock once the playback is waiting on it
+func tick(clock *babel.FakeClock, d time.Duration) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(d)
+}
+
+func TestDriverSpec(t *testing.T) {
+	Convey("Subject: Replay Driver", t, func() {
+		dir, _ := ioutil.TempDir("", "replay")
+		defer os.RemoveAll(dir)
+
+		start := time.Date(2014, 3, 1, 10, 0, 0, 0, time.UTC)
+		r := recorder.NewRecorder(dir, "btce", nil, nil, map[string]interface{}{})
+		for i := 0; i < 3; i++ {
+			at := start.Add(time.Duration(i) * 100 * time.Millisecond)
+			data := babel.MarketData{Pair: babel.BTC_USD, Last: float64(100 + i), Updated: at}
+			trade := babel.Trade{Id: strconv.Itoa(i + 1), Pair: babel.BTC_USD, Amount: 1, Rate: data.Last, Timestamp: at}
+			r.Record(recorder.Event{Type: recorder.TradeEvent, Time: at, Pair: babel.BTC_USD, Trade: &trade})
+			r.Record(recorder.Event{Type: recorder.MarketDataEvent, Time: at, Pair: babel.BTC_USD, MarketData: &data})
+		}
+		book := babel.OrderBook{Asks: []babel.Level{{101, 1}, {102, 1}}}
+		r.Record(recorder.Event{Type: recorder.OrderBookEvent, Time: start.Add(250 * time.Millisecond), Pair: babel.BTC_USD, OrderBook: &book})
+		r.Close()
+
+		Convey(`Recorded feeds should be played back as fast as possible`, func() {
+			driver := New("replay:btce", map[string]interface{}{"dir": dir, "speed": 0.0}).(*Driver)
+			channel := make(chan babel.MarketData, 10)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			<-driver.Done()
+			So(driver.Err(), ShouldBeNil)
+
+			close(channel)
+			data := []babel.MarketData{}
+			for md := range channel {
+				data = append(data, md)
+			}
+
+			So(len(data), ShouldEqual, 3)
+			So(data[0].Last, ShouldEqual, 100)
+			So(data[2].Last, ShouldEqual, 102)
+			So(driver.Clock().Now(), ShouldResemble, start.Add(250*time.Millisecond))
+
+			trades := make(chan babel.Trade, 10)
+			So(driver.TradeHistory([]babel.Pair{babel.BTC_USD}, start, 0, trades), ShouldBeNil)
+			So((<-trades).Id, ShouldEqual, "2")
+			So((<-trades).Id, ShouldEqual, "3")
+
+			replayed, err := driver.OrderBook(babel.BTC_USD, 1)
+			So(err, ShouldBeNil)
+			So(len(replayed.Asks), ShouldEqual, 1)
+			So(replayed.Asks[0].Price, ShouldEqual, 101)
+		})
+
+		Convey(`Playback should stop at the time of the configured clock`, func() {
+			clock := babel.NewFakeClock(start.Add(150 * time.Millisecond))
+			driver := New("replay:btce", map[string]interface{}{"dir": dir, "speed": 0.0, "clock": clock}).(*Driver)
+			channel := make(chan babel.MarketData, 10)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			<-driver.Done()
+
+			So(len(channel), ShouldEqual, 2)
+		})
+
+		Convey(`Playback should be paced by the speed`, func() {
+			wall := babel.NewFakeClock(time.Now())
+			driver := New("replay:btce", map[string]interface{}{"dir": dir, "speed": 5.0, "wall_clock": wall}).(*Driver)
+			channel := make(chan babel.MarketData, 10)
+			So(driver.Ticker(babel.BTC_USD, channel), ShouldBeNil)
+			So((<-channel).Last, ShouldEqual, 100)
+
+			// the second event is 100ms into the recording, 20ms at 5x
+			tick(wall, 19*time.Millisecond)
+			So(len(channel), ShouldEqual, 0)
+			tick(wall, time.Millisecond)
+			So((<-channel).Last, ShouldEqual, 101)
+
+			tick(wall, 30*time.Millisecond)
+			<-driver.Done()
+			So(len(channel), ShouldEqual, 1)
+		})
+
+		Convey(`Trade history and order books should start the playback`, func() {
+			driver := New("replay:btce", map[string]interface{}{"dir": dir, "speed": 0.0}).(*Driver)
+			driver.TradeHistory([]babel.Pair{babel.BTC_USD}, start, 0, make(chan babel.Trade, 10))
+			<-driver.Done()
+
+			trades := make(chan babel.Trade, 10)
+			So(driver.TradeHistory([]babel.Pair{babel.BTC_USD}, start.Add(-time.Second), 0, trades), ShouldBeNil)
+			So(len(trades), ShouldEqual, 3)
+
+			_, err := driver.OrderBook(babel.BTC_USD, 0)
+			So(err, ShouldBeNil)
+		})
+
+		Convey(`The account should be read-only`, func() {
+			driver := New("replay:btce", map[string]interface{}{"dir": dir})
+			_, err := driver.Account().Trade(babel.Buy, babel.BTC_USD, 1, 100)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,500 @@
+/*
+A driver that checks orders against risk limits before they reach an exchange.
+
+The risk driver wraps another exchange, passing market data and reads
+through to it, and rejects orders that break a limit with a Violation:
+the size of an order, the notional value of the open orders in a pair,
+the number of open orders, how far a price is from the market, and the
+loss for the day. Breaking the daily loss limit trips the kill switch,
+which cancels every open order and rejects new ones until Reset.
+
+Fills of the orders placed through the driver are detected from the
+open orders of the wrapped account, an order that's no longer open and
+wasn't cancelled through the driver is taken to have filled. Fills can
+also be reported with Fill.
+*/
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// an order that was rejected for breaking a limit
+type Violation struct {
+	Rule   string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("Risk limit %s: %s", v.Rule, v.Reason)
+}
+
+type Driver struct {
+	b.Exchange
+	exchange    string
+	config      map[string]interface{}
+	clock       b.Clock
+	maxAmount   float64
+	maxNotional map[b.Pair]float64
+	notional    float64
+	maxOrders   int
+	priceBand   float64
+	maxLoss     map[b.Symbol]float64
+	loss        float64
+	mutex       sync.Mutex
+	killed      bool
+	orders      map[string]b.Order
+	day         time.Time
+	cash        map[b.Pair]float64
+	positions   map[b.Pair]float64
+}
+
+// in the form risk:btce
+func New(exchange string, config map[string]interface{}) b.Exchange {
+	parts := strings.SplitN(exchange, ":", 2)
+	if len(parts) != 2 {
+		panic("Exchange name must be in risk:xxxx format")
+	}
+
+	live, err := b.NewExchange(parts[1], config)
+	if err != nil {
+		panic(err)
+	}
+
+	return Wrap(exchange, live, config)
+}
+
+// wraps an exchange with risk checks. accepts max_amount for the largest
+// order in the base, max_notional for the most in the counter that open
+// orders in a pair can be worth (either a float or a map of pair to float),
+// max_orders, price_band for the fraction a price can be from the last
+// price, max_daily_loss in the counter (either a float or a map of symbol
+// to float) and a clock as config. floats may also be strings from env
+func Wrap(exchange string, live b.Exchange, config map[string]interface{}) *Driver {
+	d := &Driver{
+		Exchange:    live,
+		exchange:    exchange,
+		config:      config,
+		clock:       b.ExchangeClock(live),
+		maxAmount:   b.ConfigFloat(config, "max_amount", 0),
+		maxNotional: map[b.Pair]float64{},
+		notional:    b.ConfigFloat(config, "max_notional", 0),
+		maxOrders:   int(b.ConfigFloat(config, "max_orders", 0)),
+		priceBand:   b.ConfigFloat(config, "price_band", 0),
+		maxLoss:     map[b.Symbol]float64{},
+		loss:        b.ConfigFloat(config, "max_daily_loss", 0),
+		orders:      map[string]b.Order{},
+		cash:        map[b.Pair]float64{},
+		positions:   map[b.Pair]float64{},
+	}
+
+	if clock, ok := config["clock"].(b.Clock); ok {
+		d.clock = clock
+	}
+	if maxNotional, ok := config["max_notional"].(map[b.Pair]float64); ok {
+		d.maxNotional = maxNotional
+	}
+	if maxOrders, ok := config["max_orders"].(int); ok {
+		d.maxOrders = maxOrders
+	}
+	if maxLoss, ok := config["max_daily_loss"].(map[b.Symbol]float64); ok {
+		d.maxLoss = maxLoss
+	}
+
+	d.day = startOfDay(d.clock.Now())
+	return d
+}
+
+func (d *Driver) Account() b.ExchangeAccount {
+	return d
+}
+
+func (d *Driver) StopTicker(pair b.Pair, channel chan<- b.MarketData) error {
+	return b.StopTicker(d.Exchange, pair, channel)
+}
+
+func (d *Driver) Clock() b.Clock {
+	return d.clock
+}
+
+// the account that orders are passed to
+func (d *Driver) account() b.ExchangeAccount {
+	return d.Exchange.Account()
+}
+
+func (d *Driver) Balance(symbols []b.Symbol) (map[b.Symbol]float64, error) {
+	return d.account().Balance(symbols)
+}
+
+func (d *Driver) Orders(limit int) ([]b.Order, error) {
+	return d.account().Orders(limit)
+}
+
+func (d *Driver) Transactions(limit int) ([]b.Transaction, error) {
+	return d.account().Transactions(limit)
+}
+
+func (d *Driver) OrderFills(id string) ([]b.Trade, error) {
+	if history, ok := d.account().(b.OrderFills); ok {
+		return history.OrderFills(id)
+	}
+	return nil, b.ErrNoFills
+}
+
+func (d *Driver) Fills() ([]b.Fill, error) {
+	if history, ok := d.account().(b.FillHistory); ok {
+		return history.Fills()
+	}
+	return nil, b.ErrNoFills
+}
+
+func (d *Driver) Reserved() (map[b.Symbol]float64, error) {
+	if reserved, ok := d.account().(b.ReservedFunds); ok {
+		return reserved.Reserved()
+	}
+	return map[b.Symbol]float64{}, nil
+}
+
+func (d *Driver) OrderBook(pair b.Pair, limit int) (b.OrderBook, error) {
+	return d.account().OrderBook(pair, limit)
+}
+
+// checks an order against the limits before placing it. market orders are
+// priced from market data and orders for the whole balance are sized from
+// the balance, and placed with that size
+func (d *Driver) Trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.killed {
+		return b.Order{}, &Violation{"kill_switch", "trading is halted"}
+	}
+
+	open, err := d.sync()
+	if err != nil {
+		return b.Order{}, err
+	}
+
+	if err := d.enforceLoss(); err != nil {
+		return b.Order{}, err
+	}
+
+	data, err := d.MarketData(pair)
+	if err != nil {
+		return b.Order{}, err
+	}
+
+	price := rate
+	if rate == -1 {
+		if t == b.Buy {
+			price = data.Sell
+		} else {
+			price = data.Buy
+		}
+		if price <= 0 {
+			return b.Order{}, errors.New("No market price to check " + pair.String())
+		}
+	}
+
+	if amount == -1 {
+		if amount, err = d.wholeBalance(t, pair, price); err != nil {
+			return b.Order{}, err
+		}
+	}
+
+	if err := d.check(t, pair, amount, price, data, open); err != nil {
+		log.Printf("Rejected %s %s %.8f @ %.8f on %s: %v", t, pair.String(), amount, price, d.exchange, err)
+		return b.Order{}, err
+	}
+
+	order, err := d.account().Trade(t, pair, amount, rate)
+	if err != nil {
+		return order, err
+	}
+
+	// orders can fill as they are placed, at a price that's only known if
+	// the account keeps fills, otherwise the checked one is used
+	placed := order
+	placed.Remains = order.Amount
+	if placed.Rate <= 0 {
+		placed.Rate = price
+	}
+	d.orders[order.Id] = placed
+
+	updates, err := b.SyncOrders(d.account(), map[string]b.Order{order.Id: placed}, []b.Order{order})
+	if err != nil {
+		return order, err
+	}
+	d.apply(updates)
+	return order, nil
+}
+
+func (d *Driver) CancelOrder(order b.Order) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.account().CancelOrder(order); err != nil {
+		return err
+	}
+	delete(d.orders, order.Id)
+	return nil
+}
+
+// reports a fill of an order placed elsewhere towards the daily loss
+func (d *Driver) Fill(trade b.Trade) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.rollDay()
+	d.fill(trade.Type, trade.Pair, trade.Amount, trade.Rate)
+	if err := d.enforceLoss(); err != nil {
+		if _, ok := err.(*Violation); !ok {
+			log.Printf("Failed to check the loss on %s: %v", d.exchange, err)
+		}
+	}
+}
+
+// returns the profit for the day of each counter currency, with positions
+// taken since the start of the day marked to the last price
+func (d *Driver) DailyPnL() (map[b.Symbol]float64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.pnl()
+}
+
+// trips the kill switch, cancelling all open orders and rejecting new ones
+func (d *Driver) Kill() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.kill()
+}
+
+// returns true if the kill switch has been tripped
+func (d *Driver) Killed() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.killed
+}
+
+// allows trading again after the kill switch was tripped
+func (d *Driver) Reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.killed = false
+}
+
+func (d *Driver) kill() error {
+	d.killed = true
+	log.Printf("Kill switch tripped on %s, cancelling all orders", d.exchange)
+
+	orders, err := d.account().Orders(0)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+	for _, order := range orders {
+		if err := d.account().CancelOrder(order); err != nil {
+			log.Printf("Failed to cancel order %s: %v", order.Id, err)
+			failed = err
+			continue
+		}
+		delete(d.orders, order.Id)
+	}
+	return failed
+}
+
+// checks an order with a known amount and price against the limits
+func (d *Driver) check(t b.TradeType, pair b.Pair, amount float64, price float64, data b.MarketData, open []b.Order) error {
+	if amount <= 0 {
+		return &Violation{"amount", "orders need a positive amount"}
+	}
+
+	if d.maxAmount > 0 && amount > d.maxAmount {
+		return &Violation{"max_amount", fmt.Sprintf("%.8f is over %.8f", amount, d.maxAmount)}
+	}
+
+	if d.maxOrders > 0 && len(open) >= d.maxOrders {
+		return &Violation{"max_orders", fmt.Sprintf("%d orders are already open", len(open))}
+	}
+
+	if maxNotional := d.pairNotional(pair); maxNotional > 0 {
+		notional := amount * price
+		for _, order := range open {
+			if order.Pair == pair && order.Rate > 0 {
+				notional += order.Remains * order.Rate
+			}
+		}
+		if notional > maxNotional {
+			return &Violation{"max_notional", fmt.Sprintf("%.8f in %s is over %.8f", notional, pair.String(), maxNotional)}
+		}
+	}
+
+	if d.priceBand > 0 {
+		reference := lastPrice(data)
+		if reference <= 0 {
+			return &Violation{"price_band", "no market price for " + pair.String()}
+		}
+		if math.Abs(price-reference)/reference > d.priceBand {
+			return &Violation{"price_band", fmt.Sprintf("%.8f is too far from %.8f", price, reference)}
+		}
+	}
+
+	return nil
+}
+
+// returns an error if the loss for the day in any counter is over the limit
+func (d *Driver) checkLoss() error {
+	if d.loss <= 0 && len(d.maxLoss) == 0 {
+		return nil
+	}
+
+	pnl, err := d.pnl()
+	if err != nil {
+		return err
+	}
+
+	for symbol, profit := range pnl {
+		limit, ok := d.maxLoss[symbol]
+		if !ok {
+			limit = d.loss
+		}
+		if limit > 0 && -profit > limit {
+			return &Violation{"max_daily_loss", fmt.Sprintf("lost %.8f %s today", -profit, symbol)}
+		}
+	}
+	return nil
+}
+
+// checks the loss for the day, tripping the kill switch if it's over the limit
+func (d *Driver) enforceLoss() error {
+	err := d.checkLoss()
+	if _, ok := err.(*Violation); ok && !d.killed {
+		d.kill()
+	}
+	return err
+}
+
+func (d *Driver) pnl() (map[b.Symbol]float64, error) {
+	pnl := map[b.Symbol]float64{}
+	for pair, cash := range d.cash {
+		value := 0.0
+		if position := d.positions[pair]; position != 0 {
+			data, err := d.MarketData(pair)
+			if err != nil {
+				return nil, err
+			}
+			value = position * lastPrice(data)
+		}
+		pnl[pair.Counter] += cash + value
+	}
+	return pnl, nil
+}
+
+// detects fills of orders placed through the driver, and returns the open orders
+func (d *Driver) sync() ([]b.Order, error) {
+	d.rollDay()
+
+	open, err := d.account().Orders(0)
+	if err != nil {
+		return nil, err
+	}
+
+	updates, err := b.SyncOrders(d.account(), d.orders, open)
+	if err != nil {
+		return nil, err
+	}
+	d.apply(updates)
+	return open, nil
+}
+
+// tracks the fills of orders, forgetting those that are no longer open.
+// fills that break the loss limit trip the kill switch, other errors are
+// left for the caller to find when it checks the loss
+func (d *Driver) apply(updates []b.OrderUpdate) {
+	filled := false
+	for _, update := range updates {
+		order := update.Order
+		if update.Filled > 0 {
+			d.fill(order.Type, order.Pair, update.Filled, update.Rate)
+			filled = true
+		}
+
+		if update.Closed || order.Remains <= 0 {
+			delete(d.orders, order.Id)
+		} else {
+			order.Rate = d.orders[order.Id].Rate
+			d.orders[order.Id] = order
+		}
+	}
+
+	if filled {
+		d.enforceLoss()
+	}
+}
+
+// tracks the cash and position taken in a pair by a fill
+func (d *Driver) fill(t b.TradeType, pair b.Pair, amount float64, rate float64) {
+	if t == b.Buy {
+		d.cash[pair] -= amount * rate
+		d.positions[pair] += amount
+	} else {
+		d.cash[pair] += amount * rate
+		d.positions[pair] -= amount
+	}
+}
+
+// starts a new day of profit and loss at midnight utc
+func (d *Driver) rollDay() {
+	if day := startOfDay(d.clock.Now()); day.After(d.day) {
+		d.day = day
+		d.cash = map[b.Pair]float64{}
+		d.positions = map[b.Pair]float64{}
+	}
+}
+
+func (d *Driver) wholeBalance(t b.TradeType, pair b.Pair, price float64) (float64, error) {
+	balances, err := d.account().Balance([]b.Symbol{pair.Base, pair.Counter})
+	if err != nil {
+		return 0, err
+	}
+	if t == b.Sell {
+		return balances[pair.Base], nil
+	}
+	return balances[pair.Counter] / price, nil
+}
+
+func (d *Driver) pairNotional(pair b.Pair) float64 {
+	if notional, ok := d.maxNotional[pair]; ok {
+		return notional
+	}
+	return d.notional
+}
+
+// the last price, or the mid when there's no last price
+func lastPrice(data b.MarketData) float64 {
+	if data.Last <= 0 && data.Buy > 0 && data.Sell > 0 {
+		return (data.Buy + data.Sell) / 2
+	}
+	return data.Last
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func init() {
+	b.AddExchangeFactory("risk", b.ExchangeFactory(New))
+}
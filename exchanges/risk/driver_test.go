@@ -0,0 +1,174 @@
+package risk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDriverSpec(t *testing.T) {
+	Convey("Subject: Risk Driver", t, func() {
+		clock := babel.NewFakeClock(time.Date(2014, 1, 1, 12, 0, 0, 0, time.UTC))
+		live := fake.New("fake", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 10000, babel.BTC: 10},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+			"clock": clock,
+		}).(*fake.Driver)
+
+		config := map[string]interface{}{"clock": clock}
+		driver := func() *Driver { return Wrap("risk:fake", live, config) }
+
+		Convey(`Orders within the limits should be placed`, func() {
+			config["max_amount"] = 1.0
+			order, err := driver().Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldBeNil)
+			So(order.Remains, ShouldEqual, 1)
+		})
+
+		Convey(`Orders over the max amount should be rejected`, func() {
+			config["max_amount"] = 1.0
+			_, err := driver().Trade(babel.Buy, babel.BTC_USD, 2, 90)
+			So(err, ShouldNotBeNil)
+			So(err.(*Violation).Rule, ShouldEqual, "max_amount")
+
+			orders, _ := live.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+		})
+
+		Convey(`Orders for the whole balance should be sized before checking`, func() {
+			config["max_amount"] = 5.0
+			_, err := driver().Trade(babel.Sell, babel.BTC_USD, -1, -1)
+			So(err.(*Violation).Rule, ShouldEqual, "max_amount")
+		})
+
+		Convey(`Open orders should count towards the notional of a pair`, func() {
+			config["max_notional"] = map[babel.Pair]float64{babel.BTC_USD: 200}
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldBeNil)
+			_, err = d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldBeNil)
+			_, err = d.Trade(babel.Buy, babel.BTC_USD, 0.5, 90)
+			So(err.(*Violation).Rule, ShouldEqual, "max_notional")
+		})
+
+		Convey(`The number of open orders should be limited`, func() {
+			config["max_orders"] = 1
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldBeNil)
+			_, err = d.Trade(babel.Sell, babel.BTC_USD, 1, 110)
+			So(err.(*Violation).Rule, ShouldEqual, "max_orders")
+		})
+
+		Convey(`Prices far from the market should be rejected`, func() {
+			config["price_band"] = "0.05"
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 96)
+			So(err, ShouldBeNil)
+			_, err = d.Trade(babel.Sell, babel.BTC_USD, 1, 200)
+			So(err.(*Violation).Rule, ShouldEqual, "price_band")
+		})
+
+		Convey(`Losing more than the daily limit should trip the kill switch`, func() {
+			config["max_daily_loss"] = 5.0
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, -1)
+			So(err, ShouldBeNil)
+			_, err = d.Trade(babel.Sell, babel.BTC_USD, 1, 150)
+			So(err, ShouldBeNil)
+
+			live.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 89, Sell: 91, Last: 90})
+			pnl, _ := d.DailyPnL()
+			So(pnl[babel.USD], ShouldEqual, -11)
+
+			_, err = d.Trade(babel.Buy, babel.BTC_USD, 1, 80)
+			So(err.(*Violation).Rule, ShouldEqual, "max_daily_loss")
+			So(d.Killed(), ShouldBeTrue)
+
+			orders, _ := live.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+
+			Convey(`The loss should reset the next day`, func() {
+				clock.Advance(24 * time.Hour)
+				d.Reset()
+				_, err = d.Trade(babel.Buy, babel.BTC_USD, 1, 80)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey(`Fills that break the loss limit should trip the kill switch`, func() {
+			config["max_daily_loss"] = 5.0
+			d := driver()
+			_, err := d.Trade(babel.Sell, babel.BTC_USD, 1, 150)
+			So(err, ShouldBeNil)
+
+			d.Fill(babel.Trade{Type: babel.Buy, Pair: babel.BTC_USD, Amount: 1, Rate: 110})
+			So(d.Killed(), ShouldBeTrue)
+
+			orders, _ := live.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+		})
+
+		Convey(`Fills detected by the daily profit should trip the kill switch`, func() {
+			config["max_daily_loss"] = 0.5
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 95)
+			So(err, ShouldBeNil)
+
+			live.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 89, Sell: 91, Last: 90})
+			d.DailyPnL()
+			So(d.Killed(), ShouldBeTrue)
+		})
+
+		Convey(`Failing to work out the loss shouldn't trip the kill switch`, func() {
+			config["max_daily_loss"] = 5.0
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, -1)
+			So(err, ShouldBeNil)
+
+			live.FailNext("MarketData", errors.New("Exchange unavailable"))
+			_, err = d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err, ShouldNotBeNil)
+			So(d.Killed(), ShouldBeFalse)
+		})
+
+		Convey(`Fills detected from open orders should count towards the loss`, func() {
+			d := driver()
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 95)
+			So(err, ShouldBeNil)
+
+			live.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 89, Sell: 91, Last: 90})
+			pnl, _ := d.DailyPnL()
+			So(pnl[babel.USD], ShouldEqual, -1)
+		})
+
+		Convey(`Orders cancelled elsewhere shouldn't count towards the loss`, func() {
+			d := driver()
+			order, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 95)
+			So(err, ShouldBeNil)
+			So(live.CancelOrder(order), ShouldBeNil)
+
+			pnl, _ := d.DailyPnL()
+			So(pnl[babel.USD], ShouldEqual, 0)
+		})
+
+		Convey(`The kill switch should cancel orders and reject new ones`, func() {
+			d := driver()
+			d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(d.Kill(), ShouldBeNil)
+
+			orders, _ := live.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+
+			_, err := d.Trade(babel.Buy, babel.BTC_USD, 1, 90)
+			So(err.(*Violation).Rule, ShouldEqual, "kill_switch")
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package execution
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// creates an execution that places an equal share of what remains every
+// interval over a duration. accepts duration (default 1h), slices (default
+// 10) and a clock as config. open children are cancelled each slice and
+// their remainder carried into the next
+func NewTWAP(exchange b.Exchange, parent Parent, config map[string]interface{}) *Execution {
+	e := newExecution(TWAP, exchange, parent, config)
+
+	slices := 10
+	if s, ok := config["slices"].(int); ok && s > 0 {
+		slices = s
+	}
+	if e.duration <= 0 {
+		e.duration = time.Hour
+	}
+
+	e.interval = e.duration / time.Duration(slices)
+	e.cancelAll = true
+	e.schedule = func(e *Execution, remains float64, slice int, now time.Time) (float64, error) {
+		if slice >= slices {
+			return 0, nil
+		}
+		return remains / float64(slices-slice), nil
+	}
+	return e
+}
+
+// creates an execution that trades a fraction of the volume traded on the
+// market since it started. accepts participation (default 0.1), interval
+// (default 1m), an optional duration and a clock as config. open children
+// are cancelled each interval and replaced with what's behind the target
+func NewVWAP(exchange b.Exchange, parent Parent, config map[string]interface{}) *Execution {
+	e := newExecution(VWAP, exchange, parent, config)
+	participation := b.ConfigFloat(config, "participation", 0.1)
+
+	volume := 0.0
+	var after time.Time
+	seen := map[string]bool{}
+
+	e.cancelAll = true
+	e.schedule = func(e *Execution, remains float64, slice int, now time.Time) (float64, error) {
+		if participation <= 0 || participation > 1 {
+			return 0, errors.New("Participation must be between 0 and 1")
+		}
+
+		if slice == 0 {
+			after = now
+			return 0, nil
+		}
+
+		trades, err := tradeHistory(e.exchange, e.parent.Pair, after)
+		if err != nil {
+			log.Printf("Failed to fetch the volume for %s, using %d trades: %v", e.parent.Pair.String(), len(trades), err)
+		}
+
+		for _, trade := range trades {
+			if !seen[trade.Key()] && !trade.Timestamp.Before(after) {
+				seen[trade.Key()] = true
+				volume += trade.Amount
+			}
+		}
+
+		report := e.Report()
+		return participation*volume - report.Filled, nil
+	}
+	return e
+}
+
+// returns the trades in a pair after a time. drivers close the channel when
+// they succeed but may not when they fail, so the channel is never closed
+// here and reading stops at the driver's result
+func tradeHistory(exchange b.Exchange, pair b.Pair, after time.Time) ([]b.Trade, error) {
+	channel := make(chan b.Trade, 100)
+	result := make(chan error, 1)
+	go func() {
+		result <- exchange.TradeHistory([]b.Pair{pair}, after, 0, channel)
+	}()
+
+	trades := []b.Trade{}
+	for {
+		select {
+		case trade, ok := <-channel:
+			if !ok {
+				return trades, <-result
+			}
+			trades = append(trades, trade)
+		case err := <-result:
+			if err != nil {
+				return trades, err
+			}
+			for trade := range channel {
+				trades = append(trades, trade)
+			}
+			return trades, nil
+		}
+	}
+}
+
+// creates an execution that only shows a visible amount of a limit order,
+// placing the next slice when the last one has filled. accepts visible as
+// the amount to show, interval (default 10s) for how often fills are
+// checked, an optional duration and a clock as config
+func NewIceberg(exchange b.Exchange, parent Parent, config map[string]interface{}) *Execution {
+	e := newExecution(Iceberg, exchange, parent, config)
+	if _, ok := config["interval"]; !ok {
+		e.interval = 10 * time.Second
+	}
+	visible := b.ConfigFloat(config, "visible", 0)
+
+	e.schedule = func(e *Execution, remains float64, slice int, now time.Time) (float64, error) {
+		if visible <= 0 {
+			return 0, errors.New("A visible amount is needed")
+		}
+		if e.parent.Limit == -1 {
+			return 0, errors.New("Icebergs need a limit price")
+		}
+
+		e.mutex.Lock()
+		open := len(e.open)
+		e.mutex.Unlock()
+
+		if open > 0 {
+			return 0, nil
+		}
+		return visible, nil
+	}
+	return e
+}
@@ -0,0 +1,341 @@
+/*
+Algorithms for executing a large order as smaller child orders.
+
+An Execution slices a parent order into child orders placed with
+ExchangeAccount.Trade. TWAP spreads the parent evenly over a duration,
+VWAP follows the volume traded on the market at a participation rate,
+and Iceberg only ever shows a visible slice of a limit order on the book.
+
+Fills of the children are detected from the account's open orders, a
+child that's no longer open and wasn't cancelled is taken as filled.
+Children placed at market are taken to fill at the best price when they
+were placed.
+*/
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Algorithm string
+
+const (
+	TWAP    Algorithm = "twap"
+	VWAP    Algorithm = "vwap"
+	Iceberg Algorithm = "iceberg"
+)
+
+// amounts smaller than this are treated as zero
+const epsilon = 1e-9
+
+// the order to execute, a limit of -1 places children at market
+type Parent struct {
+	Pair   b.Pair
+	Type   b.TradeType
+	Amount float64
+	Limit  float64
+}
+
+// the progress of an execution, the average price is over the filled amount
+type Report struct {
+	Algorithm    Algorithm
+	Pair         b.Pair
+	Type         b.TradeType
+	Amount       float64
+	Filled       float64
+	Remains      float64
+	Cost         float64
+	AveragePrice float64
+	Children     []b.Order
+	Start, End   time.Time
+	Done         bool
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%s %s %.8f of %.8f %s @ %.8f in %d orders",
+		r.Algorithm, r.Type, r.Filled, r.Amount, r.Pair.String(), r.AveragePrice, len(r.Children))
+}
+
+// returns the amount of the next child order given the amount that's not
+// yet filled, the number of the slice and the time it's at
+type scheduler func(e *Execution, remains float64, slice int, now time.Time) (float64, error)
+
+type Execution struct {
+	exchange  b.Exchange
+	parent    Parent
+	config    map[string]interface{}
+	clock     b.Clock
+	interval  time.Duration
+	duration  time.Duration
+	schedule  scheduler
+	cancelAll bool
+	mutex     sync.Mutex
+	report    Report
+	open      map[string]b.Order
+	prices    map[string]float64
+	stop      chan bool
+}
+
+func newExecution(algorithm Algorithm, exchange b.Exchange, parent Parent, config map[string]interface{}) *Execution {
+	e := &Execution{
+		exchange: exchange,
+		parent:   parent,
+		config:   config,
+		clock:    b.ExchangeClock(exchange),
+		open:     map[string]b.Order{},
+		prices:   map[string]float64{},
+		stop:     make(chan bool, 1),
+		report: Report{
+			Algorithm: algorithm,
+			Pair:      parent.Pair,
+			Type:      parent.Type,
+			Amount:    parent.Amount,
+			Remains:   parent.Amount,
+			Children:  []b.Order{},
+		},
+	}
+
+	if clock, ok := config["clock"].(b.Clock); ok {
+		e.clock = clock
+	}
+	e.interval = b.ConfigDuration(config, "interval", time.Minute)
+	e.duration = b.ConfigDuration(config, "duration", 0)
+	return e
+}
+
+// executes the parent until it's filled, stopped or the duration has passed,
+// any children that are still open at the end are cancelled
+func (e *Execution) Run() (Report, error) {
+	if err := e.validate(); err != nil {
+		return e.Report(), err
+	}
+
+	ticker := e.clock.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	start := e.clock.Now()
+	e.mutex.Lock()
+	e.report.Start = start
+	e.mutex.Unlock()
+
+	var err error
+	for slice := 0; ; slice++ {
+		now := e.clock.Now()
+		if err = e.sync(); err != nil {
+			break
+		}
+
+		remains := e.Report().Remains
+		if remains <= epsilon || (e.duration > 0 && now.Sub(start) >= e.duration) {
+			break
+		}
+
+		if e.cancelAll {
+			if err = e.cancel(); err != nil {
+				break
+			}
+			remains = e.Report().Remains
+		}
+
+		amount, scheduleErr := e.schedule(e, remains, slice, now)
+		if scheduleErr != nil {
+			err = scheduleErr
+			break
+		}
+
+		if amount = math.Min(amount, remains); amount > epsilon {
+			if err = e.place(amount); err != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-e.stop:
+			e.sync()
+			return e.finish(nil)
+		}
+	}
+
+	return e.finish(err)
+}
+
+// stops a running execution
+func (e *Execution) Stop() {
+	select {
+	case e.stop <- true:
+	default:
+	}
+}
+
+// returns the progress of the execution
+func (e *Execution) Report() Report {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	report := e.report
+	report.Children = append([]b.Order{}, e.report.Children...)
+	return report
+}
+
+func (e *Execution) validate() error {
+	if e.parent.Type != b.Buy && e.parent.Type != b.Sell {
+		return errors.New("Unknown trade type " + string(e.parent.Type))
+	}
+	if e.parent.Amount <= 0 {
+		return errors.New("Parent orders need a positive amount")
+	}
+	if e.parent.Limit <= 0 && e.parent.Limit != -1 {
+		return errors.New("Parent orders need a limit, or -1 for market")
+	}
+	if e.interval <= 0 {
+		return errors.New("Interval must be positive")
+	}
+	return nil
+}
+
+// cancels the open children and finishes the report
+func (e *Execution) finish(err error) (Report, error) {
+	if cancelErr := e.cancel(); err == nil {
+		err = cancelErr
+	}
+
+	e.mutex.Lock()
+	e.report.End = e.clock.Now()
+	e.report.Done = e.report.Remains <= epsilon
+	e.mutex.Unlock()
+
+	report := e.Report()
+	log.Printf("Finished %s", report.String())
+	return report, err
+}
+
+// places a child order
+func (e *Execution) place(amount float64) error {
+	price := e.parent.Limit
+	if price == -1 {
+		data, err := e.exchange.MarketData(e.parent.Pair)
+		if err != nil {
+			return err
+		}
+		if price = data.Sell; e.parent.Type == b.Sell {
+			price = data.Buy
+		}
+	}
+
+	order, err := e.exchange.Account().Trade(e.parent.Type, e.parent.Pair, amount, e.parent.Limit)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.report.Children = append(e.report.Children, order)
+	e.prices[order.Id] = price
+
+	// children can fill as they are placed
+	placed := order
+	placed.Remains = order.Amount
+	e.open[order.Id] = placed
+
+	updates, err := b.SyncOrders(e.exchange.Account(), map[string]b.Order{order.Id: placed}, []b.Order{order})
+	if err != nil {
+		return err
+	}
+	e.apply(updates)
+	return nil
+}
+
+// detects fills of the open children
+func (e *Execution) sync() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.open) == 0 {
+		return nil
+	}
+
+	orders, err := e.exchange.Account().Orders(0)
+	if err != nil {
+		return err
+	}
+
+	updates, err := b.SyncOrders(e.exchange.Account(), e.open, orders)
+	if err != nil {
+		return err
+	}
+	e.apply(updates)
+	return nil
+}
+
+// records the fills of the open children, forgetting those that are done
+func (e *Execution) apply(updates []b.OrderUpdate) {
+	for _, update := range updates {
+		if update.Filled > 0 {
+			e.fill(update.Order.Id, update.Filled, update.Rate)
+		}
+
+		if update.Closed || update.Order.Remains <= epsilon {
+			delete(e.open, update.Order.Id)
+		} else {
+			e.open[update.Order.Id] = update.Order
+		}
+	}
+}
+
+// cancels the open children, children that can't be cancelled are synced
+// again in case they filled in the meantime
+func (e *Execution) cancel() error {
+	e.mutex.Lock()
+	open := []b.Order{}
+	for _, order := range e.open {
+		open = append(open, order)
+	}
+	e.mutex.Unlock()
+
+	var failed error
+	for _, order := range open {
+		if err := e.exchange.Account().CancelOrder(order); err != nil {
+			failed = err
+			continue
+		}
+		e.mutex.Lock()
+		delete(e.open, order.Id)
+		e.mutex.Unlock()
+	}
+
+	if failed != nil {
+		if err := e.sync(); err != nil {
+			return err
+		}
+		e.mutex.Lock()
+		stillOpen := len(e.open)
+		e.mutex.Unlock()
+		if stillOpen == 0 {
+			return nil
+		}
+	}
+	return failed
+}
+
+// records a fill of a child
+func (e *Execution) fill(id string, amount float64, rate float64) {
+	if rate <= 0 {
+		rate = e.prices[id]
+	}
+
+	e.report.Filled += amount
+	e.report.Remains = math.Max(0, e.report.Amount-e.report.Filled)
+	e.report.Cost += amount * rate
+	if e.report.Filled > 0 {
+		e.report.AveragePrice = e.report.Cost / e.report.Filled
+	}
+}
@@ -0,0 +1,215 @@
+package execution
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// runs an execution, advancing the clock by interval until it finishes
+func run(e *Execution, clock *babel.FakeClock, interval time.Duration, between func()) Report {
+	done := make(chan Report, 1)
+	go func() {
+		report, _ := e.Run()
+		done <- report
+	}()
+
+	for {
+		select {
+		case report := <-done:
+			return report
+		case <-time.After(5 * time.Millisecond):
+			if between != nil {
+				between()
+			}
+			clock.Advance(interval)
+		}
+	}
+}
+
+// waits for a condition that's met by another goroutine
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return condition()
+}
+
+// a driver that closes the trade channel before failing
+type failingHistory struct {
+	*fake.Driver
+}
+
+func (f failingHistory) TradeHistory(pairs []babel.Pair, after time.Time, limit int, channel chan<- babel.Trade) error {
+	close(channel)
+	return errors.New("Exchange unavailable")
+}
+
+func TestExecutionSpec(t *testing.T) {
+	Convey("Subject: Execution Algorithms", t, func() {
+		clock := babel.NewFakeClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+		exchange := fake.New("fake", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 100000, babel.BTC: 100},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100},
+			},
+			"clock": clock,
+		}).(*fake.Driver)
+
+		Convey(`TWAP should split the parent evenly over the duration`, func() {
+			e := NewTWAP(exchange, Parent{babel.BTC_USD, babel.Buy, 10, -1}, map[string]interface{}{
+				"duration": 5 * time.Minute,
+				"slices":   5,
+				"clock":    clock,
+			})
+
+			report := run(e, clock, time.Minute, nil)
+			So(report.Done, ShouldBeTrue)
+			So(report.Filled, ShouldAlmostEqual, 10)
+			So(report.AveragePrice, ShouldAlmostEqual, 101)
+			So(len(report.Children), ShouldEqual, 5)
+			So(report.Children[0].Amount, ShouldAlmostEqual, 2)
+		})
+
+		Convey(`TWAP should carry unfilled slices forward and cancel at the end`, func() {
+			e := NewTWAP(exchange, Parent{babel.BTC_USD, babel.Buy, 4, 90}, map[string]interface{}{
+				"duration": 2 * time.Minute,
+				"slices":   2,
+				"clock":    clock,
+			})
+
+			report := run(e, clock, time.Minute, nil)
+			So(report.Done, ShouldBeFalse)
+			So(report.Filled, ShouldEqual, 0)
+			So(report.Remains, ShouldEqual, 4)
+			So(report.Children[1].Amount, ShouldAlmostEqual, 4)
+
+			orders, _ := exchange.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+		})
+
+		Convey(`VWAP should trade a share of the market's volume`, func() {
+			e := NewVWAP(exchange, Parent{babel.BTC_USD, babel.Sell, 3, -1}, map[string]interface{}{
+				"participation": 0.5,
+				"interval":      time.Minute,
+				"clock":         clock,
+			})
+
+			trades := 0
+			report := run(e, clock, time.Minute, func() {
+				trades++
+				exchange.AddTrades(babel.Trade{
+					Id: string(rune('a' + trades)), Pair: babel.BTC_USD, Type: babel.Buy,
+					Amount: 2, Rate: 100, Timestamp: clock.Now().Add(time.Second),
+				})
+			})
+
+			So(report.Done, ShouldBeTrue)
+			So(report.Filled, ShouldAlmostEqual, 3)
+			So(report.AveragePrice, ShouldAlmostEqual, 99)
+			So(report.Children[0].Amount, ShouldAlmostEqual, 1)
+		})
+
+		Convey(`VWAP should count trades without ids`, func() {
+			e := NewVWAP(exchange, Parent{babel.BTC_USD, babel.Sell, 3, -1}, map[string]interface{}{
+				"participation": 0.5,
+				"interval":      time.Minute,
+				"clock":         clock,
+			})
+
+			report := run(e, clock, time.Minute, func() {
+				exchange.AddTrades(babel.Trade{
+					Pair: babel.BTC_USD, Type: babel.Buy, Amount: 2, Rate: 100,
+					Timestamp: clock.Now().Add(time.Second),
+				})
+			})
+
+			So(report.Done, ShouldBeTrue)
+			So(report.Filled, ShouldAlmostEqual, 3)
+		})
+
+		Convey(`Trade history should end on the driver's result`, func() {
+			exchange.AddTrades(babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 2, Rate: 100, Timestamp: clock.Now()})
+			trades, err := tradeHistory(exchange, babel.BTC_USD, clock.Now().Add(-time.Minute))
+			So(err, ShouldBeNil)
+			So(len(trades), ShouldEqual, 1)
+
+			_, err = tradeHistory(failingHistory{exchange}, babel.BTC_USD, clock.Now())
+			So(err, ShouldNotBeNil)
+
+			exchange.FailNext("TradeHistory", errors.New("Exchange unavailable"))
+			_, err = tradeHistory(exchange, babel.BTC_USD, clock.Now())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`Icebergs should only show the visible amount`, func() {
+			e := NewIceberg(exchange, Parent{babel.BTC_USD, babel.Buy, 2.5, 100}, map[string]interface{}{
+				"visible":  1.0,
+				"interval": time.Second,
+				"clock":    clock,
+			})
+
+			done := make(chan Report, 1)
+			go func() {
+				report, _ := e.Run()
+				done <- report
+			}()
+
+			for i := 1; i <= 3; i++ {
+				So(eventually(func() bool { return len(e.Report().Children) == i }), ShouldBeTrue)
+				orders, _ := exchange.Orders(0)
+				So(len(orders), ShouldEqual, 1)
+				So(orders[0].Remains, ShouldBeLessThanOrEqualTo, 1)
+
+				exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 98, Sell: 99, Last: 99})
+				exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 99, Sell: 101, Last: 100})
+				clock.Advance(time.Second)
+			}
+
+			var report Report
+			for report.End.IsZero() {
+				select {
+				case report = <-done:
+				case <-time.After(5 * time.Millisecond):
+					clock.Advance(time.Second)
+				}
+			}
+			So(report.Done, ShouldBeTrue)
+			So(report.Filled, ShouldAlmostEqual, 2.5)
+			So(report.Children[2].Amount, ShouldAlmostEqual, 0.5)
+		})
+
+		Convey(`Stopping should cancel the open children`, func() {
+			e := NewIceberg(exchange, Parent{babel.BTC_USD, babel.Buy, 5, 90}, map[string]interface{}{
+				"visible": 1.0,
+				"clock":   clock,
+			})
+
+			done := make(chan Report, 1)
+			go func() {
+				report, _ := e.Run()
+				done <- report
+			}()
+
+			So(eventually(func() bool { return len(e.Report().Children) == 1 }), ShouldBeTrue)
+			e.Stop()
+			report := <-done
+			So(report.Done, ShouldBeFalse)
+
+			orders, _ := exchange.Orders(0)
+			So(len(orders), ShouldEqual, 0)
+		})
+
+		Convey(`Invalid parents should fail`, func() {
+			_, err := NewTWAP(exchange, Parent{babel.BTC_USD, babel.Buy, 0, -1}, map[string]interface{}{}).Run()
+			So(err, ShouldNotBeNil)
+			_, err = NewIceberg(exchange, Parent{babel.BTC_USD, babel.Buy, 1, -1}, map[string]interface{}{"visible": 1.0}).Run()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,264 @@
+/*
+Exporting of account activity for accounting tools.
+
+Fills and transactions are written as ledger-cli or beancount journals,
+with each fill moving the base and counter between asset accounts and
+the fee charged on it booked as an expense. Fills come from accounts that
+implement core.FillHistory, like paper trading accounts. Disposals from
+an accounting.Ledger are written as a capital gains CSV report.
+*/
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lox/babelcoin/accounting"
+	b "github.com/lox/babelcoin/core"
+)
+
+// the accounts that journal entries are booked against
+type Options struct {
+	Account   string
+	Fees      string
+	Gains     string
+	Transfers string
+}
+
+// fills in the default accounts
+func (o Options) withDefaults() Options {
+	if o.Account == "" {
+		o.Account = "Assets:Exchange"
+	}
+	if o.Fees == "" {
+		o.Fees = "Expenses:Fees"
+	}
+	if o.Gains == "" {
+		o.Gains = "Income:Gains"
+	}
+	if o.Transfers == "" {
+		o.Transfers = "Equity:Transfers"
+	}
+	return o
+}
+
+// a fill or a transaction, in time order
+type entry struct {
+	time  time.Time
+	fill  *b.Fill
+	tx    *b.Transaction
+	order int
+}
+
+// writes a ledger-cli journal of fills and transactions
+func WriteLedger(w io.Writer, fills []b.Fill, transactions []b.Transaction, options Options) error {
+	options = options.withDefaults()
+
+	for _, e := range entries(fills, transactions) {
+		var err error
+		date := e.time.Format("2006/01/02")
+
+		if e.fill != nil {
+			f := e.fill
+			base, counter := commodity(f.Pair.Base), commodity(f.Pair.Counter)
+			fee := f.Fee
+			value := f.Amount * f.Rate
+
+			if f.Type == b.Buy {
+				_, err = fmt.Fprintf(w, "%s * %s%s\n    %s  %.8f %s @ %.8f %s\n    %s  %.8f %s\n    %s  %.8f %s\n\n",
+					date, description(f), comment(f.Id),
+					account(options.Account, base), f.Amount, base, f.Rate, counter,
+					options.Fees, fee, counter,
+					account(options.Account, counter), -(value + fee), counter)
+			} else {
+				_, err = fmt.Fprintf(w, "%s * %s%s\n    %s  %.8f %s @ %.8f %s\n    %s  %.8f %s\n    %s  %.8f %s\n\n",
+					date, description(f), comment(f.Id),
+					account(options.Account, base), -f.Amount, base, f.Rate, counter,
+					options.Fees, fee, counter,
+					account(options.Account, counter), value-fee, counter)
+			}
+		} else {
+			tx := e.tx
+			symbol := commodity(tx.Symbol)
+			_, err = fmt.Fprintf(w, "%s * %s%s\n    %s  %.8f %s\n    %s\n\n",
+				date, transfer(tx), comment(tx.Id),
+				account(options.Account, symbol), tx.Amount, symbol,
+				options.Transfers)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes a beancount journal of fills and transactions. sales reduce lots
+// at cost, with the gain booked to the gains account
+func WriteBeancount(w io.Writer, fills []b.Fill, transactions []b.Transaction, options Options) error {
+	options = options.withDefaults()
+	all := entries(fills, transactions)
+	if len(all) == 0 {
+		return nil
+	}
+
+	accounts := map[string]bool{options.Fees: true, options.Gains: true, options.Transfers: true}
+	for _, e := range all {
+		if e.fill != nil {
+			accounts[account(options.Account, commodity(e.fill.Pair.Base))] = true
+			accounts[account(options.Account, commodity(e.fill.Pair.Counter))] = true
+		} else {
+			accounts[account(options.Account, commodity(e.tx.Symbol))] = true
+		}
+	}
+
+	names := []string{}
+	for name, _ := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	opened := all[0].time.Format("2006-01-02")
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s open %s\n", opened, name); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, e := range all {
+		var err error
+		date := e.time.Format("2006-01-02")
+
+		if e.fill != nil {
+			f := e.fill
+			base, counter := commodity(f.Pair.Base), commodity(f.Pair.Counter)
+			fee := f.Fee
+			value := f.Amount * f.Rate
+
+			if f.Type == b.Buy {
+				_, err = fmt.Fprintf(w, "%s * %q%s\n  %s  %.8f %s {%.8f %s}\n  %s  %.8f %s\n  %s  %.8f %s\n\n",
+					date, description(f), comment(f.Id),
+					account(options.Account, base), f.Amount, base, f.Rate, counter,
+					options.Fees, fee, counter,
+					account(options.Account, counter), -(value + fee), counter)
+			} else {
+				_, err = fmt.Fprintf(w, "%s * %q%s\n  %s  %.8f %s {} @ %.8f %s\n  %s  %.8f %s\n  %s  %.8f %s\n  %s\n\n",
+					date, description(f), comment(f.Id),
+					account(options.Account, base), -f.Amount, base, f.Rate, counter,
+					options.Fees, fee, counter,
+					account(options.Account, counter), value-fee, counter,
+					options.Gains)
+			}
+		} else {
+			tx := e.tx
+			symbol := commodity(tx.Symbol)
+			_, err = fmt.Fprintf(w, "%s * %q%s\n  %s  %.8f %s\n  %s\n\n",
+				date, transfer(tx), comment(tx.Id),
+				account(options.Account, symbol), tx.Amount, symbol,
+				options.Transfers)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes a capital gains report with a row for each disposal
+func WriteGains(w io.Writer, disposals []accounting.Disposal) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"description", "amount", "acquired", "disposed", "proceeds", "cost_basis", "gain", "currency"})
+
+	for _, d := range disposals {
+		writer.Write([]string{
+			fmt.Sprintf("%.8f %s", d.Amount, commodity(d.Symbol)),
+			fmt.Sprintf("%.8f", d.Amount),
+			d.Acquired.Format("2006-01-02"),
+			d.Disposed.Format("2006-01-02"),
+			fmt.Sprintf("%.8f", d.Proceeds),
+			fmt.Sprintf("%.8f", d.Cost),
+			fmt.Sprintf("%.8f", d.Gain()),
+			commodity(d.Currency),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// returns fills and transactions merged in time order, fills first at the
+// same time so deposits of proceeds follow the sale
+func entries(fills []b.Fill, transactions []b.Transaction) []entry {
+	all := []entry{}
+	for i := range fills {
+		all = append(all, entry{time: fills[i].Timestamp, fill: &fills[i], order: i})
+	}
+	for i := range transactions {
+		all = append(all, entry{time: transactions[i].Timestamp, tx: &transactions[i], order: len(fills) + i})
+	}
+	sort.Sort(byTime(all))
+	return all
+}
+
+func description(f *b.Fill) string {
+	return fmt.Sprintf("%s %.8f %s @ %.8f %s", strings.Title(string(f.Type)),
+		f.Amount, commodity(f.Pair.Base), f.Rate, commodity(f.Pair.Counter))
+}
+
+func transfer(tx *b.Transaction) string {
+	if tx.Amount < 0 {
+		return fmt.Sprintf("Withdraw %.8f %s", -tx.Amount, commodity(tx.Symbol))
+	}
+	return fmt.Sprintf("Deposit %.8f %s", tx.Amount, commodity(tx.Symbol))
+}
+
+func comment(id string) string {
+	if id == "" {
+		return ""
+	}
+	return " ; " + id
+}
+
+// returns a symbol as a commodity, e.g BTC
+func commodity(symbol b.Symbol) string {
+	return strings.ToUpper(string(symbol))
+}
+
+// returns the account for a commodity under a parent account
+func account(parent string, commodity string) string {
+	return parent + ":" + commodity
+}
+
+// returns an exchange name as an account component, e.g paper:btce becomes
+// Paper-btce
+func AccountName(name string) string {
+	component := []rune{}
+	for i, r := range name {
+		if i == 0 {
+			r = unicode.ToUpper(r)
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = '-'
+		}
+		component = append(component, r)
+	}
+	return string(component)
+}
+
+type byTime []entry
+
+func (e byTime) Len() int      { return len(e) }
+func (e byTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byTime) Less(i, j int) bool {
+	if e[i].time.Equal(e[j].time) {
+		return e[i].order < e[j].order
+	}
+	return e[i].time.Before(e[j].time)
+}
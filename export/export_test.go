@@ -0,0 +1,73 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lox/babelcoin/accounting"
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportSpec(t *testing.T) {
+	Convey("Subject: Journal and Gains Export", t, func() {
+		day := func(d int) time.Time { return time.Date(2014, 1, d, 0, 0, 0, 0, time.UTC) }
+
+		fills := []babel.Fill{
+			{Trade: babel.Trade{Id: "1", Pair: babel.BTC_USD, Type: babel.Buy, Amount: 1, Rate: 100, Timestamp: day(2)}, Fee: 1},
+			{Trade: babel.Trade{Id: "2", Pair: babel.BTC_USD, Type: babel.Sell, Amount: 1, Rate: 250, Timestamp: day(3)}, Fee: 2.5},
+		}
+		transactions := []babel.Transaction{
+			{Id: "t1", Symbol: babel.USD, Amount: 1000, Timestamp: day(1)},
+			{Id: "t2", Symbol: babel.USD, Amount: -500, Timestamp: day(4)},
+		}
+		options := Options{Account: "Assets:" + AccountName("paper:btce")}
+
+		Convey(`Ledger journals should book fills and transfers in time order`, func() {
+			var buf bytes.Buffer
+			So(WriteLedger(&buf, fills, transactions, options), ShouldBeNil)
+
+			journal := buf.String()
+			So(journal, ShouldStartWith, "2014/01/01 * Deposit 1000.00000000 USD ; t1\n")
+			So(journal, ShouldContainSubstring, "    Assets:Paper-btce:BTC  1.00000000 BTC @ 100.00000000 USD\n")
+			So(journal, ShouldContainSubstring, "    Expenses:Fees  1.00000000 USD\n    Assets:Paper-btce:USD  -101.00000000 USD\n")
+			So(journal, ShouldContainSubstring, "    Assets:Paper-btce:USD  247.50000000 USD\n")
+			So(strings.Index(journal, "Withdraw"), ShouldBeGreaterThan, strings.Index(journal, "Sell"))
+		})
+
+		Convey(`Beancount journals should open accounts and book gains on sales`, func() {
+			var buf bytes.Buffer
+			So(WriteBeancount(&buf, fills, transactions, options), ShouldBeNil)
+
+			journal := buf.String()
+			So(journal, ShouldContainSubstring, "2014-01-01 open Assets:Paper-btce:BTC\n")
+			So(journal, ShouldContainSubstring, "2014-01-01 open Income:Gains\n")
+			So(journal, ShouldContainSubstring, "  Assets:Paper-btce:BTC  1.00000000 BTC {100.00000000 USD}\n")
+			So(journal, ShouldContainSubstring, "  Assets:Paper-btce:BTC  -1.00000000 BTC {} @ 250.00000000 USD\n")
+			So(journal, ShouldContainSubstring, "  Income:Gains\n")
+		})
+
+		Convey(`Empty journals should write nothing`, func() {
+			var buf bytes.Buffer
+			So(WriteBeancount(&buf, nil, nil, options), ShouldBeNil)
+			So(buf.Len(), ShouldEqual, 0)
+		})
+
+		Convey(`Gains should be reported for each disposal`, func() {
+			ledger, _ := accounting.NewLedger(map[string]interface{}{})
+			for _, fill := range fills {
+				So(ledger.AddFill(fill.Trade, fill.Fee), ShouldBeNil)
+			}
+
+			var buf bytes.Buffer
+			So(WriteGains(&buf, ledger.Disposals()), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(len(lines), ShouldEqual, 2)
+			So(lines[0], ShouldEqual, "description,amount,acquired,disposed,proceeds,cost_basis,gain,currency")
+			So(lines[1], ShouldEqual, "1.00000000 BTC,1.00000000,2014-01-02,2014-01-03,247.50000000,101.00000000,146.50000000,USD")
+		})
+	})
+}
@@ -0,0 +1,332 @@
+/*
+Streaming technical indicators.
+
+Indicators are updated incrementally with a price at a time, or with a
+completed candle, so they can be driven from MarketData or candle
+streams without keeping the full price history.
+*/
+package indicators
+
+import (
+	"errors"
+	"math"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+var errInvalidPeriod = errors.New("Indicator periods must be at least 1")
+
+type Indicator interface {
+	// updates the indicator with the latest price
+	Update(price float64)
+
+	// updates the indicator with a completed candle
+	UpdateCandle(candle b.Candle)
+
+	// the current value, only meaningful once ready
+	Value() float64
+
+	// returns true once enough values have been seen
+	Ready() bool
+}
+
+// updates indicators from a stream of candles, forwarding each candle once
+// the indicators have been updated. closes out when in is closed
+func FeedCandles(in <-chan b.Candle, out chan<- b.Candle, indicators ...Indicator) {
+	for candle := range in {
+		for _, i := range indicators {
+			i.UpdateCandle(candle)
+		}
+		out <- candle
+	}
+	close(out)
+}
+
+// updates indicators with the last price from a stream of market data,
+// forwarding the data once the indicators have been updated. closes out
+// when in is closed
+func FeedMarketData(in <-chan b.MarketData, out chan<- b.MarketData, indicators ...Indicator) {
+	for data := range in {
+		for _, i := range indicators {
+			i.Update(data.Last)
+		}
+		out <- data
+	}
+	close(out)
+}
+
+// a simple moving average
+type SMA struct {
+	period int
+	values []float64
+	next   int
+	count  int
+	sum    float64
+}
+
+func NewSMA(period int) (*SMA, error) {
+	if period < 1 {
+		return nil, errInvalidPeriod
+	}
+	return &SMA{period: period, values: make([]float64, period)}, nil
+}
+
+func (s *SMA) Update(price float64) {
+	s.sum += price - s.values[s.next]
+	s.values[s.next] = price
+	s.next = (s.next + 1) % s.period
+	if s.count < s.period {
+		s.count++
+	}
+}
+
+func (s *SMA) UpdateCandle(candle b.Candle) { s.Update(candle.Close) }
+func (s *SMA) Ready() bool                  { return s.count == s.period }
+
+func (s *SMA) Value() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// the population standard deviation of the values in the average
+func (s *SMA) StdDev() float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	mean, variance := s.Value(), 0.0
+	for i := 0; i < s.count; i++ {
+		variance += (s.values[i] - mean) * (s.values[i] - mean)
+	}
+	return math.Sqrt(variance / float64(s.count))
+}
+
+// an exponential moving average, seeded with the simple average of the
+// first period values
+type EMA struct {
+	period int
+	alpha  float64
+	count  int
+	value  float64
+}
+
+func NewEMA(period int) (*EMA, error) {
+	if period < 1 {
+		return nil, errInvalidPeriod
+	}
+	return &EMA{period: period, alpha: 2 / float64(period+1)}, nil
+}
+
+func (e *EMA) Update(price float64) {
+	e.count++
+	if e.count <= e.period {
+		e.value += (price - e.value) / float64(e.count)
+	} else {
+		e.value += (price - e.value) * e.alpha
+	}
+}
+
+func (e *EMA) UpdateCandle(candle b.Candle) { e.Update(candle.Close) }
+func (e *EMA) Value() float64               { return e.value }
+func (e *EMA) Ready() bool                  { return e.count >= e.period }
+
+// the relative strength index, using wilder's smoothing
+type RSI struct {
+	period     int
+	count      int
+	last       float64
+	gain, loss float64
+}
+
+func NewRSI(period int) (*RSI, error) {
+	if period < 1 {
+		return nil, errInvalidPeriod
+	}
+	return &RSI{period: period}, nil
+}
+
+func (r *RSI) Update(price float64) {
+	r.count++
+	if r.count == 1 {
+		r.last = price
+		return
+	}
+
+	gain, loss := 0.0, 0.0
+	if change := price - r.last; change > 0 {
+		gain = change
+	} else {
+		loss = -change
+	}
+	r.last = price
+
+	n := float64(r.period)
+	if r.count <= r.period+1 {
+		// the first averages are simple averages of the changes
+		r.gain += gain / n
+		r.loss += loss / n
+	} else {
+		r.gain = (r.gain*(n-1) + gain) / n
+		r.loss = (r.loss*(n-1) + loss) / n
+	}
+}
+
+func (r *RSI) UpdateCandle(candle b.Candle) { r.Update(candle.Close) }
+func (r *RSI) Ready() bool                  { return r.count > r.period }
+
+func (r *RSI) Value() float64 {
+	if r.loss == 0 {
+		if r.gain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+r.gain/r.loss)
+}
+
+// moving average convergence divergence, the value is the difference
+// between the fast and slow averages
+type MACD struct {
+	fast, slow, signal *EMA
+}
+
+func NewMACD(fast int, slow int, signal int) (*MACD, error) {
+	if fast < 1 || slow < 1 || signal < 1 {
+		return nil, errInvalidPeriod
+	}
+	m := &MACD{}
+	m.fast, _ = NewEMA(fast)
+	m.slow, _ = NewEMA(slow)
+	m.signal, _ = NewEMA(signal)
+	return m, nil
+}
+
+func (m *MACD) Update(price float64) {
+	m.fast.Update(price)
+	m.slow.Update(price)
+	if m.slow.Ready() {
+		m.signal.Update(m.Value())
+	}
+}
+
+func (m *MACD) UpdateCandle(candle b.Candle) { m.Update(candle.Close) }
+func (m *MACD) Value() float64               { return m.fast.Value() - m.slow.Value() }
+func (m *MACD) Ready() bool                  { return m.signal.Ready() }
+
+// the average of the macd value
+func (m *MACD) Signal() float64 {
+	return m.signal.Value()
+}
+
+// the difference between the macd value and signal
+func (m *MACD) Histogram() float64 {
+	return m.Value() - m.Signal()
+}
+
+// bollinger bands, the value is the middle band
+type Bollinger struct {
+	sma *SMA
+	k   float64
+}
+
+func NewBollinger(period int, k float64) (*Bollinger, error) {
+	sma, err := NewSMA(period)
+	if err != nil {
+		return nil, err
+	}
+	return &Bollinger{sma, k}, nil
+}
+
+func (bb *Bollinger) Update(price float64)         { bb.sma.Update(price) }
+func (bb *Bollinger) UpdateCandle(candle b.Candle) { bb.Update(candle.Close) }
+func (bb *Bollinger) Value() float64               { return bb.sma.Value() }
+func (bb *Bollinger) Ready() bool                  { return bb.sma.Ready() }
+
+func (bb *Bollinger) Upper() float64 {
+	return bb.sma.Value() + bb.k*bb.sma.StdDev()
+}
+
+func (bb *Bollinger) Lower() float64 {
+	return bb.sma.Value() - bb.k*bb.sma.StdDev()
+}
+
+// the average true range, using wilder's smoothing. updating with a
+// price treats it as a candle with the same high, low and close
+type ATR struct {
+	period    int
+	count     int
+	lastClose float64
+	value     float64
+}
+
+func NewATR(period int) (*ATR, error) {
+	if period < 1 {
+		return nil, errInvalidPeriod
+	}
+	return &ATR{period: period}, nil
+}
+
+func (a *ATR) Update(price float64) {
+	a.UpdateCandle(b.Candle{High: price, Low: price, Close: price})
+}
+
+func (a *ATR) UpdateCandle(candle b.Candle) {
+	tr := candle.High - candle.Low
+	if a.count > 0 {
+		tr = math.Max(tr, math.Max(
+			math.Abs(candle.High-a.lastClose), math.Abs(candle.Low-a.lastClose)))
+	}
+	a.lastClose = candle.Close
+	a.count++
+
+	n := float64(a.period)
+	if a.count <= a.period {
+		a.value += (tr - a.value) / float64(a.count)
+	} else {
+		a.value = (a.value*(n-1) + tr) / n
+	}
+}
+
+func (a *ATR) Value() float64 { return a.value }
+func (a *ATR) Ready() bool    { return a.count >= a.period }
+
+// the volume weighted average price since the indicator was created.
+// updating with a price gives it a volume of one
+type VWAP struct {
+	notional, volume float64
+}
+
+func NewVWAP() *VWAP {
+	return &VWAP{}
+}
+
+func (v *VWAP) Update(price float64) {
+	v.notional += price
+	v.volume++
+}
+
+// uses the candle's vwap, or the typical price if it has none
+func (v *VWAP) UpdateCandle(candle b.Candle) {
+	price := candle.VWAP
+	if price == 0 {
+		price = (candle.High + candle.Low + candle.Close) / 3
+	}
+	v.notional += price * candle.Volume
+	v.volume += candle.Volume
+}
+
+func (v *VWAP) UpdateTrade(trade b.Trade) {
+	v.notional += trade.Rate * trade.Amount
+	v.volume += trade.Amount
+}
+
+func (v *VWAP) Ready() bool { return v.volume > 0 }
+
+func (v *VWAP) Value() float64 {
+	if v.volume == 0 {
+		return 0
+	}
+	return v.notional / v.volume
+}
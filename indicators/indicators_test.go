@@ -0,0 +1,206 @@
+package indicators
+
+import (
+	"testing"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reference values from the stockcharts.com indicator examples. their rsi
+// table rounds intermediate averages to two places, so the rsi values here
+// are the unrounded results, which agree with ta-lib
+var (
+	emaPrices = []float64{
+		22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+		22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+		23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+	}
+	ema10 = []float64{
+		22.22, 22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28,
+		23.34, 23.43, 23.51, 23.54, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08,
+		22.92,
+	}
+	rsiPrices = []float64{
+		44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+		45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+		46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57,
+		43.42, 42.66, 43.13,
+	}
+	rsi14 = []float64{
+		70.46, 66.25, 66.48, 69.35, 66.29, 57.92, 62.88, 63.21, 56.01, 62.34,
+		54.67, 50.39, 40.02, 41.49, 41.90, 45.50, 37.32, 33.09, 37.79,
+	}
+)
+
+// reference values for the ema prices, worked out from the textbook
+// definitions by a separate batch calculation rather than these types
+var (
+	macd5_10 = []float64{
+		0.0487, 0.0845, 0.2126, 0.3741, 0.3941, 0.3932, 0.3871, 0.3118, 0.2806,
+		0.2542, 0.1910, 0.0753, -0.0060, -0.0152, -0.1177, -0.1029, -0.1946, -0.2677,
+	}
+	macdSignal4 = []float64{
+		0.0396, 0.0576, 0.1196, 0.2214, 0.2904, 0.3315, 0.3538, 0.3370, 0.3144,
+		0.2903, 0.2506, 0.1805, 0.1059, 0.0575, -0.0126, -0.0487, -0.1071, -0.1713,
+	}
+	bollinger20 = [][3]float64{
+		{22.7155, 24.1261, 21.3049}, {22.7930, 24.2661, 21.3199}, {22.8770, 24.3939, 21.3601},
+		{22.9555, 24.4617, 21.4493}, {23.0065, 24.4714, 21.5416}, {23.0525, 24.4676, 21.6374},
+		{23.1125, 24.4665, 21.7585}, {23.1350, 24.4438, 21.8262}, {23.1685, 24.4371, 21.8999},
+		{23.1765, 24.4234, 21.9296}, {23.1705, 24.4355, 21.9055},
+	}
+	atrHighs = []float64{
+		48.70, 48.72, 48.90, 48.87, 48.82, 49.05, 49.20, 49.35, 49.92,
+		50.19, 50.12, 49.66, 49.88, 50.19, 50.36, 50.57, 50.65, 50.43,
+	}
+	atrLows = []float64{
+		47.79, 48.14, 48.39, 48.37, 48.24, 48.64, 48.94, 48.86, 49.50,
+		49.87, 49.20, 48.90, 49.43, 49.73, 49.26, 50.09, 50.30, 49.21,
+	}
+	atrCloses = []float64{
+		48.16, 48.61, 48.75, 48.63, 48.74, 49.03, 49.07, 49.32, 49.91,
+		50.13, 49.53, 49.50, 49.75, 50.03, 50.31, 50.52, 50.41, 49.34,
+	}
+	atr14 = []float64{0.5543, 0.5933, 0.5852, 0.5684, 0.6149}
+)
+
+func TestIndicatorSpec(t *testing.T) {
+	Convey("Subject: Indicators", t, func() {
+		Convey(`Periods below one should be rejected`, func() {
+			_, err := NewSMA(0)
+			So(err, ShouldNotBeNil)
+			_, err = NewEMA(0)
+			So(err, ShouldNotBeNil)
+			_, err = NewRSI(-1)
+			So(err, ShouldNotBeNil)
+			_, err = NewMACD(12, 0, 9)
+			So(err, ShouldNotBeNil)
+			_, err = NewBollinger(0, 2)
+			So(err, ShouldNotBeNil)
+			_, err = NewATR(0)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey(`SMA should average the last N prices`, func() {
+			sma, _ := NewSMA(3)
+			for _, p := range []float64{1, 2, 3} {
+				sma.Update(p)
+			}
+			So(sma.Ready(), ShouldBeTrue)
+			So(sma.Value(), ShouldEqual, 2)
+
+			sma.Update(7)
+			So(sma.Value(), ShouldEqual, 4)
+		})
+
+		Convey(`EMA should match reference values`, func() {
+			ema, _ := NewEMA(10)
+			results := []float64{}
+			for _, p := range emaPrices {
+				ema.Update(p)
+				if ema.Ready() {
+					results = append(results, ema.Value())
+				}
+			}
+
+			So(len(results), ShouldEqual, len(ema10))
+			for i, expected := range ema10 {
+				So(results[i], ShouldAlmostEqual, expected, 0.01)
+			}
+		})
+
+		Convey(`RSI should match reference values`, func() {
+			rsi, _ := NewRSI(14)
+			results := []float64{}
+			for _, p := range rsiPrices {
+				rsi.Update(p)
+				if rsi.Ready() {
+					results = append(results, rsi.Value())
+				}
+			}
+
+			So(len(results), ShouldEqual, len(rsi14))
+			for i, expected := range rsi14 {
+				So(results[i], ShouldAlmostEqual, expected, 0.01)
+			}
+		})
+
+		Convey(`MACD should match reference values`, func() {
+			macd, _ := NewMACD(5, 10, 4)
+			values, signals := []float64{}, []float64{}
+			for _, p := range emaPrices {
+				macd.Update(p)
+				if macd.Ready() {
+					values = append(values, macd.Value())
+					signals = append(signals, macd.Signal())
+				}
+			}
+
+			So(len(values), ShouldEqual, len(macd5_10))
+			for i := range macd5_10 {
+				So(values[i], ShouldAlmostEqual, macd5_10[i], 0.0001)
+				So(signals[i], ShouldAlmostEqual, macdSignal4[i], 0.0001)
+			}
+			So(macd.Histogram(), ShouldAlmostEqual, -0.2677+0.1713, 0.0001)
+		})
+
+		Convey(`Bollinger bands should match reference values`, func() {
+			bb, _ := NewBollinger(20, 2)
+			results := [][3]float64{}
+			for _, p := range emaPrices {
+				bb.Update(p)
+				if bb.Ready() {
+					results = append(results, [3]float64{bb.Value(), bb.Upper(), bb.Lower()})
+				}
+			}
+
+			So(len(results), ShouldEqual, len(bollinger20))
+			for i, expected := range bollinger20 {
+				for j := range expected {
+					So(results[i][j], ShouldAlmostEqual, expected[j], 0.0001)
+				}
+			}
+		})
+
+		Convey(`ATR should match reference values`, func() {
+			atr, _ := NewATR(14)
+			results := []float64{}
+			for i := range atrCloses {
+				atr.UpdateCandle(babel.Candle{High: atrHighs[i], Low: atrLows[i], Close: atrCloses[i]})
+				if atr.Ready() {
+					results = append(results, atr.Value())
+				}
+			}
+
+			So(len(results), ShouldEqual, len(atr14))
+			for i, expected := range atr14 {
+				So(results[i], ShouldAlmostEqual, expected, 0.0001)
+			}
+		})
+
+		Convey(`VWAP should weight prices by volume`, func() {
+			vwap := NewVWAP()
+			vwap.UpdateTrade(babel.Trade{Rate: 100, Amount: 1})
+			vwap.UpdateTrade(babel.Trade{Rate: 110, Amount: 3})
+			So(vwap.Value(), ShouldEqual, 107.5)
+
+			vwap.UpdateCandle(babel.Candle{VWAP: 90, Volume: 4})
+			So(vwap.Value(), ShouldEqual, 98.75)
+		})
+
+		Convey(`Indicators should be fed from candle streams`, func() {
+			in := make(chan babel.Candle, 3)
+			out := make(chan babel.Candle, 3)
+			for _, p := range []float64{1, 2, 3} {
+				in <- babel.Candle{Close: p}
+			}
+			close(in)
+
+			sma, _ := NewSMA(3)
+			FeedCandles(in, out, sma)
+			So(len(out), ShouldEqual, 3)
+			So(sma.Value(), ShouldEqual, 2)
+		})
+	})
+}
@@ -5,14 +5,34 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docopt/docopt.go"
+	"github.com/lox/babelcoin/accounting"
+	"github.com/lox/babelcoin/arbitrage"
+	"github.com/lox/babelcoin/backtest"
+	"github.com/lox/babelcoin/candles"
+	"github.com/lox/babelcoin/convert"
 	"github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/aggregate"
 	"github.com/lox/babelcoin/exchanges/bitcoincharts"
 	"github.com/lox/babelcoin/exchanges/btce"
 	"github.com/lox/babelcoin/exchanges/cryptsy"
+	"github.com/lox/babelcoin/exchanges/dryrun"
+	"github.com/lox/babelcoin/exchanges/paper"
+	"github.com/lox/babelcoin/exchanges/replay"
+	"github.com/lox/babelcoin/exchanges/risk"
+	"github.com/lox/babelcoin/export"
+	"github.com/lox/babelcoin/orderbook"
+	"github.com/lox/babelcoin/portfolio"
+	"github.com/lox/babelcoin/recorder"
+	"github.com/lox/babelcoin/router"
+	"github.com/lox/babelcoin/store"
+	"github.com/lox/babelcoin/strategy"
+	util "github.com/lox/babelcoin/util"
 )
 
 func main() {
@@ -20,10 +40,21 @@ func main() {
 
 Usage:
   babelcoin ticker <exchange> <pair> [--interval=<duration>]
-  babelcoin tradehistory <exchange> <pair>...
+  babelcoin book <exchange> <pair> [--interval=<duration>] [--depth=<depth>]
+  babelcoin tradehistory <exchange> <pair>... [--store=<dir>]
   babelcoin (buy|sell) <exchange> <pair> <amount> <rate> [--timeout=<duration>]
   babelcoin pairs <exchange>
   babelcoin balances <exchange>
+  babelcoin candles <exchange> <pair> [--interval=<duration>] [--since=<duration>] [--follow]
+  babelcoin run <exchange> <pair>... [--strategy=<name>]
+  babelcoin backtest <exchange> <pair> [--strategy=<name>] [--since=<duration>] [--balances=<balances>] [--fee=<fee>] [--latency=<duration>] [--recording=<dir>]
+  babelcoin record <exchange> <pair>... [--recording=<dir>] [--interval=<duration>] [--rotate=<duration>]
+  babelcoin arbitrage <venue>... --pair=<pair> [--interval=<duration>] [--min-return=<return>] [--max-amount=<amount>] [--execute]
+  babelcoin triangular <exchange> [--interval=<duration>] [--min-return=<return>] [--start=<symbols>]
+  babelcoin convert <amount> <from> <to> <venue>... [--last]
+  babelcoin portfolio [<venue>...] [--quote=<symbol>]
+  babelcoin export <exchange> [--format=<format>] [--method=<method>]
+  babelcoin route (buy|sell) <pair> <amount> <venue>... [--limit=<rate>] [--plan]
   babelcoin -h | --help
   babelcoin --version
 
@@ -31,7 +62,28 @@ Options:
   -h --help     			Show this screen.
   --version     			Show version.
   -i --interval=<duration>  Time interval to use [default: 30s].
-  --timeout=<duration>  	A timeout to cancel the order by if not filled.`
+  --timeout=<duration>  	A timeout to cancel the order by if not filled.
+  --strategy=<name>  		The strategy to run [default: buyhold].
+  --since=<duration>  		How far back to load history from [default: 720h].
+  --follow  				Keep polling for new trades.
+  --store=<dir>  			A directory to store downloaded trades in.
+  --balances=<balances>  	Starting balances, e.g usd:1000,btc:1 [default: usd:1000].
+  --fee=<fee>  				The fee charged on each trade [default: 0.002].
+  --latency=<duration>  	The delay before orders reach the market [default: 0s].
+  --recording=<dir>  		A directory that feeds are recorded to.
+  --rotate=<duration>  		How often to start a new recording file [default: 1h].
+  --pair=<pair>  			The pair to look for opportunities in.
+  --min-return=<return>  	The minimum return on an opportunity [default: 0].
+  --max-amount=<amount>  	The most to trade on an opportunity, 0 for no limit [default: 0].
+  --execute  				Place orders for opportunities that are found.
+  --start=<symbols>  		The symbols cycles should start from, e.g btc,usd.
+  --last  				Convert at the last price instead of the bid and ask.
+  --quote=<symbol>  		The currency to value holdings in [default: usd].
+  --format=<format>  		Export a paper account as ledger, beancount or gains [default: ledger].
+  --method=<method>  		How lots are matched for gains, fifo, lifo or average [default: fifo].
+  --limit=<rate>  		The worst price to route at, -1 for any [default: -1].
+  --plan  				Show how an order would be routed without placing it.
+  --depth=<depth>  		The number of levels in each order book snapshot [default: 50].`
 
 	args, err := docopt.Parse(usage, nil, true, "Babelcoin", false)
 	if err != nil {
@@ -40,8 +92,12 @@ Options:
 
 	if ticker := args["ticker"]; ticker.(bool) {
 		Ticker(args)
+	} else if book := args["book"]; book.(bool) {
+		Book(args)
 	} else if pairs := args["pairs"]; pairs.(bool) {
 		Pairs(args)
+	} else if route := args["route"]; route.(bool) {
+		Route(args)
 	} else if buy := args["buy"]; buy.(bool) {
 		//Trade(args, "buy")
 	} else if sell := args["sell"]; sell.(bool) {
@@ -50,6 +106,24 @@ Options:
 		TradeHistory(args)
 	} else if balances := args["balances"]; balances.(bool) {
 		Balances(args)
+	} else if candles := args["candles"]; candles.(bool) {
+		Candles(args)
+	} else if run := args["run"]; run.(bool) {
+		Run(args)
+	} else if backtest := args["backtest"]; backtest.(bool) {
+		Backtest(args)
+	} else if record := args["record"]; record.(bool) {
+		Record(args)
+	} else if arbitrage := args["arbitrage"]; arbitrage.(bool) {
+		Arbitrage(args)
+	} else if triangular := args["triangular"]; triangular.(bool) {
+		Triangular(args)
+	} else if convert := args["convert"]; convert.(bool) {
+		Convert(args)
+	} else if portfolio := args["portfolio"]; portfolio.(bool) {
+		Portfolio(args)
+	} else if export := args["export"]; export.(bool) {
+		Export(args)
 	}
 }
 
@@ -78,6 +152,36 @@ func Ticker(args map[string]interface{}) {
 	}
 }
 
+func Book(args map[string]interface{}) {
+	depth, err := strconv.Atoi(args["--depth"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	stream := orderbook.NewStream(exchange, map[string]interface{}{
+		"interval": args["--interval"].(string),
+		"depth":    depth,
+	})
+
+	events := make(chan orderbook.Event, 10)
+	if err := stream.Watch(babelcoin.ParsePair(args["<pair>"].(string)), events); err != nil {
+		panic(err)
+	}
+
+	for event := range events {
+		bid, _ := event.Book.BestBid()
+		ask, _ := event.Book.BestAsk()
+		log.Printf("%s: %d changes Bid: %.6f (%.4f) Ask: %.6f (%.4f) Imbalance: %.2f",
+			event.Type, len(event.Changes), bid.Price, bid.Amount, ask.Price, ask.Amount,
+			event.Book.Imbalance(10))
+	}
+}
+
 func TradeHistory(args map[string]interface{}) {
 	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
 	if err != nil {
@@ -85,7 +189,7 @@ func TradeHistory(args map[string]interface{}) {
 	}
 
 	// get history for up to 2 months ago
-	after := time.Now().AddDate(0, -2, 0)
+	after := babelcoin.ExchangeClock(exchange).Now().AddDate(0, -2, 0)
 	channel := make(chan babelcoin.Trade, 5000)
 
 	pairs := []babelcoin.Pair{}
@@ -93,6 +197,11 @@ func TradeHistory(args map[string]interface{}) {
 		pairs = append(pairs, babelcoin.ParsePair(p))
 	}
 
+	if dir, ok := args["--store"].(string); ok {
+		TradeHistoryFromStore(args["<exchange>"].(string), exchange, dir, pairs, after)
+		return
+	}
+
 	go func() {
 		if err := exchange.TradeHistory(pairs, after, 2000, channel); err != nil {
 			panic(err)
@@ -106,6 +215,55 @@ func TradeHistory(args map[string]interface{}) {
 	}
 }
 
+// only downloads trades newer than those in the store, then prints from the store
+func TradeHistoryFromStore(name string, exchange babelcoin.Exchange, dir string, pairs []babelcoin.Pair, after time.Time) {
+	s, err := store.Open(dir, map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	clock := babelcoin.ExchangeClock(exchange)
+	since := clock.Now()
+	for _, pair := range pairs {
+		latest, err := s.Latest(name, pair)
+		if err != nil {
+			panic(err)
+		}
+		if latest.Before(after) {
+			latest = after
+		}
+		if latest.Before(since) {
+			since = latest
+		}
+	}
+
+	log.Printf("Downloading history after %s", since)
+	channel := make(chan babelcoin.Trade, 5000)
+	go func() {
+		if err := exchange.TradeHistory(pairs, since, 2000, channel); err != nil {
+			panic(err)
+		}
+	}()
+
+	inserted, err := s.Record(name, channel)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("Stored %d new trades", inserted)
+
+	stored := make(chan babelcoin.Trade, 5000)
+	go func() {
+		if err := s.Query(name, pairs, after, clock.Now(), stored); err != nil {
+			panic(err)
+		}
+	}()
+
+	for trade := range stored {
+		fmt.Printf("%s %s %s %.4f @ %.4f\n",
+			trade.Timestamp.Format("2006-01-02T15:04:05"), trade.Type, trade.Pair.String(), trade.Amount, trade.Rate)
+	}
+}
+
 func Pairs(args map[string]interface{}) {
 	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
 	if err != nil {
@@ -138,6 +296,493 @@ func Balances(args map[string]interface{}) {
 	}
 }
 
+func Backtest(args map[string]interface{}) {
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	since, err := time.ParseDuration(args["--since"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	latency, err := time.ParseDuration(args["--latency"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	fee, err := strconv.ParseFloat(args["--fee"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	balances, err := babelcoin.ParseBalances(args["--balances"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	s, err := strategy.NewStrategy(args["--strategy"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	pair := babelcoin.ParsePair(args["<pair>"].(string))
+	bt := backtest.New(backtest.Config{
+		Pair:     pair,
+		Balances: balances,
+		Fee:      fee,
+		Latency:  latency,
+	}, strategy.NewRuntime(nil, s, []babelcoin.Pair{pair}, map[string]interface{}{}))
+
+	channel := make(chan babelcoin.Trade, 5000)
+	now := babelcoin.ExchangeClock(exchange).Now()
+	go func() {
+		var err error
+		if dir, ok := args["--recording"].(string); ok {
+			err = recorder.Trades(dir, args["<exchange>"].(string), []babelcoin.Pair{pair}, now.Add(-since), now, channel)
+		} else {
+			err = sortedHistory(exchange, pair, now.Add(-since), channel)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	report, err := bt.Run(channel)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, trade := range report.Trades {
+		fmt.Printf("%s %s %s %.4f @ %.4f\n",
+			trade.Timestamp.Format("2006-01-02T15:04:05"), trade.Type, trade.Pair.String(), trade.Amount, trade.Rate)
+	}
+	fmt.Println(report.String())
+}
+
+// sends the trade history of a pair in time order, as exchanges can return
+// it newest first
+func sortedHistory(exchange babelcoin.Exchange, pair babelcoin.Pair, after time.Time, channel chan<- babelcoin.Trade) error {
+	history := make(chan babelcoin.Trade, 5000)
+	if err := exchange.TradeHistory([]babelcoin.Pair{pair}, after, 0, history); err != nil {
+		return err
+	}
+
+	trades := []*babelcoin.Trade{}
+	for trade := range history {
+		trade := trade
+		trades = append(trades, &trade)
+	}
+
+	sort.Stable(babelcoin.TradeSorter{Trades: trades, By: func(t1, t2 *babelcoin.Trade) bool {
+		return t1.Timestamp.Before(t2.Timestamp)
+	}})
+
+	for _, trade := range trades {
+		channel <- *trade
+	}
+	close(channel)
+	return nil
+}
+
+func Candles(args map[string]interface{}) {
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	interval, err := time.ParseDuration(args["--interval"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	since, err := time.ParseDuration(args["--since"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	pairs := []babelcoin.Pair{babelcoin.ParsePair(args["<pair>"].(string))}
+	trades := make(chan babelcoin.Trade, 5000)
+	channel := make(chan babelcoin.Candle, 100)
+	clock := babelcoin.ExchangeClock(exchange)
+
+	if args["--follow"].(bool) {
+		history := make(chan babelcoin.Trade, 5000)
+		go func() {
+			if err := exchange.TradeHistory(pairs, clock.Now().Add(-since), 0, history); err != nil {
+				panic(err)
+			}
+		}()
+		go func() {
+			// poll from the last trade aggregated, the aggregator drops the
+			// trades that are fetched again
+			last := clock.Now().Add(-since)
+			for trade := range history {
+				if trade.Timestamp.After(last) {
+					last = trade.Timestamp
+				}
+				trades <- trade
+			}
+			if err := util.HistoryPollerAfter(clock, exchange, pairs, interval, last, trades, nil); err != nil {
+				panic(err)
+			}
+		}()
+		go candles.Aggregate(trades, interval, interval, channel)
+	} else {
+		go func() {
+			if err := exchange.TradeHistory(pairs, clock.Now().Add(-since), 0, trades); err != nil {
+				panic(err)
+			}
+		}()
+		go candles.Aggregate(trades, interval, 0, channel)
+	}
+
+	for candle := range channel {
+		fmt.Println(candle.String())
+	}
+}
+
+func Run(args map[string]interface{}) {
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	s, err := strategy.NewStrategy(args["--strategy"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	pairs := []babelcoin.Pair{}
+	for _, p := range args["<pair>"].([]string) {
+		pairs = append(pairs, babelcoin.ParsePair(p))
+	}
+
+	log.Printf("Running %s on %s", args["--strategy"], args["<exchange>"])
+	if err := strategy.NewRuntime(exchange, s, pairs, map[string]interface{}{}).Run(); err != nil {
+		panic(err)
+	}
+}
+
+func Record(args map[string]interface{}) {
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	interval, err := time.ParseDuration(args["--interval"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	rotate, err := time.ParseDuration(args["--rotate"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	dir, ok := args["--recording"].(string)
+	if !ok {
+		dir = "recordings"
+	}
+
+	pairs := []babelcoin.Pair{}
+	for _, p := range args["<pair>"].([]string) {
+		pairs = append(pairs, babelcoin.ParsePair(p))
+	}
+
+	log.Printf("Recording %s to %s", args["<exchange>"], dir)
+	err = recorder.NewRecorder(dir, args["<exchange>"].(string), exchange, pairs, map[string]interface{}{
+		"rotate":         rotate,
+		"trade_interval": interval,
+		"book_interval":  interval,
+	}).Run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Arbitrage(args map[string]interface{}) {
+	interval, err := time.ParseDuration(args["--interval"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	minReturn, err := strconv.ParseFloat(args["--min-return"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	maxAmount, err := strconv.ParseFloat(args["--max-amount"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	venues := []arbitrage.Venue{}
+	for _, name := range args["<venue>"].([]string) {
+		exchange, err := NewExchange(name, map[string]interface{}{})
+		if err != nil {
+			panic(err)
+		}
+		venues = append(venues, arbitrage.Venue{Name: name, Exchange: exchange})
+	}
+
+	scanner := arbitrage.NewScanner(venues, map[string]interface{}{
+		"min_return": minReturn,
+		"max_amount": maxAmount,
+	})
+
+	pair := babelcoin.ParsePair(args["--pair"].(string))
+	channel := make(chan arbitrage.Opportunity, 100)
+	go func() {
+		if err := scanner.Watch(pair, interval, channel); err != nil {
+			panic(err)
+		}
+	}()
+
+	for o := range channel {
+		log.Println(o.String())
+		if args["--execute"].(bool) {
+			buy, sell, err := scanner.Execute(o)
+			if err != nil {
+				log.Printf("Failed to execute: %v", err)
+				continue
+			}
+			log.Printf("Placed buy %s and sell %s", buy.Id, sell.Id)
+		}
+	}
+}
+
+func Triangular(args map[string]interface{}) {
+	exchange, err := NewExchange(args["<exchange>"].(string), map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	interval, err := time.ParseDuration(args["--interval"].(string))
+	if err != nil {
+		panic(err)
+	}
+
+	minReturn, err := strconv.ParseFloat(args["--min-return"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	start := []babelcoin.Symbol{}
+	if symbols, ok := args["--start"].(string); ok {
+		for _, symbol := range strings.Split(symbols, ",") {
+			start = append(start, babelcoin.Symbol(strings.ToLower(symbol)))
+		}
+	}
+
+	detector := arbitrage.NewTriangular(exchange, map[string]interface{}{
+		"min_return": minReturn,
+		"start":      start,
+	})
+
+	channel := make(chan arbitrage.Cycle, 100)
+	go func() {
+		if err := detector.Watch(interval, channel); err != nil {
+			panic(err)
+		}
+	}()
+
+	for cycle := range channel {
+		log.Println(cycle.String())
+	}
+}
+
+func Convert(args map[string]interface{}) {
+	amount, err := strconv.ParseFloat(args["<amount>"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	exchanges := map[string]babelcoin.Exchange{}
+	for _, name := range args["<venue>"].([]string) {
+		exchange, err := NewExchange(name, map[string]interface{}{})
+		if err != nil {
+			panic(err)
+		}
+		exchanges[name] = exchange
+	}
+
+	config := map[string]interface{}{}
+	if args["--last"].(bool) {
+		config["price"] = "last"
+	}
+
+	from := babelcoin.Symbol(strings.ToLower(args["<from>"].(string)))
+	to := babelcoin.Symbol(strings.ToLower(args["<to>"].(string)))
+
+	rate, steps, err := convert.NewConverter(exchanges, config).Rate(from, to)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, step := range steps {
+		log.Println(step.String())
+	}
+	fmt.Printf("%.8f %s = %.8f %s (%s)\n", amount, from, amount*rate, to, convert.PathString(from, steps))
+}
+
+func Portfolio(args map[string]interface{}) {
+	names := args["<venue>"].([]string)
+	if len(names) == 0 {
+		names = ConfiguredExchanges()
+	}
+	if len(names) == 0 {
+		panic("No exchanges given, and no exchange keys are set")
+	}
+
+	exchanges := map[string]babelcoin.Exchange{}
+	for _, name := range names {
+		exchange, err := NewExchange(name, map[string]interface{}{})
+		if err != nil {
+			panic(err)
+		}
+		exchanges[name] = exchange
+	}
+
+	quote := babelcoin.Symbol(strings.ToLower(args["--quote"].(string)))
+	p, err := portfolio.Build(exchanges, convert.NewConverter(exchanges, map[string]interface{}{}), quote, map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Print(p.String())
+}
+
+func Export(args map[string]interface{}) {
+	name := args["<exchange>"].(string)
+	exchange, err := NewExchange(name, map[string]interface{}{})
+	if err != nil {
+		panic(err)
+	}
+
+	fills, transactions, err := activity(exchange.Account())
+	if err != nil {
+		log.Fatalf("Unable to export %s: %v", name, err)
+	}
+
+	options := export.Options{Account: "Assets:" + export.AccountName(name)}
+
+	switch format := args["--format"].(string); format {
+	case "ledger":
+		err = export.WriteLedger(os.Stdout, fills, transactions, options)
+	case "beancount":
+		err = export.WriteBeancount(os.Stdout, fills, transactions, options)
+	case "gains":
+		var ledger *accounting.Ledger
+		ledger, err = accounting.NewLedger(map[string]interface{}{
+			"method": args["--method"].(string),
+		})
+		if err != nil {
+			panic(err)
+		}
+		for _, fill := range fills {
+			if err := ledger.AddFill(fill.Trade, fill.Fee); err != nil {
+				log.Printf("Skipping fill %s: %v", fill.Id, err)
+			}
+		}
+		err = export.WriteGains(os.Stdout, ledger.Disposals())
+	default:
+		err = errors.New("Unknown export format " + format)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// returns the fills and transactions of an account, only accounts that keep
+// their fills like paper accounts can be exported. drivers panic for methods
+// they don't implement, which is returned as an error
+func activity(account babelcoin.ExchangeAccount) (fills []babelcoin.Fill, transactions []babelcoin.Transaction, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	history, ok := account.(babelcoin.FillHistory)
+	if !ok {
+		return nil, nil, errors.New("The account doesn't keep the fills of its orders, use a paper account")
+	}
+
+	if fills, err = history.Fills(); err != nil {
+		return nil, nil, err
+	}
+
+	transactions, err = account.Transactions(0)
+	return fills, transactions, err
+}
+
+func Route(args map[string]interface{}) {
+	amount, err := strconv.ParseFloat(args["<amount>"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	limit, err := strconv.ParseFloat(args["--limit"].(string), 64)
+	if err != nil {
+		panic(err)
+	}
+
+	venues := []router.Venue{}
+	for _, name := range args["<venue>"].([]string) {
+		exchange, err := NewExchange(name, map[string]interface{}{})
+		if err != nil {
+			panic(err)
+		}
+		venues = append(venues, router.Venue{Name: name, Exchange: exchange})
+	}
+
+	t := babelcoin.Buy
+	if args["sell"].(bool) {
+		t = babelcoin.Sell
+	}
+	pair := babelcoin.ParsePair(args["<pair>"].(string))
+	r := router.NewRouter(venues, map[string]interface{}{})
+
+	if args["--plan"].(bool) {
+		plan, err := r.Plan(t, pair, amount, limit)
+		if err != nil {
+			panic(err)
+		}
+		for _, a := range plan.Allocations {
+			log.Printf("%s %.8f on %s @ %.8f (limit %.8f)", t, a.Amount, a.Venue, a.Price, a.Limit)
+		}
+		log.Printf("Total cost %.8f @ %.8f, %.8f unfilled", plan.Cost, plan.Price, plan.Unfilled)
+		return
+	}
+
+	result, err := r.Route(t, pair, amount, limit)
+	for _, child := range result.Children {
+		if child.Err == nil {
+			log.Printf("Placed order %s on %s, %.8f of %.8f filled",
+				child.Order.Id, child.Allocation.Venue, child.Order.Amount-child.Order.Remains, child.Order.Amount)
+		}
+	}
+	log.Println(result.String())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// returns the exchanges that have keys set in the environment
+func ConfiguredExchanges() []string {
+	names := []string{}
+	for name, env := range map[string]string{"btce": "BTCE_KEY", "cryptsy": "CRYPTSY_KEY"} {
+		if os.Getenv(env) != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 /*
 func Symbols(args map[string]interface{}) {
 	exchange, err := factory.NewExchange(args["<exchange>"].(string))
@@ -211,7 +856,7 @@ poll:
 			} else {
 				spew.Dump(trade)
 			}
-		case <-time.After(timeout):
+		case <-babelcoin.ExchangeClock(exchange).After(timeout):
 			log.Printf("Order timed out, cancelling")
 			close(trades)
 			err := order.Cancel()
@@ -234,14 +879,83 @@ func NewExchange(exchange string, config map[string]interface{}) (babelcoin.Exch
 	case "cryptsy":
 		config["key"] = os.Getenv("CRYPTSY_KEY")
 		config["secret"] = os.Getenv("CRYPTSY_SECRET")
-		return cryptsy.New(exchange, config), nil
+		return dryRun(exchange, cryptsy.New(exchange, config), config), nil
 	case "bitcoincharts":
 		return bitcoincharts.New(exchange, config), nil
 	case "btce":
 		config["key"] = os.Getenv("BTCE_KEY")
 		config["secret"] = os.Getenv("BTCE_SECRET")
-		return btce.New(exchange, config), nil
+		return dryRun(exchange, btce.New(exchange, config), config), nil
+	case "paper":
+		if len(parts) != 2 {
+			return nil, errors.New("Exchange name must be in paper:xxxx format")
+		}
+		live, err := NewExchange(parts[1], config)
+		if err != nil {
+			return nil, err
+		}
+		config["balances"] = os.Getenv("PAPER_BALANCES")
+		config["state_file"] = os.Getenv("PAPER_STATE_FILE")
+		return paper.Wrap(exchange, live, config), nil
+	case "aggregate":
+		if len(parts) != 2 {
+			return nil, errors.New("Exchange name must be in aggregate:xxxx,yyyy format")
+		}
+		venues := map[string]babelcoin.Exchange{}
+		for _, name := range strings.Split(parts[1], ",") {
+			venueConfig := map[string]interface{}{}
+			for k, v := range config {
+				venueConfig[k] = v
+			}
+
+			venue, err := NewExchange(name, venueConfig)
+			if err != nil {
+				return nil, err
+			}
+			venues[name] = venue
+		}
+		return aggregate.Combine(exchange, venues), nil
+	case "replay":
+		if len(parts) != 2 {
+			return nil, errors.New("Exchange name must be in replay:xxxx format")
+		}
+		config["dir"] = os.Getenv("REPLAY_DIR")
+		config["speed"] = os.Getenv("REPLAY_SPEED")
+		return replay.New(exchange, config), nil
+	case "risk":
+		if len(parts) != 2 {
+			return nil, errors.New("Exchange name must be in risk:xxxx format")
+		}
+		live, err := NewExchange(parts[1], config)
+		if err != nil {
+			return nil, err
+		}
+		config["max_amount"] = os.Getenv("RISK_MAX_AMOUNT")
+		config["max_notional"] = os.Getenv("RISK_MAX_NOTIONAL")
+		config["max_orders"] = os.Getenv("RISK_MAX_ORDERS")
+		config["price_band"] = os.Getenv("RISK_PRICE_BAND")
+		config["max_daily_loss"] = os.Getenv("RISK_MAX_DAILY_LOSS")
+		return risk.Wrap(exchange, live, config), nil
+	case "dryrun":
+		if len(parts) != 2 {
+			return nil, errors.New("Exchange name must be in dryrun:xxxx format")
+		}
+		live, err := NewExchange(parts[1], config)
+		if err != nil {
+			return nil, err
+		}
+		return dryrun.Wrap(exchange, live, config), nil
 	}
 
 	return nil, errors.New("Unknown exchange " + exchange)
 }
+
+// wraps a driver that places real orders in a dry run if DRY_RUN is set.
+// only these are wrapped, so that drivers around them like risk still see
+// the simulated orders
+func dryRun(name string, exchange babelcoin.Exchange, config map[string]interface{}) babelcoin.Exchange {
+	if enabled, _ := strconv.ParseBool(os.Getenv("DRY_RUN")); enabled {
+		return dryrun.Wrap("dryrun:"+name, exchange, config)
+	}
+	return exchange
+}
@@ -0,0 +1,423 @@
+/*
+A local order matching engine for a single pair, used for simulating
+exchanges in fake drivers and backtests.
+
+Orders are matched with price-time priority, limit orders that aren't
+fully filled rest on the book and market orders (a rate of -1) walk the
+book until filled or the book is exhausted.
+*/
+package matching
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// amounts smaller than this are considered filled, to absorb float error
+const dust = 1e-12
+
+// a match between a resting maker order and an incoming taker order,
+// the maker and taker are the state of the orders after the fill
+type Fill struct {
+	b.Trade
+	Maker, Taker b.Order
+}
+
+type Engine struct {
+	Exchange string
+	Pair     b.Pair
+	Clock    b.Clock
+	bids     side
+	asks     side
+	orders   map[string]*entry
+	free     []*entry
+	levels   []*level
+	fills    []Fill
+	sequence int64
+	trades   int64
+}
+
+// a resting order, linked into the queue of its price level
+type entry struct {
+	order      b.Order
+	level      *level
+	prev, next *entry
+}
+
+// a price level, orders are queued in time order from head to tail
+type level struct {
+	price      float64
+	head, tail *entry
+	total      float64
+}
+
+// the levels on one side of the book, sorted with the best price last
+type side struct {
+	levels []*level
+	buy    bool
+}
+
+// creates an empty matching engine for a pair
+func New(exchange string, pair b.Pair) *Engine {
+	return &Engine{
+		Exchange: exchange,
+		Pair:     pair,
+		Clock:    b.RealClock{},
+		bids:     side{buy: true},
+		asks:     side{buy: false},
+		orders:   make(map[string]*entry, 1024),
+	}
+}
+
+// submits an order to the engine, it's matched against the book and the
+// remainder of a limit order rests. returns the fills and final order. the
+// fills are reused by the next call to the engine, copy them to keep them
+func (e *Engine) Submit(order b.Order) ([]Fill, b.Order, error) {
+	if order.Type != b.Buy && order.Type != b.Sell {
+		return nil, order, errors.New("Unknown trade type " + string(order.Type))
+	} else if order.Amount <= 0 {
+		return nil, order, errors.New("Invalid order amount")
+	} else if order.Rate <= 0 && order.Rate != -1 {
+		return nil, order, errors.New("Invalid order rate")
+	}
+
+	e.sequence++
+	if order.Id == "" {
+		order.Id = strconv.FormatInt(e.sequence, 10)
+	} else if _, exists := e.orders[order.Id]; exists {
+		return nil, order, errors.New("Duplicate order " + order.Id)
+	}
+	if order.Pair == (b.Pair{}) {
+		order.Pair = e.Pair
+	}
+	if order.Timestamp.IsZero() {
+		order.Timestamp = e.Clock.Now()
+	}
+	order.Remains = order.Amount
+
+	e.fills = e.match(&order, e.fills[:0])
+
+	if order.Remains > dust && order.Rate != -1 {
+		e.rest(order)
+	} else if order.Remains <= dust {
+		order.Remains = 0
+	}
+
+	return e.fills, order, nil
+}
+
+// adds a limit order to the book without matching it, for seeding the
+// engine from an order book that may be crossed
+func (e *Engine) Add(order b.Order) (b.Order, error) {
+	if order.Type != b.Buy && order.Type != b.Sell {
+		return order, errors.New("Unknown trade type " + string(order.Type))
+	} else if order.Amount <= 0 {
+		return order, errors.New("Invalid order amount")
+	} else if order.Rate <= 0 {
+		return order, errors.New("Invalid order rate")
+	}
+
+	e.sequence++
+	if order.Id == "" {
+		order.Id = strconv.FormatInt(e.sequence, 10)
+	} else if _, exists := e.orders[order.Id]; exists {
+		return order, errors.New("Duplicate order " + order.Id)
+	}
+	if order.Pair == (b.Pair{}) {
+		order.Pair = e.Pair
+	}
+	if order.Timestamp.IsZero() {
+		order.Timestamp = e.Clock.Now()
+	}
+	order.Remains = order.Amount
+
+	e.rest(order)
+	return order, nil
+}
+
+// cancels a resting order, returning its final state
+func (e *Engine) Cancel(id string) (b.Order, error) {
+	en, ok := e.orders[id]
+	if !ok {
+		return b.Order{}, errors.New("Unknown order " + id)
+	}
+
+	order := en.order
+	e.remove(en)
+	return order, nil
+}
+
+// replaces the amount and rate of a resting order. reducing the amount at
+// the same rate keeps time priority, otherwise the order is requeued. the
+// fills are reused by the next call to the engine
+func (e *Engine) Replace(id string, amount float64, rate float64) ([]Fill, b.Order, error) {
+	en, ok := e.orders[id]
+	if !ok {
+		return nil, b.Order{}, errors.New("Unknown order " + id)
+	}
+	if rate <= 0 {
+		return nil, en.order, errors.New("Invalid order rate")
+	}
+
+	filled := en.order.Amount - en.order.Remains
+	if amount <= filled {
+		return nil, en.order, errors.New("Replaced amount must exceed the filled amount")
+	}
+
+	if rate == en.order.Rate && amount <= en.order.Amount {
+		en.level.total -= en.order.Amount - amount
+		en.order.Remains -= en.order.Amount - amount
+		en.order.Amount = amount
+		return nil, en.order, nil
+	}
+
+	order := en.order
+	e.remove(en)
+
+	order.Amount, order.Rate = amount, rate
+	order.Timestamp = e.Clock.Now()
+	order.Remains = amount - filled
+
+	e.fills = e.match(&order, e.fills[:0])
+	if order.Remains > dust {
+		e.rest(order)
+	} else {
+		order.Remains = 0
+	}
+
+	return e.fills, order, nil
+}
+
+// returns a resting order by id
+func (e *Engine) Order(id string) (b.Order, bool) {
+	if en, ok := e.orders[id]; ok {
+		return en.order, true
+	}
+	return b.Order{}, false
+}
+
+// returns the resting orders in the engine
+func (e *Engine) Orders() []b.Order {
+	orders := make([]b.Order, 0, len(e.orders))
+	for _, en := range e.orders {
+		orders = append(orders, en.order)
+	}
+	return orders
+}
+
+// returns the aggregated order book, limited to the top N levels if limit > 0
+func (e *Engine) Book(limit int) b.OrderBook {
+	return b.OrderBook{
+		Asks: e.asks.aggregate(limit),
+		Bids: e.bids.aggregate(limit),
+	}
+}
+
+// returns the best bid and ask, zero if a side is empty
+func (e *Engine) Spread() (bid float64, ask float64) {
+	if n := len(e.bids.levels); n > 0 {
+		bid = e.bids.levels[n-1].price
+	}
+	if n := len(e.asks.levels); n > 0 {
+		ask = e.asks.levels[n-1].price
+	}
+	return bid, ask
+}
+
+func (e *Engine) side(t b.TradeType) *side {
+	if t == b.Buy {
+		return &e.bids
+	}
+	return &e.asks
+}
+
+// matches a taker order against the opposite side of the book
+func (e *Engine) match(taker *b.Order, fills []Fill) []Fill {
+	opposite := &e.asks
+	if taker.Type == b.Sell {
+		opposite = &e.bids
+	}
+
+	for taker.Remains > dust && len(opposite.levels) > 0 {
+		l := opposite.levels[len(opposite.levels)-1]
+		if taker.Rate != -1 && !crosses(taker.Type, l.price, taker.Rate) {
+			break
+		}
+
+		for taker.Remains > dust && l.head != nil {
+			maker := l.head
+			amount := maker.order.Remains
+			if taker.Remains < amount {
+				amount = taker.Remains
+			}
+
+			maker.order.Remains -= amount
+			taker.Remains -= amount
+			l.total -= amount
+			if maker.order.Remains <= dust {
+				maker.order.Remains = 0
+			}
+
+			e.trades++
+			fills = append(fills, Fill{
+				Trade: b.Trade{
+					Id:        strconv.FormatInt(e.trades, 10),
+					Pair:      e.Pair,
+					Amount:    amount,
+					Rate:      l.price,
+					Timestamp: taker.Timestamp,
+					Type:      taker.Type,
+					Exchange:  e.Exchange,
+				},
+				Maker: maker.order,
+				Taker: *taker,
+			})
+
+			if maker.order.Remains == 0 {
+				l.unlink(maker)
+				delete(e.orders, maker.order.Id)
+				e.release(maker)
+			}
+		}
+
+		if l.head == nil {
+			opposite.levels = opposite.levels[:len(opposite.levels)-1]
+			e.releaseLevel(l)
+		}
+	}
+
+	return fills
+}
+
+// adds an order to the book, after all orders at the same price
+func (e *Engine) rest(order b.Order) {
+	s := e.side(order.Type)
+	l := s.level(order.Rate, e.allocLevel)
+	en := e.alloc()
+	en.order, en.level = order, l
+
+	if l.tail == nil {
+		l.head, l.tail = en, en
+	} else {
+		en.prev, l.tail.next, l.tail = l.tail, en, en
+	}
+	l.total += order.Remains
+	e.orders[order.Id] = en
+}
+
+// takes a resting order off the book
+func (e *Engine) remove(en *entry) {
+	l, s := en.level, e.side(en.order.Type)
+	l.total -= en.order.Remains
+	l.unlink(en)
+	delete(e.orders, en.order.Id)
+	e.release(en)
+
+	if l.head == nil {
+		s.remove(l)
+		e.releaseLevel(l)
+	}
+}
+
+// returns an entry from the free list, or a new one
+func (e *Engine) alloc() *entry {
+	if n := len(e.free); n > 0 {
+		en := e.free[n-1]
+		e.free = e.free[:n-1]
+		return en
+	}
+	return &entry{}
+}
+
+// returns an entry that is no longer on the book to the free list
+func (e *Engine) release(en *entry) {
+	*en = entry{}
+	e.free = append(e.free, en)
+}
+
+// returns a level from the free list, or a new one
+func (e *Engine) allocLevel(price float64) *level {
+	if n := len(e.levels); n > 0 {
+		l := e.levels[n-1]
+		e.levels = e.levels[:n-1]
+		l.price = price
+		return l
+	}
+	return &level{price: price}
+}
+
+// returns an empty level to the free list
+func (e *Engine) releaseLevel(l *level) {
+	*l = level{}
+	e.levels = append(e.levels, l)
+}
+
+// removes an order from the level's queue
+func (l *level) unlink(en *entry) {
+	if en.prev != nil {
+		en.prev.next = en.next
+	} else {
+		l.head = en.next
+	}
+	if en.next != nil {
+		en.next.prev = en.prev
+	} else {
+		l.tail = en.prev
+	}
+	en.prev, en.next = nil, nil
+}
+
+// finds the index a price would be at in the sorted levels
+func (s *side) search(price float64) int {
+	if s.buy {
+		return sort.Search(len(s.levels), func(i int) bool { return s.levels[i].price >= price })
+	}
+	return sort.Search(len(s.levels), func(i int) bool { return s.levels[i].price <= price })
+}
+
+// returns the level for a price, creating it with alloc if needed
+func (s *side) level(price float64, alloc func(float64) *level) *level {
+	i := s.search(price)
+	if i < len(s.levels) && s.levels[i].price == price {
+		return s.levels[i]
+	}
+
+	l := alloc(price)
+	s.levels = append(s.levels, nil)
+	copy(s.levels[i+1:], s.levels[i:])
+	s.levels[i] = l
+	return l
+}
+
+func (s *side) remove(l *level) {
+	i := s.search(l.price)
+	if i < len(s.levels) && s.levels[i] == l {
+		s.levels = append(s.levels[:i], s.levels[i+1:]...)
+	}
+}
+
+// returns the levels best first, as they appear in an OrderBook
+func (s *side) aggregate(limit int) []b.Level {
+	n := len(s.levels)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+
+	levels := make([]b.Level, n)
+	for i := 0; i < n; i++ {
+		l := s.levels[len(s.levels)-1-i]
+		levels[i].Price, levels[i].Amount = l.price, l.total
+	}
+	return levels
+}
+
+// returns true if a taker of type t at rate would match a maker at price
+func crosses(t b.TradeType, price float64, rate float64) bool {
+	if t == b.Buy {
+		return price <= rate
+	}
+	return price >= rate
+}
@@ -0,0 +1,181 @@
+package matching
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func order(t babel.TradeType, amount float64, rate float64) babel.Order {
+	return babel.Order{Type: t, Amount: amount, Rate: rate}
+}
+
+func TestEngineSpec(t *testing.T) {
+	Convey("Subject: Matching Engine", t, func() {
+		engine := New("test", babel.BTC_USD)
+
+		engine.Submit(order(babel.Sell, 1, 101))
+		engine.Submit(order(babel.Sell, 2, 102))
+		engine.Submit(order(babel.Sell, 1, 101))
+		engine.Submit(order(babel.Buy, 3, 99))
+
+		Convey(`Resting orders should be aggregated into the book`, func() {
+			book := engine.Book(0)
+			So(len(book.Asks), ShouldEqual, 2)
+			So(book.Asks[0].Price, ShouldEqual, 101)
+			So(book.Asks[0].Amount, ShouldEqual, 2)
+			So(book.Bids[0].Price, ShouldEqual, 99)
+
+			bid, ask := engine.Spread()
+			So(bid, ShouldEqual, 99)
+			So(ask, ShouldEqual, 101)
+		})
+
+		Convey(`Orders at the same price should fill in time order`, func() {
+			fills, taker, err := engine.Submit(order(babel.Buy, 1.5, 101))
+			So(err, ShouldBeNil)
+			So(taker.Remains, ShouldEqual, 0)
+			So(len(fills), ShouldEqual, 2)
+			So(fills[0].Maker.Id, ShouldEqual, "1")
+			So(fills[0].Maker.Remains, ShouldEqual, 0)
+			So(fills[1].Maker.Id, ShouldEqual, "3")
+			So(fills[1].Maker.Remains, ShouldEqual, 0.5)
+		})
+
+		Convey(`Market orders should walk the book`, func() {
+			fills, taker, err := engine.Submit(order(babel.Buy, 5, -1))
+			So(err, ShouldBeNil)
+			So(len(fills), ShouldEqual, 3)
+			So(fills[2].Rate, ShouldEqual, 102)
+			So(taker.Remains, ShouldEqual, 1)
+			So(len(engine.Book(0).Asks), ShouldEqual, 0)
+
+			_, ok := engine.Order(taker.Id)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey(`Limit orders should partially fill and rest`, func() {
+			fills, taker, _ := engine.Submit(order(babel.Sell, 4, 99))
+			So(len(fills), ShouldEqual, 1)
+			So(taker.Remains, ShouldEqual, 1)
+
+			book := engine.Book(0)
+			So(book.Asks[0].Price, ShouldEqual, 99)
+			So(book.Asks[0].Amount, ShouldEqual, 1)
+			So(len(book.Bids), ShouldEqual, 0)
+		})
+
+		Convey(`Cancelled orders should be removed from the book`, func() {
+			cancelled, err := engine.Cancel("1")
+			So(err, ShouldBeNil)
+			So(cancelled.Remains, ShouldEqual, 1)
+
+			fills, _, _ := engine.Submit(order(babel.Buy, 1, 101))
+			So(fills[0].Maker.Id, ShouldEqual, "3")
+
+			_, err = engine.Cancel("1")
+			So(err, ShouldNotBeNil)
+
+			engine.Cancel("4")
+			book := engine.Book(0)
+			So(len(book.Bids), ShouldEqual, 0)
+			So(len(book.Asks), ShouldEqual, 1)
+		})
+
+		Convey(`Replacing an order should requeue it unless reduced`, func() {
+			_, replaced, err := engine.Replace("1", 0.5, 101)
+			So(err, ShouldBeNil)
+			So(replaced.Remains, ShouldEqual, 0.5)
+
+			fills, _, _ := engine.Submit(order(babel.Buy, 0.1, 101))
+			So(fills[0].Maker.Id, ShouldEqual, "1")
+
+			engine.Replace("1", 2, 101)
+			fills, _, _ = engine.Submit(order(babel.Buy, 0.1, 101))
+			So(fills[0].Maker.Id, ShouldEqual, "3")
+		})
+
+		Convey(`Added orders should rest without matching`, func() {
+			added, err := engine.Add(order(babel.Buy, 1, 105))
+			So(err, ShouldBeNil)
+			So(added.Remains, ShouldEqual, 1)
+
+			bid, ask := engine.Spread()
+			So(bid, ShouldEqual, 105)
+			So(ask, ShouldEqual, 101)
+
+			fills, _, _ := engine.Submit(order(babel.Sell, 1, 105))
+			So(fills[0].Maker.Id, ShouldEqual, added.Id)
+		})
+
+		Convey(`Replacing an order with an invalid rate should fail`, func() {
+			_, _, err := engine.Replace("1", 1, 0)
+			So(err, ShouldNotBeNil)
+			_, _, err = engine.Replace("1", 1, -1)
+			So(err, ShouldNotBeNil)
+
+			resting, ok := engine.Order("1")
+			So(ok, ShouldBeTrue)
+			So(resting.Rate, ShouldEqual, 101)
+		})
+
+		Convey(`Replacing an order across the spread should fill it`, func() {
+			fills, replaced, err := engine.Replace("4", 3, 101)
+			So(err, ShouldBeNil)
+			So(len(fills), ShouldEqual, 2)
+			So(replaced.Remains, ShouldEqual, 1)
+		})
+	})
+}
+
+func BenchmarkSubmit(b *testing.B) {
+	engine := New("bench", babel.BTC_USD)
+	random := rand.New(rand.NewSource(1))
+	orders := make([]babel.Order, 1<<16)
+
+	for i := range orders {
+		t := babel.Buy
+		if random.Intn(2) == 0 {
+			t = babel.Sell
+		}
+		// half of orders rest within 10 of the mid and half are market
+		// orders, so the volume taken matches the volume resting and the
+		// book stays bounded
+		rate := float64(1000 - 1 - random.Intn(10))
+		if t == babel.Sell {
+			rate = 2000 - rate
+		}
+		if random.Intn(2) == 0 {
+			rate = -1
+		}
+		orders[i] = babel.Order{
+			Type:      t,
+			Amount:    float64(1 + random.Intn(10)),
+			Rate:      rate,
+			Timestamp: time.Unix(int64(i), 0),
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.Submit(orders[i&(len(orders)-1)])
+	}
+}
+
+func BenchmarkSubmitCancel(b *testing.B) {
+	engine := New("bench", babel.BTC_USD)
+	random := rand.New(rand.NewSource(1))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, o, _ := engine.Submit(babel.Order{
+			Type: babel.Buy, Amount: 1, Rate: float64(900 + random.Intn(50)),
+		})
+		engine.Cancel(o.Id)
+	}
+}
@@ -0,0 +1,133 @@
+package orderbook
+
+import (
+	"sort"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// a local level 2 order book for a pair, keyed by price
+type Book struct {
+	Pair     b.Pair
+	Sequence int64
+	Updated  time.Time
+	bids     map[float64]float64
+	asks     map[float64]float64
+}
+
+// creates an empty book for a pair
+func NewBook(pair b.Pair) *Book {
+	return &Book{
+		Pair: pair,
+		bids: map[float64]float64{},
+		asks: map[float64]float64{},
+	}
+}
+
+// applies a change to the book, and returns false if it didn't change
+func (bk *Book) Apply(delta b.BookDelta) bool {
+	side := bk.side(delta.Type)
+	if delta.Sequence != 0 {
+		bk.Sequence = delta.Sequence
+	}
+
+	amount, ok := side[delta.Price]
+	if delta.Amount <= 0 {
+		delete(side, delta.Price)
+		return ok
+	}
+	side[delta.Price] = delta.Amount
+	return !ok || amount != delta.Amount
+}
+
+// returns the changes that turn the book into a snapshot of it
+func (bk *Book) Diff(snapshot b.OrderBook) []b.BookDelta {
+	return append(
+		diff(bk.Pair, b.Buy, bk.bids, snapshot.Bids),
+		diff(bk.Pair, b.Sell, bk.asks, snapshot.Asks)...)
+}
+
+// replaces the book with a snapshot, and returns the changes it made. the
+// book takes the snapshot's sequence, so changes that predate it are dropped
+func (bk *Book) Reset(snapshot b.OrderBook) []b.BookDelta {
+	changes := bk.Diff(snapshot)
+	for _, delta := range changes {
+		bk.Apply(delta)
+	}
+	bk.Sequence = snapshot.Sequence
+	return changes
+}
+
+// returns the book best first, a limit of 0 returns every level
+func (bk *Book) OrderBook(limit int) b.OrderBook {
+	book := b.OrderBook{Asks: levels(bk.asks), Bids: levels(bk.bids), Sequence: bk.Sequence}
+	b.SortLevels(book.Asks, false)
+	b.SortLevels(book.Bids, true)
+
+	if limit > 0 && len(book.Asks) > limit {
+		book.Asks = book.Asks[:limit]
+	}
+	if limit > 0 && len(book.Bids) > limit {
+		book.Bids = book.Bids[:limit]
+	}
+	return book
+}
+
+// returns true if the best bid is at or above the best ask
+func (bk *Book) Crossed() bool {
+	book := b.OrderBook{Asks: levels(bk.asks), Bids: levels(bk.bids)}
+	bid, hasBid := book.BestBid()
+	ask, hasAsk := book.BestAsk()
+	return hasBid && hasAsk && bid.Price >= ask.Price
+}
+
+func (bk *Book) side(t b.TradeType) map[float64]float64 {
+	if t == b.Buy {
+		return bk.bids
+	}
+	return bk.asks
+}
+
+// returns the changes from the levels in side to those in a snapshot, best
+// first so that they're in a stable order
+func diff(pair b.Pair, t b.TradeType, side map[float64]float64, snapshot []b.Level) []b.BookDelta {
+	target := map[float64]float64{}
+	for _, l := range snapshot {
+		if l.Amount > 0 {
+			target[l.Price] += l.Amount
+		}
+	}
+
+	prices := []float64{}
+	for price := range side {
+		prices = append(prices, price)
+	}
+	for price := range target {
+		if _, ok := side[price]; !ok {
+			prices = append(prices, price)
+		}
+	}
+	if t == b.Buy {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+
+	changes := []b.BookDelta{}
+	for _, price := range prices {
+		amount, ok := target[price]
+		if current, exists := side[price]; !ok || !exists || current != amount {
+			changes = append(changes, b.BookDelta{Pair: pair, Type: t, Price: price, Amount: amount})
+		}
+	}
+	return changes
+}
+
+func levels(side map[float64]float64) []b.Level {
+	levels := make([]b.Level, 0, len(side))
+	for price, amount := range side {
+		levels = append(levels, b.Level{Price: price, Amount: amount})
+	}
+	return levels
+}
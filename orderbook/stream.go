@@ -0,0 +1,313 @@
+/*
+Local level 2 order books, maintained from a snapshot and the changes to it.
+
+A Stream keeps a Book for each pair it watches. Exchanges that implement
+b.OrderBookStreamer push their changes, which are applied to a snapshot
+from ExchangeAccount.OrderBook. Other exchanges are polled, and the changes
+are the difference between successive snapshots. Every change is sent to
+the watcher as an Event, so nothing between polls of the stream is lost.
+
+A book that crosses, misses a sequence number or goes without an update
+for longer than the stale duration is resynced from a new snapshot.
+*/
+package orderbook
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// the kind of event sent by a Stream
+type EventType string
+
+const (
+	Snapshot EventType = "snapshot"
+	Update   EventType = "update"
+	Crossed  EventType = "crossed"
+	Stale    EventType = "stale"
+)
+
+// a change to a book. snapshots carry the changes from the previous book and
+// crossed and stale events are followed by a snapshot once it's resynced
+type Event struct {
+	Type    EventType
+	Pair    b.Pair
+	Changes []b.BookDelta
+	Book    b.OrderBook
+	Time    time.Time
+}
+
+type Stream struct {
+	exchange b.Exchange
+	clock    b.Clock
+	depth    int
+	interval time.Duration
+	stale    time.Duration
+	mutex    sync.Mutex
+	books    map[b.Pair]*watched
+	done     chan struct{}
+	stopped  bool
+}
+
+// a book being watched and the state of its resyncs
+type watched struct {
+	book    *Book
+	channel chan<- Event
+	resync  bool
+	stale   bool
+}
+
+// creates a stream of books on an exchange. accepts depth (default 50) for
+// the size of snapshots, interval (default 5s) for how often they're polled
+// or checked, stale (default 1m) and a clock as config
+func NewStream(exchange b.Exchange, config map[string]interface{}) *Stream {
+	s := &Stream{
+		exchange: exchange,
+		clock:    b.ConfigClock(config),
+		depth:    50,
+		interval: b.ConfigDuration(config, "interval", 5*time.Second),
+		stale:    b.ConfigDuration(config, "stale", time.Minute),
+		books:    map[b.Pair]*watched{},
+		done:     make(chan struct{}),
+	}
+
+	if depth, ok := config["depth"].(int); ok {
+		s.depth = depth
+	}
+	return s
+}
+
+// starts maintaining the book for a pair, sending an event to the channel
+// for the first snapshot and every change after it
+func (s *Stream) Watch(pair b.Pair, channel chan<- Event) error {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return errors.New("Stream has been stopped")
+	}
+	if _, ok := s.books[pair]; ok {
+		s.mutex.Unlock()
+		return errors.New("Already watching " + pair.String())
+	}
+	w := &watched{book: NewBook(pair), channel: channel}
+	s.books[pair] = w
+	s.mutex.Unlock()
+
+	var deltas chan b.BookDelta
+	if streamer, ok := s.exchange.(b.OrderBookStreamer); ok {
+		deltas = make(chan b.BookDelta, 100)
+		if err := streamer.OrderBookDeltas(pair, deltas); err != nil {
+			s.forget(pair)
+			return err
+		}
+	}
+
+	if err := s.resync(w); err != nil {
+		s.forget(pair)
+		return err
+	}
+
+	go s.run(w, deltas)
+	return nil
+}
+
+// returns a copy of the book for a pair, false if it isn't being watched
+func (s *Stream) Book(pair b.Pair) (b.OrderBook, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w, ok := s.books[pair]
+	if !ok {
+		return b.OrderBook{}, false
+	}
+	return w.book.OrderBook(0), true
+}
+
+// stops maintaining every book, a stopped stream can't be watched again
+func (s *Stream) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+}
+
+func (s *Stream) run(w *watched, deltas chan b.BookDelta) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case delta, ok := <-deltas:
+			if !ok {
+				log.Printf("Changes to %s stopped, polling instead", w.book.Pair.String())
+				deltas = nil
+				continue
+			}
+			s.apply(w, delta)
+		case <-ticker.C():
+			if deltas == nil {
+				s.poll(w)
+			} else {
+				s.check(w)
+			}
+		}
+	}
+}
+
+// applies a change that was pushed by the exchange, dropping it if it
+// predates the book and resyncing if it skips a sequence number or crosses
+// the book
+func (s *Stream) apply(w *watched, delta b.BookDelta) {
+	s.mutex.Lock()
+	if w.resync {
+		s.mutex.Unlock()
+		return
+	}
+
+	last := w.book.Sequence
+	if delta.Sequence != 0 && delta.Sequence <= last {
+		s.mutex.Unlock()
+		return
+	}
+	if last != 0 && delta.Sequence != 0 && delta.Sequence != last+1 {
+		log.Printf("Missed changes to %s between %d and %d, resyncing",
+			w.book.Pair.String(), last, delta.Sequence)
+		w.resync = true
+		s.mutex.Unlock()
+		s.resync(w)
+		return
+	}
+
+	changed := w.book.Apply(delta)
+	w.book.Updated = s.clock.Now()
+	w.stale = false
+	event := s.event(w, Update, []b.BookDelta{delta})
+	crossed := w.book.Crossed()
+	s.mutex.Unlock()
+
+	if changed {
+		s.send(w, event)
+	}
+	if crossed {
+		s.crossed(w)
+	}
+}
+
+// polls a snapshot and sends the difference from the last one
+func (s *Stream) poll(w *watched) {
+	book, err := s.exchange.Account().OrderBook(w.book.Pair, s.depth)
+	if err != nil {
+		log.Printf("Failed to poll %s: %v", w.book.Pair.String(), err)
+		s.check(w)
+		return
+	}
+
+	s.mutex.Lock()
+	if w.resync {
+		s.mutex.Unlock()
+		s.resync(w)
+		return
+	}
+
+	changes := w.book.Reset(book)
+	w.book.Updated = s.clock.Now()
+	w.stale = false
+	event := s.event(w, Update, changes)
+	crossed := w.book.Crossed()
+	s.mutex.Unlock()
+
+	if len(changes) > 0 {
+		s.send(w, event)
+	}
+	if crossed {
+		s.crossed(w)
+	}
+}
+
+// resyncs a book that failed to resync before, or that has gone stale
+func (s *Stream) check(w *watched) {
+	s.mutex.Lock()
+	resync := w.resync
+	stale := !w.stale && s.clock.Now().Sub(w.book.Updated) > s.stale
+	if stale {
+		w.stale, w.resync = true, true
+	}
+	event := s.event(w, Stale, nil)
+	s.mutex.Unlock()
+
+	if stale {
+		log.Printf("No changes to %s since %s, resyncing", w.book.Pair.String(), w.book.Updated)
+		s.send(w, event)
+	}
+	if resync || stale {
+		s.resync(w)
+	}
+}
+
+// sends a crossed event and resyncs the book
+func (s *Stream) crossed(w *watched) {
+	s.mutex.Lock()
+	w.resync = true
+	event := s.event(w, Crossed, nil)
+	s.mutex.Unlock()
+
+	log.Printf("Book for %s is crossed, resyncing", w.book.Pair.String())
+	s.send(w, event)
+	s.resync(w)
+}
+
+// replaces a book with a new snapshot and sends it. if the snapshot fails
+// the book is resynced again on the next tick
+func (s *Stream) resync(w *watched) error {
+	book, err := s.exchange.Account().OrderBook(w.book.Pair, s.depth)
+	if err != nil {
+		log.Printf("Failed to resync %s: %v", w.book.Pair.String(), err)
+		s.mutex.Lock()
+		w.resync = true
+		s.mutex.Unlock()
+		return err
+	}
+
+	s.mutex.Lock()
+	changes := w.book.Reset(book)
+	w.book.Updated = s.clock.Now()
+	w.resync, w.stale = false, false
+	event := s.event(w, Snapshot, changes)
+	s.mutex.Unlock()
+
+	s.send(w, event)
+	return nil
+}
+
+// builds an event from the current state of a book, the mutex must be held
+func (s *Stream) event(w *watched, t EventType, changes []b.BookDelta) Event {
+	return Event{
+		Type:    t,
+		Pair:    w.book.Pair,
+		Changes: changes,
+		Book:    w.book.OrderBook(0),
+		Time:    s.clock.Now(),
+	}
+}
+
+func (s *Stream) send(w *watched, event Event) {
+	select {
+	case w.channel <- event:
+	case <-s.done:
+	}
+}
+
+func (s *Stream) forget(pair b.Pair) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.books, pair)
+}
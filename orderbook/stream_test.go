@@ -0,0 +1,211 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a fake exchange that pushes the changes it's given
+type streaming struct {
+	*fake.Driver
+	deltas   chan<- babel.BookDelta
+	sequence int64
+}
+
+func (s *streaming) Account() babel.ExchangeAccount {
+	return s
+}
+
+// returns the fake's book numbered with the sequence
+func (s *streaming) OrderBook(pair babel.Pair, limit int) (babel.OrderBook, error) {
+	book, err := s.Driver.OrderBook(pair, limit)
+	book.Sequence = s.sequence
+	return book, err
+}
+
+func (s *streaming) OrderBookDeltas(pair babel.Pair, channel chan<- babel.BookDelta) error {
+	s.deltas = channel
+	return nil
+}
+
+// waits for the next event, failing if none arrives
+func next(events chan Event) Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		return Event{}
+	}
+}
+
+// advances the clock once the stream is waiting on it
+func tick(clock *babel.FakeClock, d time.Duration) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if clock.Waiters() == 0 {
+		panic("NOWAITERS")
+	}
+	clock.Advance(d)
+}
+
+func TestBookSpec(t *testing.T) {
+	Convey("Subject: Book", t, func() {
+		book := NewBook(babel.BTC_USD)
+		book.Reset(babel.OrderBook{
+			Asks: []babel.Level{{101, 1}, {102, 2}},
+			Bids: []babel.Level{{99, 1}},
+		})
+
+		Convey(`Changes should set and remove levels`, func() {
+			So(book.Apply(babel.BookDelta{Type: babel.Sell, Price: 101, Amount: 3}), ShouldBeTrue)
+			So(book.Apply(babel.BookDelta{Type: babel.Sell, Price: 101, Amount: 3}), ShouldBeFalse)
+			So(book.Apply(babel.BookDelta{Type: babel.Buy, Price: 99, Amount: 0}), ShouldBeTrue)
+			So(book.OrderBook(0), ShouldResemble, babel.OrderBook{
+				Asks: []babel.Level{{101, 3}, {102, 2}},
+				Bids: []babel.Level{},
+			})
+		})
+
+		Convey(`Diffs should only contain what changed`, func() {
+			changes := book.Diff(babel.OrderBook{
+				Asks: []babel.Level{{102, 2}, {103, 1}},
+				Bids: []babel.Level{{99, 1}},
+			})
+			So(changes, ShouldResemble, []babel.BookDelta{
+				{Pair: babel.BTC_USD, Type: babel.Sell, Price: 101, Amount: 0},
+				{Pair: babel.BTC_USD, Type: babel.Sell, Price: 103, Amount: 1},
+			})
+		})
+
+		Convey(`Resets should take the snapshot's sequence`, func() {
+			book.Apply(babel.BookDelta{Type: babel.Buy, Price: 99, Amount: 2, Sequence: 4})
+			book.Reset(babel.OrderBook{Bids: []babel.Level{{99, 1}}, Sequence: 7})
+			So(book.Sequence, ShouldEqual, 7)
+			So(book.OrderBook(0).Sequence, ShouldEqual, 7)
+		})
+
+		Convey(`Books should know when they're crossed`, func() {
+			So(book.Crossed(), ShouldBeFalse)
+			book.Apply(babel.BookDelta{Type: babel.Buy, Price: 101, Amount: 1})
+			So(book.Crossed(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestStreamSpec(t *testing.T) {
+	Convey("Subject: Order Book Stream", t, func() {
+		clock := babel.NewFakeClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+		exchange := fake.New("fake", map[string]interface{}{"clock": clock}).(*fake.Driver)
+		exchange.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{101, 1}, {102, 2}},
+			Bids: []babel.Level{{99, 1}},
+		})
+		events := make(chan Event, 10)
+
+		Convey(`Polled books should send the differences between polls`, func() {
+			stream := NewStream(exchange, map[string]interface{}{"interval": "5s", "clock": clock})
+			defer stream.Stop()
+			So(stream.Watch(babel.BTC_USD, events), ShouldBeNil)
+
+			event := next(events)
+			So(event.Type, ShouldEqual, Snapshot)
+			So(len(event.Changes), ShouldEqual, 3)
+			So(stream.Watch(babel.BTC_USD, events), ShouldNotBeNil)
+
+			exchange.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{101, 0.5}, {102, 2}},
+				Bids: []babel.Level{{99, 1}, {98, 4}},
+			})
+			tick(clock, 5*time.Second)
+
+			event = next(events)
+			So(event.Type, ShouldEqual, Update)
+			So(event.Changes, ShouldResemble, []babel.BookDelta{
+				{Pair: babel.BTC_USD, Type: babel.Buy, Price: 98, Amount: 4},
+				{Pair: babel.BTC_USD, Type: babel.Sell, Price: 101, Amount: 0.5},
+			})
+
+			book, ok := stream.Book(babel.BTC_USD)
+			So(ok, ShouldBeTrue)
+			So(book.Bids, ShouldResemble, []babel.Level{{99, 1}, {98, 4}})
+		})
+
+		Convey(`Crossed books should be resynced`, func() {
+			stream := NewStream(exchange, map[string]interface{}{"clock": clock})
+			defer stream.Stop()
+			stream.Watch(babel.BTC_USD, events)
+			next(events)
+
+			exchange.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{101, 1}},
+				Bids: []babel.Level{{102, 1}},
+			})
+			tick(clock, 5*time.Second)
+
+			So(next(events).Type, ShouldEqual, Update)
+			So(next(events).Type, ShouldEqual, Crossed)
+			So(next(events).Type, ShouldEqual, Snapshot)
+		})
+
+		Convey(`Books that can't be polled should go stale`, func() {
+			stream := NewStream(exchange, map[string]interface{}{"stale": time.Second, "clock": clock})
+			defer stream.Stop()
+			stream.Watch(babel.BTC_USD, events)
+			next(events)
+
+			exchange.FailNext("OrderBook", errors.New("down"))
+			tick(clock, 5*time.Second)
+
+			So(next(events).Type, ShouldEqual, Stale)
+			event := next(events)
+			So(event.Type, ShouldEqual, Snapshot)
+			So(len(event.Changes), ShouldEqual, 0)
+		})
+
+		Convey(`Pushed changes should be applied to the snapshot`, func() {
+			streamer := &streaming{Driver: exchange}
+			stream := NewStream(streamer, map[string]interface{}{"clock": clock})
+			defer stream.Stop()
+			So(stream.Watch(babel.BTC_USD, events), ShouldBeNil)
+			So(next(events).Type, ShouldEqual, Snapshot)
+
+			streamer.deltas <- babel.BookDelta{Pair: babel.BTC_USD, Type: babel.Buy, Price: 100, Amount: 2, Sequence: 1}
+			event := next(events)
+			So(event.Type, ShouldEqual, Update)
+			So(event.Book.Bids[0], ShouldResemble, babel.Level{100, 2})
+
+			Convey(`And a missed sequence number should resync`, func() {
+				streamer.deltas <- babel.BookDelta{Pair: babel.BTC_USD, Type: babel.Buy, Price: 100, Amount: 3, Sequence: 3}
+				event := next(events)
+				So(event.Type, ShouldEqual, Snapshot)
+				So(event.Book.Bids, ShouldResemble, []babel.Level{{99, 1}})
+			})
+
+			Convey(`And changes older than a snapshot should be dropped`, func() {
+				streamer.sequence = 10
+				streamer.deltas <- babel.BookDelta{Pair: babel.BTC_USD, Type: babel.Buy, Price: 100, Amount: 3, Sequence: 3}
+				So(next(events).Type, ShouldEqual, Snapshot)
+
+				streamer.deltas <- babel.BookDelta{Pair: babel.BTC_USD, Type: babel.Buy, Price: 100, Amount: 4, Sequence: 9}
+				streamer.deltas <- babel.BookDelta{Pair: babel.BTC_USD, Type: babel.Buy, Price: 100, Amount: 5, Sequence: 11}
+				event := next(events)
+				So(event.Type, ShouldEqual, Update)
+				So(event.Changes[0].Sequence, ShouldEqual, 11)
+				So(event.Book.Bids[0], ShouldResemble, babel.Level{Price: 100, Amount: 5})
+			})
+
+			Convey(`And books without changes should go stale`, func() {
+				tick(clock, 2*time.Minute)
+				So(next(events).Type, ShouldEqual, Stale)
+				So(next(events).Type, ShouldEqual, Snapshot)
+			})
+		})
+	})
+}
@@ -0,0 +1,189 @@
+/*
+A view of the balances held across several exchanges.
+
+Balances are taken as the funds available to trade. Accounts that hold
+back funds for their open orders implement core.ReservedFunds, and those
+funds are added as locked, otherwise the balance is taken as the whole
+holding. All holdings are valued in a quote currency with a
+convert.Converter.
+*/
+package portfolio
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/lox/babelcoin/convert"
+	b "github.com/lox/babelcoin/core"
+)
+
+// the balance of a symbol on an exchange, valued in the quote currency.
+// allocation is the fraction of the total portfolio value
+type Holding struct {
+	Exchange          string
+	Symbol            b.Symbol
+	Available, Locked float64
+	Total             float64
+	Value             float64
+	Priced            bool
+	Allocation        float64
+}
+
+type Portfolio struct {
+	Quote     b.Symbol
+	Holdings  []Holding
+	Exchanges map[string]float64
+	Symbols   map[b.Symbol]float64
+	Total     float64
+	Time      time.Time
+}
+
+// builds a portfolio from the accounts of exchanges keyed by name, valued
+// in quote with the converter. accepts a clock as config
+func Build(exchanges map[string]b.Exchange, converter *convert.Converter, quote b.Symbol, config map[string]interface{}) (*Portfolio, error) {
+	p := &Portfolio{
+		Quote:     quote,
+		Exchanges: map[string]float64{},
+		Symbols:   map[b.Symbol]float64{},
+		Time:      b.ConfigClock(config).Now(),
+	}
+
+	names := []string{}
+	for name, _ := range exchanges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		available, err := exchanges[name].Account().Balance([]b.Symbol{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch balances for %s: %v", name, err)
+		}
+
+		locked, err := lockedFunds(exchanges[name].Account())
+		if err != nil {
+			log.Printf("Failed to fetch reserved funds for %s, locked funds are excluded: %v", name, err)
+		}
+
+		symbols := []b.Symbol{}
+		for symbol, _ := range available {
+			symbols = append(symbols, symbol)
+		}
+		for symbol, _ := range locked {
+			if _, ok := available[symbol]; !ok {
+				symbols = append(symbols, symbol)
+			}
+		}
+		sort.Sort(bySymbol(symbols))
+
+		for _, symbol := range symbols {
+			h := Holding{
+				Exchange:  name,
+				Symbol:    symbol,
+				Available: available[symbol],
+				Locked:    locked[symbol],
+			}
+			h.Total = h.Available + h.Locked
+			if h.Total == 0 {
+				continue
+			}
+
+			if value, err := converter.Convert(h.Total, symbol, quote); err == nil {
+				h.Value, h.Priced = value, true
+			} else {
+				log.Printf("Unable to value %s on %s: %v", symbol, name, err)
+			}
+
+			p.Holdings = append(p.Holdings, h)
+			p.Exchanges[name] += h.Value
+			p.Symbols[symbol] += h.Value
+			p.Total += h.Value
+		}
+	}
+
+	for i := range p.Holdings {
+		if p.Total > 0 {
+			p.Holdings[i].Allocation = p.Holdings[i].Value / p.Total
+		}
+	}
+
+	return p, nil
+}
+
+// returns the allocation of the portfolio to an exchange
+func (p *Portfolio) ExchangeAllocation(exchange string) float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Exchanges[exchange] / p.Total
+}
+
+// returns the allocation of the portfolio to a symbol across all exchanges
+func (p *Portfolio) SymbolAllocation(symbol b.Symbol) float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Symbols[symbol] / p.Total
+}
+
+func (p *Portfolio) String() string {
+	var buf bytes.Buffer
+	exchange := ""
+
+	for _, h := range p.Holdings {
+		if h.Exchange != exchange {
+			exchange = h.Exchange
+			fmt.Fprintf(&buf, "%s %.2f %s (%.2f%%)\n", exchange, p.Exchanges[exchange], p.Quote, p.ExchangeAllocation(exchange)*100)
+		}
+
+		value := "unpriced"
+		if h.Priced {
+			value = fmt.Sprintf("%.2f %s (%.2f%%)", h.Value, p.Quote, h.Allocation*100)
+		}
+		fmt.Fprintf(&buf, "  %-6s %14.8f available %14.8f locked = %s\n", h.Symbol, h.Available, h.Locked, value)
+	}
+
+	fmt.Fprintf(&buf, "Total %.2f %s\n", p.Total, p.Quote)
+
+	symbols := []b.Symbol{}
+	for symbol, _ := range p.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Sort(bySymbol(symbols))
+	for _, symbol := range symbols {
+		fmt.Fprintf(&buf, "  %-6s %.2f %s (%.2f%%)\n", symbol, p.Symbols[symbol], p.Quote, p.SymbolAllocation(symbol)*100)
+	}
+
+	return buf.String()
+}
+
+// returns the funds an account holds back from its balances for open
+// orders, none if its balances include them. some drivers panic for
+// unimplemented methods, which is returned as an error
+func lockedFunds(account b.ExchangeAccount) (locked map[b.Symbol]float64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			locked, err = map[b.Symbol]float64{}, fmt.Errorf("%v", r)
+		}
+	}()
+
+	reserved, ok := account.(b.ReservedFunds)
+	if !ok {
+		return map[b.Symbol]float64{}, nil
+	}
+
+	locked, err = reserved.Reserved()
+	if err != nil || locked == nil {
+		return map[b.Symbol]float64{}, err
+	}
+	return locked, nil
+}
+
+type bySymbol []b.Symbol
+
+func (s bySymbol) Len() int           { return len(s) }
+func (s bySymbol) Less(i, j int) bool { return s[i] < s[j] }
+func (s bySymbol) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
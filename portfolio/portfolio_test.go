@@ -0,0 +1,88 @@
+package portfolio
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lox/babelcoin/convert"
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/dryrun"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPortfolioSpec(t *testing.T) {
+	Convey("Subject: Portfolio", t, func() {
+		btce := fake.New("btce", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 100, babel.BTC: 1},
+			"market_data": []babel.MarketData{
+				{Pair: babel.BTC_USD, Buy: 100, Sell: 101, Last: 100},
+				{Pair: babel.LTC_BTC, Buy: 0.02, Sell: 0.021, Last: 0.02},
+			},
+		}).(*fake.Driver)
+		cryptsy := fake.New("cryptsy", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.LTC: 50, babel.AUD: 10},
+		}).(*fake.Driver)
+
+		exchanges := map[string]babel.Exchange{"btce": btce, "cryptsy": cryptsy}
+		converter := convert.NewConverter(exchanges, map[string]interface{}{})
+
+		Convey(`Balances should be valued in the quote currency`, func() {
+			p, err := Build(exchanges, converter, babel.USD, map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(p.Exchanges["btce"], ShouldAlmostEqual, 200)
+			So(p.Exchanges["cryptsy"], ShouldAlmostEqual, 100)
+			So(p.Total, ShouldAlmostEqual, 300)
+			So(p.ExchangeAllocation("btce"), ShouldAlmostEqual, 2/3.0)
+			So(p.SymbolAllocation(babel.LTC), ShouldAlmostEqual, 1/3.0)
+		})
+
+		Convey(`Holdings without a rate should be listed but unpriced`, func() {
+			p, _ := Build(exchanges, converter, babel.USD, map[string]interface{}{})
+			for _, h := range p.Holdings {
+				if h.Symbol == babel.AUD {
+					So(h.Priced, ShouldBeFalse)
+					So(h.Total, ShouldEqual, 10)
+				}
+			}
+			So(len(p.Holdings), ShouldEqual, 4)
+		})
+
+		Convey(`Funds held back for open orders should be locked`, func() {
+			_, err := btce.Trade(babel.Buy, babel.BTC_USD, 0.5, 90)
+			So(err, ShouldBeNil)
+
+			p, _ := Build(exchanges, converter, babel.USD, map[string]interface{}{})
+			for _, h := range p.Holdings {
+				if h.Exchange == "btce" && h.Symbol == babel.USD {
+					So(h.Available, ShouldEqual, 55)
+					So(h.Locked, ShouldEqual, 45)
+					So(h.Total, ShouldEqual, 100)
+				}
+			}
+			So(p.Exchanges["btce"], ShouldAlmostEqual, 200)
+		})
+
+		Convey(`Orders on accounts that don't hold funds back shouldn't be counted twice`, func() {
+			dryRun := dryrun.Wrap("dryrun:btce", btce, map[string]interface{}{})
+			_, err := dryRun.Trade(babel.Buy, babel.BTC_USD, 0.5, 90)
+			So(err, ShouldBeNil)
+
+			p, _ := Build(map[string]babel.Exchange{"btce": dryRun}, converter, babel.USD, map[string]interface{}{})
+			So(p.Total, ShouldAlmostEqual, 200)
+		})
+
+		Convey(`The portfolio should be timed by the clock`, func() {
+			clock := babel.NewFakeClock(time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC))
+			p, _ := Build(exchanges, converter, babel.USD, map[string]interface{}{"clock": clock})
+			So(p.Time, ShouldResemble, clock.Now())
+		})
+
+		Convey(`Failing to fetch balances should fail`, func() {
+			btce.FailNext("Balance", errors.New("down"))
+			_, err := Build(exchanges, converter, babel.USD, map[string]interface{}{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,334 @@
+/*
+Recording of live exchange feeds to disk, and replay of the recordings.
+
+Events are appended as lines of json to segments under
+<dir>/<exchange>/<yyyy-mm-ddThh-mm-ss>.json, named for the start of the
+period they cover, and a new segment is started each rotate interval.
+A start event is written each time a recorder starts, so consumers can
+tell where a recording may have a gap. On restart, trades already in the
+recording aren't recorded again.
+*/
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+	util "github.com/lox/babelcoin/util"
+)
+
+const segmentFormat = "2006-01-02T15-04-05"
+
+type EventType string
+
+const (
+	StartEvent      EventType = "start"
+	MarketDataEvent EventType = "marketdata"
+	TradeEvent      EventType = "trade"
+	OrderBookEvent  EventType = "orderbook"
+)
+
+// a recorded event, only the field for the event type is set
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	Pair       b.Pair
+	MarketData *b.MarketData `json:",omitempty"`
+	Trade      *b.Trade      `json:",omitempty"`
+	OrderBook  *b.OrderBook  `json:",omitempty"`
+}
+
+type Recorder struct {
+	dir      string
+	name     string
+	exchange b.Exchange
+	pairs    []b.Pair
+	config   map[string]interface{}
+	rotate   time.Duration
+	clock    b.Clock
+	mutex    sync.Mutex
+	file     *os.File
+	period   time.Time
+	seen     map[string]time.Time
+	pruneAt  int
+	stop     chan bool
+}
+
+// creates a recorder of pairs on an exchange, the name is used for the
+// directory that the recording is written to. accepts rotate,
+// trade_interval, book_interval durations, a book_depth int and a clock as
+// config, order books are only recorded if book_interval is set and the
+// exchange's clock is used if there's no clock
+func NewRecorder(dir string, name string, exchange b.Exchange, pairs []b.Pair, config map[string]interface{}) *Recorder {
+	r := &Recorder{
+		dir:      dir,
+		name:     name,
+		exchange: exchange,
+		pairs:    pairs,
+		config:   config,
+		rotate:   time.Hour,
+		seen:     map[string]time.Time{},
+		pruneAt:  100000,
+		stop:     make(chan bool),
+	}
+
+	if d, ok := config["rotate"].(time.Duration); ok {
+		r.rotate = d
+	}
+
+	if clock, ok := config["clock"].(b.Clock); ok {
+		r.clock = clock
+	} else {
+		r.clock = b.ExchangeClock(exchange)
+	}
+
+	return r
+}
+
+// records the exchange feeds until Stop
+func (r *Recorder) Run() error {
+	if err := r.restore(); err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := r.Record(Event{Type: StartEvent, Time: r.clock.Now()}); err != nil {
+		return err
+	}
+
+	marketData := make(chan b.MarketData, 100)
+	for _, pair := range r.pairs {
+		if err := r.exchange.Ticker(pair, marketData); err != nil {
+			return err
+		}
+		defer b.StopTicker(r.exchange, pair, marketData)
+	}
+
+	done := make(chan bool)
+	defer close(done)
+
+	trades := make(chan b.Trade, 5000)
+	if err := util.HistoryPoller(r.clock, r.exchange, r.pairs, r.duration("trade_interval", 30*time.Second), trades, done); err != nil {
+		return err
+	}
+
+	var books <-chan time.Time
+	if interval := r.duration("book_interval", 0); interval > 0 {
+		bookTicker := r.clock.NewTicker(interval)
+		defer bookTicker.Stop()
+		books = bookTicker.C()
+	}
+
+	depth, _ := r.config["book_depth"].(int)
+
+	for {
+		var err error
+
+		select {
+		case data := <-marketData:
+			err = r.Record(Event{Type: MarketDataEvent, Time: r.clock.Now(), Pair: data.Pair, MarketData: &data})
+		case trade := <-trades:
+			if r.isNew(trade) {
+				err = r.Record(Event{Type: TradeEvent, Time: r.clock.Now(), Pair: trade.Pair, Trade: &trade})
+			}
+		case <-books:
+			for _, pair := range r.pairs {
+				book, bookErr := r.exchange.Account().OrderBook(pair, depth)
+				if bookErr != nil {
+					log.Printf("Failed to fetch order book for %s: %v", pair.String(), bookErr)
+					continue
+				}
+				if err = r.Record(Event{Type: OrderBookEvent, Time: r.clock.Now(), Pair: pair, OrderBook: &book}); err != nil {
+					break
+				}
+			}
+		case <-r.stop:
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stops a running recorder
+func (r *Recorder) Stop() {
+	r.stop <- true
+}
+
+// appends an event to the segment for it's time, rotating segments as needed
+func (r *Recorder) Record(event Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	period := event.Time.UTC().Truncate(r.rotate)
+	if r.file == nil || !period.Equal(r.period) {
+		if err := r.open(period); err != nil {
+			return err
+		}
+	}
+
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(append(bytes, '\n'))
+	return err
+}
+
+// closes the current segment
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// opens the segment for a period for appending
+func (r *Recorder) open(period time.Time) error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	path := filepath.Join(r.dir, r.name, period.Format(segmentFormat)+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	// a crash can leave a partial line, which replay skips
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte{'\n'})
+		}
+	}
+
+	r.file, r.period = f, period
+	return nil
+}
+
+// loads the trades recorded in the last few days, so that the poller
+// re-fetching history after a restart doesn't record duplicates
+func (r *Recorder) restore() error {
+	files, err := segments(r.dir, r.name)
+	if err != nil {
+		return err
+	}
+
+	// the history poller first fetches the last 3 days
+	cutoff := r.clock.Now().AddDate(0, 0, -3).Add(-r.rotate)
+
+	for i := len(files) - 1; i >= 0; i-- {
+		start, err := segmentStart(files[i])
+		if err == nil && start.Before(cutoff) {
+			break
+		}
+
+		err = readSegment(files[i], func(event Event) error {
+			if event.Type == TradeEvent && event.Trade != nil {
+				r.isNew(*event.Trade)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns true if a trade hasn't been recorded. the poller re-fetches up
+// to 3 days of history, so keys are kept for longer than that
+func (r *Recorder) isNew(trade b.Trade) bool {
+	key := trade.Key()
+	if _, ok := r.seen[key]; ok {
+		return false
+	}
+	r.seen[key] = trade.Timestamp
+
+	if len(r.seen) > r.pruneAt {
+		cutoff := r.clock.Now().AddDate(0, 0, -4)
+		for id, timestamp := range r.seen {
+			if timestamp.Before(cutoff) {
+				delete(r.seen, id)
+			}
+		}
+		r.pruneAt = 2 * len(r.seen)
+		if r.pruneAt < 100000 {
+			r.pruneAt = 100000
+		}
+	}
+	return true
+}
+
+func (r *Recorder) duration(key string, def time.Duration) time.Duration {
+	if d, ok := r.config[key].(time.Duration); ok {
+		return d
+	}
+	return def
+}
+
+// returns the segment files of a recording in time order
+func segments(dir string, name string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, name, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func segmentStart(path string) (time.Time, error) {
+	base := filepath.Base(path)
+	return time.Parse(segmentFormat, base[:len(base)-len(filepath.Ext(base))])
+}
+
+// calls f with each event in a segment, corrupt lines are skipped
+func readSegment(path string, f func(event Event) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 1 {
+			var event Event
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil {
+				log.Printf("Skipping corrupt event in %s: %v", path, jsonErr)
+			} else if fErr := f(event); fErr != nil {
+				return fErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package recorder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func replay(dir string, from time.Time, to time.Time) []Event {
+	channel := make(chan Event, 100)
+	So(Replay(dir, "fake", from, to, channel), ShouldBeNil)
+
+	events := []Event{}
+	for event := range channel {
+		events = append(events, event)
+	}
+	return events
+}
+
+func count(events []Event, t EventType) int {
+	n := 0
+	for _, event := range events {
+		if event.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRecorderSpec(t *testing.T) {
+	Convey("Subject: Feed Recorder", t, func() {
+		dir, _ := ioutil.TempDir("", "recorder")
+		defer os.RemoveAll(dir)
+
+		start := time.Date(2014, 3, 1, 10, 30, 0, 0, time.UTC)
+		data := babel.MarketData{Pair: babel.BTC_USD, Last: 100}
+
+		Convey(`Segments should rotate and replay in order`, func() {
+			r := NewRecorder(dir, "fake", nil, nil, map[string]interface{}{"rotate": time.Hour})
+			for i := 0; i < 4; i++ {
+				data.Last = float64(100 + i)
+				So(r.Record(Event{Type: MarketDataEvent, Time: start.Add(time.Duration(i) * 30 * time.Minute), MarketData: &data}), ShouldBeNil)
+			}
+			So(r.Close(), ShouldBeNil)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "fake", "*.json"))
+			So(len(files), ShouldEqual, 3)
+
+			events := replay(dir, start.Add(30*time.Minute), start.Add(2*time.Hour))
+			So(len(events), ShouldEqual, 3)
+			So(events[0].MarketData.Last, ShouldEqual, 101)
+			So(events[2].MarketData.Last, ShouldEqual, 103)
+		})
+
+		Convey(`Partial lines from a crash should be skipped`, func() {
+			r := NewRecorder(dir, "fake", nil, nil, map[string]interface{}{})
+			So(r.Record(Event{Type: MarketDataEvent, Time: start, MarketData: &data}), ShouldBeNil)
+			r.file.Write([]byte(`{"Type":"marke`))
+			r.Close()
+
+			r = NewRecorder(dir, "fake", nil, nil, map[string]interface{}{})
+			So(r.Record(Event{Type: MarketDataEvent, Time: start.Add(time.Minute), MarketData: &data}), ShouldBeNil)
+			r.Close()
+
+			So(len(replay(dir, start, start.Add(time.Hour))), ShouldEqual, 2)
+		})
+
+		Convey(`Feeds should be recorded without duplicate trades across restarts`, func() {
+			now := time.Now()
+			exchange := fake.New("fake", map[string]interface{}{
+				"market_data": []babel.MarketData{data},
+			}).(*fake.Driver)
+			exchange.SetOrderBook(babel.BTC_USD, babel.OrderBook{})
+			exchange.AddTrades(
+				babel.Trade{Id: "1", Pair: babel.BTC_USD, Amount: 1, Rate: 100, Timestamp: now.Add(-time.Minute)},
+				babel.Trade{Id: "2", Pair: babel.BTC_USD, Amount: 1, Rate: 101, Timestamp: now.Add(-time.Second)},
+				babel.Trade{Pair: babel.BTC_USD, Amount: 2, Rate: 102, Timestamp: now.Add(-time.Second)},
+				babel.Trade{Pair: babel.BTC_USD, Amount: 3, Rate: 103, Timestamp: now.Add(-time.Second)},
+			)
+
+			config := map[string]interface{}{
+				"trade_interval": 5 * time.Millisecond,
+				"book_interval":  5 * time.Millisecond,
+			}
+
+			record := func() {
+				r := NewRecorder(dir, "fake", exchange, []babel.Pair{babel.BTC_USD}, config)
+				done := make(chan error)
+				go func() { done <- r.Run() }()
+				time.Sleep(50 * time.Millisecond)
+				r.Stop()
+				So(<-done, ShouldBeNil)
+			}
+
+			record()
+			record()
+
+			events := replay(dir, now.Add(-time.Hour), time.Now())
+			So(count(events, StartEvent), ShouldEqual, 2)
+			So(count(events, MarketDataEvent), ShouldEqual, 2)
+			So(count(events, TradeEvent), ShouldEqual, 4)
+			So(count(events, OrderBookEvent), ShouldBeGreaterThan, 0)
+
+			trades := make(chan babel.Trade, 10)
+			So(Trades(dir, "fake", []babel.Pair{babel.BTC_USD}, now.Add(-time.Hour), time.Now(), trades), ShouldBeNil)
+			So((<-trades).Id, ShouldEqual, "1")
+			So((<-trades).Id, ShouldEqual, "2")
+
+			// a ticker left running would block once its buffer is full
+			sent := make(chan bool)
+			go func() {
+				for i := 0; i < 200; i++ {
+					exchange.SetMarketData(data)
+				}
+				sent <- true
+			}()
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				So("tickers still running", ShouldBeNil)
+			}
+		})
+
+		Convey(`Trades should replay in timestamp order by trade time`, func() {
+			r := NewRecorder(dir, "fake", nil, nil, map[string]interface{}{})
+			polled := start.Add(time.Hour + 5*time.Minute)
+			for _, minutes := range []int{70, 50, 30, 10} {
+				trade := babel.Trade{Id: strconv.Itoa(minutes), Pair: babel.BTC_USD, Amount: 1, Rate: 100,
+					Timestamp: start.Add(time.Duration(minutes) * time.Minute)}
+				So(r.Record(Event{Type: TradeEvent, Time: polled, Pair: trade.Pair, Trade: &trade}), ShouldBeNil)
+			}
+			r.Close()
+
+			trades := make(chan babel.Trade, 10)
+			So(Trades(dir, "fake", []babel.Pair{babel.BTC_USD}, start.Add(20*time.Minute), start.Add(time.Hour), trades), ShouldBeNil)
+
+			ids := []string{}
+			for trade := range trades {
+				ids = append(ids, trade.Id)
+			}
+			So(ids, ShouldResemble, []string{"30", "50"})
+		})
+	})
+}
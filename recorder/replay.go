@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"sort"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// writes the events in a recording between from and to into the channel
+// in the order they were recorded, the channel is closed when done
+func Replay(dir string, name string, from time.Time, to time.Time, events chan<- Event) error {
+	defer close(events)
+
+	files, err := segments(dir, name)
+	if err != nil {
+		return err
+	}
+
+	for i, path := range files {
+		// skip segments that end before from
+		if i+1 < len(files) {
+			if next, err := segmentStart(files[i+1]); err == nil && !next.After(from) {
+				continue
+			}
+		}
+		if start, err := segmentStart(path); err == nil && !start.Before(to) {
+			break
+		}
+
+		err = readSegment(path, func(event Event) error {
+			if !event.Time.Before(from) && event.Time.Before(to) {
+				events <- event
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trades are recorded when they're polled, which is after they happen, so
+// events this long after the end of a range are read for its late trades
+const lateTrades = time.Hour
+
+// writes the recorded trades for pairs between from and to into the
+// channel in timestamp order, e.g to drive the backtester. exchanges return
+// history in different orders so the trades are sorted. the channel is
+// closed when done
+func Trades(dir string, name string, pairs []b.Pair, from time.Time, to time.Time, trades chan<- b.Trade) error {
+	defer close(trades)
+
+	events := make(chan Event, 100)
+	result := make(chan error, 1)
+	go func() {
+		result <- Replay(dir, name, from, to.Add(lateTrades), events)
+	}()
+
+	recorded := []*b.Trade{}
+	for event := range events {
+		if event.Type != TradeEvent || event.Trade == nil || !b.ContainsPair(event.Pair, pairs) {
+			continue
+		}
+		if !event.Trade.Timestamp.Before(from) && event.Trade.Timestamp.Before(to) {
+			recorded = append(recorded, event.Trade)
+		}
+	}
+
+	if err := <-result; err != nil {
+		return err
+	}
+
+	sort.Stable(b.TradeSorter{Trades: recorded, By: func(t1, t2 *b.Trade) bool {
+		return t1.Timestamp.Before(t2.Timestamp)
+	}})
+
+	for _, trade := range recorded {
+		trades <- *trade
+	}
+	return nil
+}
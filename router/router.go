@@ -0,0 +1,379 @@
+/*
+Routing of an order across several exchanges at the lowest total cost.
+
+The Router fetches the order book of a pair on each venue, and walks the
+levels of all of them together from the best price after each venue's
+fee, so an order is split across venues in whatever way costs the least.
+The amount allocated to a venue is limited by its balance. The children
+are placed concurrently as limit orders at the worst level used on each
+venue, and the fills the account reports for them are added up. Children
+that don't fill when they're placed are left resting on their venue.
+*/
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// amounts smaller than this are treated as zero
+const epsilon = 1e-9
+
+// an exchange that orders are routed to
+type Venue struct {
+	Name     string
+	Exchange b.Exchange
+}
+
+// the part of an order routed to a venue. the price is the average over
+// the levels used and the limit is the worst of them, cost is in the
+// counter currency after fees
+type Allocation struct {
+	Venue  string
+	Amount float64
+	Price  float64
+	Limit  float64
+	Cost   float64
+}
+
+// how an order is split across venues, unfilled is the amount that the
+// books and balances couldn't take
+type Plan struct {
+	Pair        b.Pair
+	Type        b.TradeType
+	Amount      float64
+	Allocations []Allocation
+	Cost        float64
+	Price       float64
+	Unfilled    float64
+}
+
+// an order placed for an allocation, with the amount that filled when it
+// was placed and the average price of those fills
+type Child struct {
+	Allocation Allocation
+	Order      b.Order
+	Filled     float64
+	Price      float64
+	Err        error
+}
+
+// the result of routing an order. filled is the amount of the children
+// that filled when they were placed, and the price is the average of their
+// fills. what didn't fill is left resting, and isn't followed up
+type Result struct {
+	Plan     Plan
+	Children []Child
+	Filled   float64
+	Price    float64
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%s %.8f %s filled %.8f @ %.8f across %d venues",
+		r.Plan.Type, r.Plan.Amount, r.Plan.Pair.String(), r.Filled, r.Price, len(r.Children))
+}
+
+type Router struct {
+	venues     []Venue
+	config     map[string]interface{}
+	fees       map[string]float64
+	defaultFee float64
+	depth      int
+}
+
+// a price level on a venue
+type level struct {
+	venue     string
+	price     float64
+	effective float64
+	amount    float64
+}
+
+// creates a router across venues. accepts fees as a map of venue name to
+// fee, a default fee and a book depth int as config
+func NewRouter(venues []Venue, config map[string]interface{}) *Router {
+	r := &Router{
+		venues:     venues,
+		config:     config,
+		fees:       map[string]float64{},
+		defaultFee: 0.002,
+		depth:      50,
+	}
+
+	if fees, ok := config["fees"].(map[string]float64); ok {
+		r.fees = fees
+	}
+	if fee, ok := config["fee"].(float64); ok {
+		r.defaultFee = fee
+	}
+	if depth, ok := config["depth"].(int); ok {
+		r.depth = depth
+	}
+	return r
+}
+
+// splits an order across venues at the lowest cost, a limit of -1 uses any
+// price in the books
+func (r *Router) Plan(t b.TradeType, pair b.Pair, amount float64, limit float64) (Plan, error) {
+	if t != b.Buy && t != b.Sell {
+		return Plan{}, errors.New("Unknown trade type " + string(t))
+	}
+	if amount <= 0 {
+		return Plan{}, errors.New("Orders need a positive amount")
+	}
+
+	levels, capacity, err := r.levels(t, pair, limit)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{Pair: pair, Type: t, Amount: amount}
+	allocations := map[string]*Allocation{}
+	remains := amount
+
+	for _, l := range levels {
+		if remains <= epsilon {
+			break
+		}
+
+		// capacity is in the counter for buys, which pay the fee on top of
+		// the price, and the base for sells
+		take := math.Min(remains, l.amount)
+		if t == b.Buy {
+			take = math.Min(take, capacity[l.venue]/l.effective)
+		} else {
+			take = math.Min(take, capacity[l.venue])
+		}
+		if take <= epsilon {
+			continue
+		}
+
+		if t == b.Buy {
+			capacity[l.venue] -= take * l.effective
+		} else {
+			capacity[l.venue] -= take
+		}
+
+		a, ok := allocations[l.venue]
+		if !ok {
+			a = &Allocation{Venue: l.venue}
+			allocations[l.venue] = a
+		}
+		a.Amount += take
+		a.Price += take * l.price
+		a.Cost += take * l.effective
+		a.Limit = l.price
+		remains -= take
+	}
+
+	for _, venue := range r.venues {
+		if a, ok := allocations[venue.Name]; ok {
+			a.Price /= a.Amount
+			plan.Allocations = append(plan.Allocations, *a)
+			plan.Cost += a.Cost
+		}
+	}
+
+	plan.Unfilled = math.Max(0, remains)
+	if filled := amount - plan.Unfilled; filled > epsilon {
+		plan.Price = plan.Cost / filled
+	}
+	return plan, nil
+}
+
+// plans an order and places the children on each venue concurrently, the
+// children that don't fill straight away are left resting
+func (r *Router) Route(t b.TradeType, pair b.Pair, amount float64, limit float64) (Result, error) {
+	plan, err := r.Plan(t, pair, amount, limit)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(plan.Allocations) == 0 {
+		return Result{Plan: plan}, errors.New("No liquidity to route " + pair.String())
+	}
+
+	result := Result{Plan: plan, Children: make([]Child, len(plan.Allocations))}
+	var wg sync.WaitGroup
+
+	for i, a := range plan.Allocations {
+		wg.Add(1)
+		go func(i int, a Allocation) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					result.Children[i] = Child{Allocation: a, Err: fmt.Errorf("%v", r)}
+				}
+			}()
+
+			account := r.venue(a.Venue).Account()
+			order, err := account.Trade(t, pair, a.Amount, a.Limit)
+			if err != nil {
+				log.Printf("Failed to place %s %.8f %s on %s: %v", t, a.Amount, pair.String(), a.Venue, err)
+				result.Children[i] = Child{Allocation: a, Order: order, Err: err}
+				return
+			}
+
+			filled, price := fills(account, order, a)
+			result.Children[i] = Child{Allocation: a, Order: order, Filled: filled, Price: price}
+		}(i, a)
+	}
+	wg.Wait()
+
+	cost := 0.0
+	failed := 0
+	for _, child := range result.Children {
+		if child.Err != nil {
+			failed++
+			continue
+		}
+		result.Filled += child.Filled
+		cost += child.Filled * child.Price
+	}
+	if result.Filled > epsilon {
+		result.Price = cost / result.Filled
+	}
+
+	if failed > 0 {
+		return result, fmt.Errorf("%d of %d child orders failed", failed, len(result.Children))
+	}
+	return result, nil
+}
+
+// returns the amount of a child that filled when it was placed and the
+// average price of the fills, from the account's fills where it keeps them.
+// the planned price is used when it doesn't
+func fills(account b.ExchangeAccount, order b.Order, a Allocation) (float64, float64) {
+	placed := order
+	placed.Remains = order.Amount
+	updates, err := b.SyncOrders(account, map[string]b.Order{order.Id: placed}, []b.Order{order})
+	if err != nil {
+		log.Printf("Failed to read the fills of order %s on %s: %v", order.Id, a.Venue, err)
+		return order.Amount - order.Remains, a.Price
+	}
+
+	for _, update := range updates {
+		if update.Filled > 0 {
+			if _, ok := account.(b.OrderFills); !ok {
+				return update.Filled, a.Price
+			}
+			return update.Filled, update.Rate
+		}
+	}
+	return 0, 0
+}
+
+// returns the levels of all venues that an order could fill against, best
+// first after fees, and what the balance on each venue allows
+func (r *Router) levels(t b.TradeType, pair b.Pair, limit float64) ([]level, map[string]float64, error) {
+	type result struct {
+		venue   Venue
+		book    b.OrderBook
+		balance float64
+		err     error
+	}
+
+	results := make(chan result, len(r.venues))
+	for _, venue := range r.venues {
+		go func(venue Venue) {
+			// drivers without order books or keys may panic, which skips
+			// the venue
+			defer func() {
+				if r := recover(); r != nil {
+					results <- result{venue: venue, err: fmt.Errorf("%v", r)}
+				}
+			}()
+
+			book, err := venue.Exchange.Account().OrderBook(pair, r.depth)
+			if err != nil {
+				results <- result{venue, book, 0, err}
+				return
+			}
+
+			symbol := pair.Base
+			if t == b.Buy {
+				symbol = pair.Counter
+			}
+			balances, err := venue.Exchange.Account().Balance([]b.Symbol{symbol})
+			results <- result{venue, book, balances[symbol], err}
+		}(venue)
+	}
+
+	levels := []level{}
+	capacity := map[string]float64{}
+	for _ = range r.venues {
+		res := <-results
+		if res.err != nil {
+			log.Printf("Skipping %s: %v", res.venue.Name, res.err)
+			continue
+		}
+
+		fee := r.fee(res.venue, pair)
+		capacity[res.venue.Name] = res.balance
+
+		side := res.book.Bids
+		if t == b.Buy {
+			side = res.book.Asks
+		}
+		for _, l := range side {
+			if limit != -1 && ((t == b.Buy && l.Price > limit) || (t == b.Sell && l.Price < limit)) {
+				continue
+			}
+
+			effective := l.Price * (1 - fee)
+			if t == b.Buy {
+				effective = l.Price * (1 + fee)
+			}
+			levels = append(levels, level{res.venue.Name, l.Price, effective, l.Amount})
+		}
+	}
+
+	if len(capacity) == 0 {
+		return nil, nil, errors.New("No venues returned an order book for " + pair.String())
+	}
+
+	sort.Sort(byEffective{levels, t == b.Sell})
+	return levels, capacity, nil
+}
+
+// returns the fee for a venue, from config first and then the venue
+func (r *Router) fee(venue Venue, pair b.Pair) float64 {
+	if fee, ok := r.fees[venue.Name]; ok {
+		return fee
+	}
+	if schedule, ok := venue.Exchange.(b.FeeSchedule); ok {
+		if fee, err := schedule.Fee(pair); err == nil {
+			return fee
+		}
+	}
+	return r.defaultFee
+}
+
+func (r *Router) venue(name string) b.Exchange {
+	for _, venue := range r.venues {
+		if venue.Name == name {
+			return venue.Exchange
+		}
+	}
+	return nil
+}
+
+// sorts levels by price after fees, best first
+type byEffective struct {
+	levels     []level
+	descending bool
+}
+
+func (l byEffective) Len() int      { return len(l.levels) }
+func (l byEffective) Swap(i, j int) { l.levels[i], l.levels[j] = l.levels[j], l.levels[i] }
+func (l byEffective) Less(i, j int) bool {
+	if l.descending {
+		return l.levels[i].effective > l.levels[j].effective
+	}
+	return l.levels[i].effective < l.levels[j].effective
+}
@@ -0,0 +1,157 @@
+package router
+
+import (
+	"errors"
+	"testing"
+
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// a venue whose driver doesn't implement order books
+type noBook struct {
+	*fake.Driver
+}
+
+func (n noBook) Account() babel.ExchangeAccount {
+	return n
+}
+
+func (n noBook) OrderBook(pair babel.Pair, limit int) (babel.OrderBook, error) {
+	panic("Not implemented")
+}
+
+// a venue that shows an older book than the one its orders fill against
+type staleBook struct {
+	*fake.Driver
+	book babel.OrderBook
+}
+
+func (s staleBook) Account() babel.ExchangeAccount {
+	return s
+}
+
+func (s staleBook) OrderBook(pair babel.Pair, limit int) (babel.OrderBook, error) {
+	return s.book, nil
+}
+
+func TestRouterSpec(t *testing.T) {
+	Convey("Subject: Smart Order Router", t, func() {
+		btce := fake.New("btce", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 10000, babel.BTC: 10},
+		}).(*fake.Driver)
+		btce.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{100, 1}, {103, 5}},
+			Bids: []babel.Level{{99, 2}, {95, 5}},
+		})
+
+		bitstamp := fake.New("bitstamp", map[string]interface{}{
+			"balances": map[babel.Symbol]float64{babel.USD: 10000, babel.BTC: 10},
+		}).(*fake.Driver)
+		bitstamp.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+			Asks: []babel.Level{{101, 2}, {102, 5}},
+			Bids: []babel.Level{{98, 2}, {97, 5}},
+		})
+
+		venues := []Venue{{"btce", btce}, {"bitstamp", bitstamp}}
+		router := NewRouter(venues, map[string]interface{}{"fee": 0.0})
+
+		Convey(`Buys should take the cheapest levels across venues`, func() {
+			plan, err := router.Plan(babel.Buy, babel.BTC_USD, 5, -1)
+			So(err, ShouldBeNil)
+			So(len(plan.Allocations), ShouldEqual, 2)
+			So(plan.Allocations[0].Venue, ShouldEqual, "btce")
+			So(plan.Allocations[0].Amount, ShouldEqual, 1)
+			So(plan.Allocations[1].Amount, ShouldEqual, 4)
+			So(plan.Allocations[1].Limit, ShouldEqual, 102)
+			So(plan.Cost, ShouldEqual, 100+202+204)
+			So(plan.Unfilled, ShouldEqual, 0)
+		})
+
+		Convey(`Sells should take the best bids across venues`, func() {
+			plan, _ := router.Plan(babel.Sell, babel.BTC_USD, 5, -1)
+			So(plan.Allocations[0].Amount, ShouldEqual, 2)
+			So(plan.Allocations[1].Amount, ShouldEqual, 3)
+			So(plan.Price, ShouldAlmostEqual, (198+196+97)/5.0)
+		})
+
+		Convey(`Fees should change where orders are routed`, func() {
+			router := NewRouter(venues, map[string]interface{}{
+				"fees": map[string]float64{"btce": 0.05, "bitstamp": 0},
+			})
+			plan, _ := router.Plan(babel.Buy, babel.BTC_USD, 1, -1)
+			So(plan.Allocations[0].Venue, ShouldEqual, "bitstamp")
+		})
+
+		Convey(`Buys should leave room in balances for the fee`, func() {
+			poor := fake.New("poor", map[string]interface{}{
+				"balances": map[babel.Symbol]float64{babel.USD: 101},
+				"fee":      0.01,
+			}).(*fake.Driver)
+			poor.SetOrderBook(babel.BTC_USD, babel.OrderBook{
+				Asks: []babel.Level{{Price: 100, Amount: 5}},
+			})
+
+			router := NewRouter([]Venue{{Name: "poor", Exchange: poor}}, map[string]interface{}{})
+			plan, _ := router.Plan(babel.Buy, babel.BTC_USD, 2, -1)
+			So(plan.Amount-plan.Unfilled, ShouldAlmostEqual, 1)
+			So(plan.Cost, ShouldAlmostEqual, 101)
+		})
+
+		Convey(`Limits and balances should cap what's routed`, func() {
+			btce.SetBalance(babel.USD, 50)
+			plan, _ := router.Plan(babel.Buy, babel.BTC_USD, 5, 101)
+			So(plan.Allocations[0].Venue, ShouldEqual, "btce")
+			So(plan.Allocations[0].Amount, ShouldEqual, 0.5)
+			So(plan.Allocations[1].Amount, ShouldEqual, 2)
+			So(plan.Unfilled, ShouldEqual, 2.5)
+		})
+
+		Convey(`Routing should place the children and report their fills`, func() {
+			result, err := router.Route(babel.Buy, babel.BTC_USD, 5, -1)
+			So(err, ShouldBeNil)
+			So(len(result.Children), ShouldEqual, 2)
+			So(result.Filled, ShouldAlmostEqual, 5)
+			So(result.Price, ShouldAlmostEqual, 506/5.0)
+
+			balances, _ := bitstamp.Balance([]babel.Symbol{babel.BTC})
+			So(balances[babel.BTC], ShouldEqual, 14)
+		})
+
+		Convey(`Fills should be reported at the price they filled at`, func() {
+			stale := staleBook{fake.New("stale", map[string]interface{}{
+				"balances": map[babel.Symbol]float64{babel.USD: 10000},
+			}).(*fake.Driver), babel.OrderBook{Asks: []babel.Level{{Price: 105, Amount: 3}}}}
+			stale.SetOrderBook(babel.BTC_USD, babel.OrderBook{Asks: []babel.Level{{Price: 100, Amount: 1}}})
+
+			router := NewRouter([]Venue{{Name: "stale", Exchange: stale}}, map[string]interface{}{"fee": 0.0})
+			result, err := router.Route(babel.Buy, babel.BTC_USD, 3, -1)
+			So(err, ShouldBeNil)
+			So(result.Filled, ShouldEqual, 1)
+			So(result.Price, ShouldEqual, 100)
+
+			orders, _ := stale.Orders(0)
+			So(len(orders), ShouldEqual, 1)
+			So(orders[0].Remains, ShouldEqual, 2)
+		})
+
+		Convey(`Venues that fail should be skipped`, func() {
+			btce.FailNext("OrderBook", errors.New("down"))
+			plan, err := router.Plan(babel.Buy, babel.BTC_USD, 1, -1)
+			So(err, ShouldBeNil)
+			So(plan.Allocations[0].Venue, ShouldEqual, "bitstamp")
+
+			broken := noBook{fake.New("broken", map[string]interface{}{}).(*fake.Driver)}
+			skipping := NewRouter(append(venues, Venue{Name: "broken", Exchange: broken}), map[string]interface{}{"fee": 0.0})
+			plan, err = skipping.Plan(babel.Buy, babel.BTC_USD, 1, -1)
+			So(err, ShouldBeNil)
+			So(plan.Allocations[0].Venue, ShouldEqual, "btce")
+
+			btce.FailNext("Trade", errors.New("down"))
+			result, err := router.Route(babel.Buy, babel.BTC_USD, 2, -1)
+			So(err, ShouldNotBeNil)
+			So(result.Filled, ShouldAlmostEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,343 @@
+/*
+A file based store of trades, so that history only needs to be
+downloaded once.
+
+Trades are stored in a segment per exchange, pair and day, as lines of
+json under <dir>/<exchange>/<pair>/<yyyy-mm-dd>.json. Inserts are de-duped
+on Trade.Key(), and segments older than the compress_after config
+are gzipped. Late trades for a gzipped segment are appended to a plain
+segment alongside it, which is folded in on the next Compact.
+*/
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+const day = 24 * time.Hour
+
+// the number of segments to keep identities cached for
+const cachedSegments = 64
+
+type Store struct {
+	dir           string
+	compressAfter time.Duration
+	clock         b.Clock
+	mutex         sync.Mutex
+	segments      map[string]map[string]int
+}
+
+// opens a store in a directory, creating it if needed and compressing any
+// segments that have aged
+func Open(dir string, config map[string]interface{}) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:           dir,
+		compressAfter: 48 * time.Hour,
+		clock:         b.ConfigClock(config),
+		segments:      map[string]map[string]int{},
+	}
+
+	if d, ok := config["compress_after"].(time.Duration); ok {
+		s.compressAfter = d
+	}
+
+	return s, s.Compact()
+}
+
+// inserts a trade, returning false if it was already in the store.
+// identical trades without ids are only stored once, Record keeps them
+func (s *Store) Insert(trade b.Trade) (bool, error) {
+	return s.insert(trade, 1)
+}
+
+// inserts the nth occurrence of a trade, unless the store already has n
+func (s *Store) insert(trade b.Trade, occurrence int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if trade.Exchange == "" {
+		return false, fmt.Errorf("Trade %s has no exchange", trade.String())
+	}
+
+	path := s.segmentPath(trade.Exchange, trade.Pair, trade.Timestamp)
+	counts, err := s.segmentCounts(path)
+	if err != nil {
+		return false, err
+	}
+
+	id := trade.Key()
+	if counts[id] >= occurrence {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	bytes, err := json.Marshal(trade)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = f.Write(append(bytes, '\n')); err != nil {
+		return false, err
+	}
+
+	counts[id]++
+	return true, nil
+}
+
+// inserts trades from a channel until it's closed, e.g from a HistoryPoller.
+// trades without an exchange are given the provided exchange. sources
+// without ids can have identical trades in the same second, so repeats of
+// a trade within a run of the same timestamp are kept as separate trades
+func (s *Store) Record(exchange string, trades <-chan b.Trade) (int, error) {
+	inserted := 0
+	var last time.Time
+	occurrences := map[string]int{}
+
+	for trade := range trades {
+		if trade.Exchange == "" {
+			trade.Exchange = exchange
+		}
+		if !trade.Timestamp.Equal(last) {
+			last = trade.Timestamp
+			occurrences = map[string]int{}
+		}
+		occurrences[trade.Key()]++
+
+		ok, err := s.insert(trade, occurrences[trade.Key()])
+		if err != nil {
+			return inserted, err
+		} else if ok {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// writes trades for an exchange and pairs between from and to into the
+// channel in timestamp order, the channel is closed when done
+func (s *Store) Query(exchange string, pairs []b.Pair, from time.Time, to time.Time, channel chan<- b.Trade) error {
+	defer close(channel)
+
+	for d := from.UTC().Truncate(day); d.Before(to); d = d.Add(day) {
+		trades := []*b.Trade{}
+
+		for _, pair := range pairs {
+			segment, err := s.read(s.segmentPath(exchange, pair, d))
+			if err != nil {
+				return err
+			}
+			for i := range segment {
+				if !segment[i].Timestamp.Before(from) && segment[i].Timestamp.Before(to) {
+					trades = append(trades, &segment[i])
+				}
+			}
+		}
+
+		sort.Stable(b.TradeSorter{Trades: trades, By: func(t1, t2 *b.Trade) bool {
+			return t1.Timestamp.Before(t2.Timestamp)
+		}})
+
+		for _, trade := range trades {
+			channel <- *trade
+		}
+	}
+
+	return nil
+}
+
+// returns the timestamp of the latest stored trade for an exchange and
+// pair, or the zero time if there are none
+func (s *Store) Latest(exchange string, pair b.Pair) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, exchange, pair.String(), "*"))
+	if err != nil || len(files) == 0 {
+		return time.Time{}, err
+	}
+
+	sort.Strings(files)
+	trades, err := s.readSegments(strings.TrimSuffix(files[len(files)-1], ".gz"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, trade := range trades {
+		if trade.Timestamp.After(latest) {
+			latest = trade.Timestamp
+		}
+	}
+	return latest, nil
+}
+
+// gzips segments for days that ended longer than compress_after ago
+func (s *Store) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", "*", "*.json"))
+	if err != nil {
+		return err
+	}
+
+	cutoff := s.clock.Now().Add(-s.compressAfter)
+	for _, path := range files {
+		start, err := time.Parse("2006-01-02", filepath.Base(path)[:10])
+		if err != nil || start.Add(day).After(cutoff) {
+			continue
+		}
+
+		trades, err := s.readSegments(path)
+		if err != nil {
+			return err
+		}
+		if err = writeSegment(path+".gz", trades); err != nil {
+			return err
+		}
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		delete(s.segments, path)
+	}
+
+	return nil
+}
+
+// reads a segment, which may be compressed
+func (s *Store) read(path string) ([]b.Trade, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readSegments(path)
+}
+
+// reads the compressed and plain parts of a segment, either may be missing
+func (s *Store) readSegments(path string) ([]b.Trade, error) {
+	compressed, err := readSegment(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	plain, err := readSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(compressed, plain...), nil
+}
+
+// returns how many of each trade identity are in a segment, loaded on
+// first use
+func (s *Store) segmentCounts(path string) (map[string]int, error) {
+	if counts, ok := s.segments[path]; ok {
+		return counts, nil
+	}
+
+	trades, err := s.readSegments(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.segments) >= cachedSegments {
+		for key := range s.segments {
+			delete(s.segments, key)
+			break
+		}
+	}
+
+	counts := map[string]int{}
+	for _, trade := range trades {
+		counts[trade.Key()]++
+	}
+	s.segments[path] = counts
+	return counts, nil
+}
+
+func (s *Store) segmentPath(exchange string, pair b.Pair, t time.Time) string {
+	return filepath.Join(s.dir, exchange, pair.String(), t.UTC().Format("2006-01-02")+".json")
+}
+
+// reads all trades from a segment file, a missing segment is empty
+func readSegment(path string) ([]b.Trade, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []b.Trade{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if filepath.Ext(path) == ".gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	trades := []b.Trade{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var trade b.Trade
+		if err := json.Unmarshal(scanner.Bytes(), &trade); err != nil {
+			return nil, fmt.Errorf("Corrupt segment %s: %v", path, err)
+		}
+		trades = append(trades, trade)
+	}
+
+	return trades, scanner.Err()
+}
+
+// writes a compressed segment via a temp file, so it's never left truncated
+func writeSegment(path string, trades []b.Trade) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".segment")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	gz := gzip.NewWriter(f)
+	encoder := json.NewEncoder(gz)
+	for _, trade := range trades {
+		if err = encoder.Encode(trade); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err = gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	babel "github.com/lox/babelcoin/core"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func trade(id string, t time.Time, rate float64) babel.Trade {
+	return babel.Trade{Id: id, Pair: babel.BTC_USD, Amount: 1, Rate: rate, Timestamp: t, Exchange: "btce"}
+}
+
+func query(s *Store, from time.Time, to time.Time) []babel.Trade {
+	channel := make(chan babel.Trade, 100)
+	So(s.Query("btce", []babel.Pair{babel.BTC_USD}, from, to, channel), ShouldBeNil)
+
+	trades := []babel.Trade{}
+	for trade := range channel {
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+func TestStoreSpec(t *testing.T) {
+	Convey("Subject: Trade Store", t, func() {
+		dir, _ := ioutil.TempDir("", "store")
+		defer os.RemoveAll(dir)
+
+		now := time.Now().UTC()
+		s, err := Open(dir, map[string]interface{}{})
+		So(err, ShouldBeNil)
+
+		Convey(`Duplicate trades should only be inserted once`, func() {
+			ok, err := s.Insert(trade("1", now, 100))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+
+			ok, _ = s.Insert(trade("1", now, 100))
+			So(ok, ShouldBeFalse)
+
+			reopened, _ := Open(dir, map[string]interface{}{})
+			ok, _ = reopened.Insert(trade("1", now, 100))
+			So(ok, ShouldBeFalse)
+			So(len(query(reopened, now.Add(-time.Hour), now.Add(time.Hour))), ShouldEqual, 1)
+		})
+
+		Convey(`Queries should return trades in a range in order`, func() {
+			s.Insert(trade("3", now.Add(-1*time.Hour), 103))
+			s.Insert(trade("1", now.Add(-50*time.Hour), 101))
+			s.Insert(trade("2", now.Add(-26*time.Hour), 102))
+
+			trades := query(s, now.Add(-30*time.Hour), now)
+			So(len(trades), ShouldEqual, 2)
+			So(trades[0].Id, ShouldEqual, "2")
+			So(trades[1].Id, ShouldEqual, "3")
+
+			latest, _ := s.Latest("btce", babel.BTC_USD)
+			So(latest.Equal(now.Add(-time.Hour)), ShouldBeTrue)
+		})
+
+		Convey(`Old segments should be compressed and still queryable`, func() {
+			old := now.Add(-96 * time.Hour)
+			s.Insert(trade("1", old, 100))
+			So(s.Compact(), ShouldBeNil)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "btce", "btc_usd", "*"))
+			So(len(files), ShouldEqual, 1)
+			So(filepath.Ext(files[0]), ShouldEqual, ".gz")
+
+			ok, _ := s.Insert(trade("2", old.Add(time.Second), 100))
+			So(ok, ShouldBeTrue)
+			ok, _ = s.Insert(trade("1", old, 100))
+			So(ok, ShouldBeFalse)
+
+			So(len(query(s, old.Add(-time.Hour), now)), ShouldEqual, 2)
+
+			Convey(`And late trades should be folded in when compacting`, func() {
+				files, _ := filepath.Glob(filepath.Join(dir, "btce", "btc_usd", "*"))
+				So(len(files), ShouldEqual, 2)
+
+				So(s.Compact(), ShouldBeNil)
+				files, _ = filepath.Glob(filepath.Join(dir, "btce", "btc_usd", "*"))
+				So(len(files), ShouldEqual, 1)
+
+				latest, _ := s.Latest("btce", babel.BTC_USD)
+				So(latest.Equal(old.Add(time.Second)), ShouldBeTrue)
+				So(len(query(s, old.Add(-time.Hour), now)), ShouldEqual, 2)
+			})
+		})
+
+		Convey(`Trades without ids should be identified by their contents`, func() {
+			record := func() int {
+				channel := make(chan babel.Trade, 3)
+				channel <- babel.Trade{Pair: babel.BTC_USD, Amount: 1, Rate: 100, Timestamp: now}
+				channel <- babel.Trade{Pair: babel.BTC_USD, Amount: 2, Rate: 100, Timestamp: now}
+				channel <- babel.Trade{Pair: babel.BTC_USD, Amount: 1, Rate: 100, Timestamp: now}
+				close(channel)
+
+				inserted, err := s.Record("btce", channel)
+				So(err, ShouldBeNil)
+				return inserted
+			}
+
+			So(record(), ShouldEqual, 3)
+			So(record(), ShouldEqual, 0)
+		})
+	})
+}
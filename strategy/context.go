@@ -0,0 +1,188 @@
+package strategy
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+// the controlled interface a strategy trades through. orders placed via
+// the context are tracked so that fills can be reported back
+type Context struct {
+	exchange b.Exchange
+	orders   map[string]b.Order
+	fills    []fill
+	last     map[b.Pair]b.MarketData
+	now      time.Time
+	sequence int
+}
+
+type fill struct {
+	trade b.Trade
+	order b.Order
+}
+
+func newContext(exchange b.Exchange) *Context {
+	return &Context{
+		exchange: exchange,
+		orders:   map[string]b.Order{},
+		last:     map[b.Pair]b.MarketData{},
+	}
+}
+
+// places a buy order, amount and rate follow ExchangeAccount.Trade
+func (c *Context) Buy(pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	return c.trade(b.Buy, pair, amount, rate)
+}
+
+// places a sell order, amount and rate follow ExchangeAccount.Trade
+func (c *Context) Sell(pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	return c.trade(b.Sell, pair, amount, rate)
+}
+
+func (c *Context) trade(t b.TradeType, pair b.Pair, amount float64, rate float64) (b.Order, error) {
+	order, err := c.exchange.Account().Trade(t, pair, amount, rate)
+	if err != nil {
+		return order, err
+	}
+
+	tracked := map[string]b.Order{order.Id: {
+		Id:        order.Id,
+		Pair:      order.Pair,
+		Type:      order.Type,
+		Timestamp: order.Timestamp,
+		Amount:    order.Amount,
+		Remains:   order.Amount,
+		Rate:      order.Rate,
+	}}
+	c.orders[order.Id] = tracked[order.Id]
+
+	// orders can fill as they are placed
+	updates, err := b.SyncOrders(c.exchange.Account(), tracked, []b.Order{order})
+	if err != nil {
+		return order, err
+	}
+	c.update(updates)
+
+	return order, nil
+}
+
+// cancels an order placed through the context
+func (c *Context) Cancel(order b.Order) error {
+	if err := c.exchange.Account().CancelOrder(order); err != nil {
+		return err
+	}
+	delete(c.orders, order.Id)
+	return nil
+}
+
+// cancels all open orders placed through the context
+func (c *Context) CancelAll() error {
+	for _, order := range c.orders {
+		if err := c.Cancel(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the open orders placed through the context
+func (c *Context) Orders() []b.Order {
+	orders := []b.Order{}
+	for _, order := range c.orders {
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// returns the account balances, all balances if no symbols are given
+func (c *Context) Balance(symbols ...b.Symbol) (map[b.Symbol]float64, error) {
+	if symbols == nil {
+		symbols = []b.Symbol{}
+	}
+	return c.exchange.Account().Balance(symbols)
+}
+
+// returns the latest market data seen for a pair, or fetches it
+func (c *Context) MarketData(pair b.Pair) (b.MarketData, error) {
+	if data, ok := c.last[pair]; ok {
+		return data, nil
+	}
+	return c.exchange.MarketData(pair)
+}
+
+// returns the time of the latest event, which is simulated in backtests
+func (c *Context) Now() time.Time {
+	return c.now
+}
+
+// logs a message prefixed with the strategy time
+func (c *Context) Logf(format string, v ...interface{}) {
+	log.Printf("[%s] %s", c.now.Format(time.Stamp), fmt.Sprintf(format, v...))
+}
+
+// applies the changes to tracked orders, queueing a fill for any new fills
+// and forgetting orders that are no longer open
+func (c *Context) update(updates []b.OrderUpdate) {
+	for _, update := range updates {
+		tracked, ok := c.orders[update.Order.Id]
+		if !ok {
+			continue
+		}
+
+		if update.Filled > 0 {
+			tracked.Remains -= update.Filled
+			tracked.Fee = update.Order.Fee
+			c.queueFill(tracked, update.Filled, update.Rate)
+		}
+
+		if update.Closed || tracked.Remains <= 0 {
+			delete(c.orders, tracked.Id)
+		} else {
+			c.orders[tracked.Id] = tracked
+		}
+	}
+}
+
+// refreshes tracked orders from the exchange, orders that are no longer
+// open are only reported as filled if the exchange's fills say so
+func (c *Context) sync() error {
+	if len(c.orders) == 0 {
+		return nil
+	}
+
+	open, err := c.exchange.Account().Orders(0)
+	if err != nil {
+		return err
+	}
+
+	updates, err := b.SyncOrders(c.exchange.Account(), c.orders, open)
+	if err != nil {
+		return err
+	}
+	c.update(updates)
+
+	return nil
+}
+
+// queues a fill to be reported, at the price it was filled at when known
+func (c *Context) queueFill(order b.Order, amount float64, rate float64) {
+	if rate <= 0 {
+		rate = c.last[order.Pair].Last
+	}
+
+	c.sequence++
+	c.fills = append(c.fills, fill{
+		trade: b.Trade{
+			Id:        fmt.Sprintf("%s-%d", order.Id, c.sequence),
+			Pair:      order.Pair,
+			Amount:    amount,
+			Rate:      rate,
+			Timestamp: c.now,
+			Type:      order.Type,
+		},
+		order: order,
+	})
+}
@@ -0,0 +1,189 @@
+package strategy
+
+import (
+	"log"
+	"time"
+
+	b "github.com/lox/babelcoin/core"
+	util "github.com/lox/babelcoin/util"
+)
+
+// drives a strategy from an exchange, callbacks are never run concurrently
+type Runtime struct {
+	exchange b.Exchange
+	strategy Strategy
+	pairs    []b.Pair
+	config   map[string]interface{}
+	ctx      *Context
+	seen     map[string]time.Time
+	stop     chan bool
+}
+
+// creates a runtime for a strategy trading pairs on an exchange. accepts
+// trade_interval, order_interval and book_interval durations and a clock as
+// config, order books are only polled if book_interval is set and the
+// exchange's clock is used if there's no clock. if exchange is nil
+// it's bound on the first call to OnTrade, for use in the backtester
+func NewRuntime(exchange b.Exchange, strategy Strategy, pairs []b.Pair, config map[string]interface{}) *Runtime {
+	r := &Runtime{
+		exchange: exchange,
+		strategy: strategy,
+		pairs:    pairs,
+		config:   config,
+		seen:     map[string]time.Time{},
+		stop:     make(chan bool),
+	}
+
+	if exchange != nil {
+		r.ctx = newContext(exchange)
+	}
+
+	return r
+}
+
+// returns the context that the strategy trades through
+func (r *Runtime) Context() *Context {
+	return r.ctx
+}
+
+// runs the strategy against the live feeds of the exchange until Stop
+func (r *Runtime) Run() error {
+	marketData := make(chan b.MarketData, 100)
+	for _, pair := range r.pairs {
+		if err := r.exchange.Ticker(pair, marketData); err != nil {
+			return err
+		}
+		defer b.StopTicker(r.exchange, pair, marketData)
+	}
+
+	done := make(chan bool)
+	defer close(done)
+
+	clock := r.clock()
+	trades := make(chan b.Trade, 5000)
+	if err := util.HistoryPoller(clock, r.exchange, r.pairs, r.duration("trade_interval", 30*time.Second), trades, done); err != nil {
+		return err
+	}
+
+	orderTicker := clock.NewTicker(r.duration("order_interval", 10*time.Second))
+	defer orderTicker.Stop()
+
+	var books <-chan time.Time
+	if interval := r.duration("book_interval", 0); interval > 0 {
+		bookTicker := clock.NewTicker(interval)
+		defer bookTicker.Stop()
+		books = bookTicker.C()
+	}
+
+	for {
+		r.ctx.now = clock.Now()
+
+		select {
+		case data := <-marketData:
+			r.dispatchMarketData(data)
+		case trade := <-trades:
+			if r.isNew(trade) {
+				r.dispatchTrade(trade)
+			}
+		case <-books:
+			for _, pair := range r.pairs {
+				book, err := r.exchange.Account().OrderBook(pair, 0)
+				if err != nil {
+					log.Printf("Failed to fetch order book for %s: %v", pair.String(), err)
+					continue
+				}
+				r.strategy.OnOrderBook(r.ctx, pair, book)
+				r.dispatchFills()
+			}
+		case <-orderTicker.C():
+			if err := r.ctx.sync(); err != nil {
+				log.Printf("Failed to sync orders: %v", err)
+			}
+			r.dispatchFills()
+		case <-r.stop:
+			return nil
+		}
+	}
+}
+
+// stops a running runtime
+func (r *Runtime) Stop() {
+	r.stop <- true
+}
+
+// drives the strategy from a historical trade, which makes the runtime a
+// backtest.Strategy. market data and fills are checked synchronously
+func (r *Runtime) OnTrade(trade b.Trade, exchange b.Exchange) {
+	if r.ctx == nil {
+		r.exchange = exchange
+		r.ctx = newContext(exchange)
+	}
+
+	if !b.ContainsPair(trade.Pair, r.pairs) {
+		return
+	}
+
+	r.ctx.now = trade.Timestamp
+	if err := r.ctx.sync(); err != nil {
+		log.Printf("Failed to sync orders: %v", err)
+	}
+	r.dispatchFills()
+
+	if data, err := exchange.MarketData(trade.Pair); err == nil {
+		r.dispatchMarketData(data)
+	}
+	r.dispatchTrade(trade)
+}
+
+func (r *Runtime) dispatchMarketData(data b.MarketData) {
+	r.ctx.last[data.Pair] = data
+	r.strategy.OnMarketData(r.ctx, data)
+	r.dispatchFills()
+}
+
+func (r *Runtime) dispatchTrade(trade b.Trade) {
+	r.strategy.OnTrade(r.ctx, trade)
+	r.dispatchFills()
+}
+
+// delivers queued fills, including any caused by orders placed in OnFill
+func (r *Runtime) dispatchFills() {
+	for len(r.ctx.fills) > 0 {
+		f := r.ctx.fills[0]
+		r.ctx.fills = r.ctx.fills[1:]
+		r.strategy.OnFill(r.ctx, f.trade, f.order)
+	}
+}
+
+// returns true if a trade hasn't been seen before, the poller re-fetches
+// recent trades so they are de-duplicated here
+func (r *Runtime) isNew(trade b.Trade) bool {
+	key := trade.Key()
+	if _, ok := r.seen[key]; ok {
+		return false
+	}
+	r.seen[key] = trade.Timestamp
+
+	if len(r.seen) > 10000 {
+		for id, timestamp := range r.seen {
+			if timestamp.Before(trade.Timestamp.Add(-time.Hour)) {
+				delete(r.seen, id)
+			}
+		}
+	}
+	return true
+}
+
+func (r *Runtime) clock() b.Clock {
+	if clock, ok := r.config["clock"].(b.Clock); ok {
+		return clock
+	}
+	return b.ExchangeClock(r.exchange)
+}
+
+func (r *Runtime) duration(key string, def time.Duration) time.Duration {
+	if d, ok := r.config[key].(time.Duration); ok {
+		return d
+	}
+	return def
+}
@@ -0,0 +1,152 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lox/babelcoin/backtest"
+	babel "github.com/lox/babelcoin/core"
+	"github.com/lox/babelcoin/exchanges/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// buys on the first market data below a rate, and records callbacks
+type recorder struct {
+	Base
+	rate   float64
+	placed bool
+	data   []babel.MarketData
+	fills  []babel.Trade
+	events chan string
+}
+
+func (s *recorder) OnMarketData(ctx *Context, data babel.MarketData) {
+	s.data = append(s.data, data)
+	if !s.placed {
+		ctx.Buy(data.Pair, 1, s.rate)
+		s.placed = true
+	}
+	s.notify("data")
+}
+
+func (s *recorder) OnFill(ctx *Context, fill babel.Trade, order babel.Order) {
+	s.fills = append(s.fills, fill)
+	s.notify("fill")
+}
+
+func (s *recorder) notify(event string) {
+	if s.events != nil {
+		s.events <- event
+	}
+}
+
+// waits for the next callback, failing if none arrives
+func next(events chan string) string {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
+// advances the clock once n tickers are waiting on it
+func tick(clock *babel.FakeClock, n int, d time.Duration) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(d)
+}
+
+func TestRuntimeSpec(t *testing.T) {
+	Convey("Subject: Strategy Runtime", t, func() {
+		Convey(`Strategies should run against a live exchange`, func() {
+			clock := babel.NewFakeClock(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+			exchange := fake.New("fake", map[string]interface{}{
+				"balances": map[babel.Symbol]float64{babel.USD: 1000},
+				"clock":    clock,
+			}).(*fake.Driver)
+			s := &recorder{rate: 95, events: make(chan string, 10)}
+
+			runtime := NewRuntime(exchange, s, []babel.Pair{babel.BTC_USD}, map[string]interface{}{
+				"order_interval": 10 * time.Second,
+				"clock":          clock,
+			})
+			exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 99, Sell: 100, Last: 100})
+			done := make(chan error)
+			go func() { done <- runtime.Run() }()
+
+			So(next(s.events), ShouldEqual, "data")
+			exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 93, Sell: 94, Last: 94})
+			So(next(s.events), ShouldEqual, "data")
+			tick(clock, 2, 10*time.Second)
+			So(next(s.events), ShouldEqual, "fill")
+			runtime.Stop()
+
+			So(<-done, ShouldBeNil)
+			So(len(s.data), ShouldEqual, 2)
+			So(len(s.fills), ShouldEqual, 1)
+			So(s.fills[0].Amount, ShouldEqual, 1)
+			So(s.fills[0].Rate, ShouldEqual, 94)
+			So(len(runtime.Context().Orders()), ShouldEqual, 0)
+		})
+
+		Convey(`Orders cancelled on the exchange shouldn't be reported as fills`, func() {
+			exchange := fake.New("fake", map[string]interface{}{
+				"balances": map[babel.Symbol]float64{babel.USD: 1000},
+			}).(*fake.Driver)
+			exchange.SetMarketData(babel.MarketData{Pair: babel.BTC_USD, Buy: 99, Sell: 100, Last: 100})
+			ctx := NewRuntime(exchange, &recorder{}, []babel.Pair{babel.BTC_USD}, nil).Context()
+
+			order, err := ctx.Buy(babel.BTC_USD, 1, 90)
+			So(err, ShouldBeNil)
+			So(exchange.CancelOrder(order), ShouldBeNil)
+
+			So(ctx.sync(), ShouldBeNil)
+			So(len(ctx.fills), ShouldEqual, 0)
+			So(len(ctx.Orders()), ShouldEqual, 0)
+		})
+
+		Convey(`Strategies should run unchanged in the backtester`, func() {
+			s := &recorder{rate: 95}
+			bt := backtest.New(backtest.Config{
+				Pair:     babel.BTC_USD,
+				Balances: map[babel.Symbol]float64{babel.USD: 1000},
+			}, NewRuntime(nil, s, []babel.Pair{babel.BTC_USD}, nil))
+
+			trades := make(chan babel.Trade, 3)
+			for i, price := range []float64{100, 94, 98} {
+				trades <- babel.Trade{Pair: babel.BTC_USD, Amount: 5, Rate: price, Timestamp: time.Unix(int64(i), 0)}
+			}
+			close(trades)
+
+			report, err := bt.Run(trades)
+			So(err, ShouldBeNil)
+			So(len(s.data), ShouldEqual, 3)
+			So(len(s.fills), ShouldEqual, 1)
+			So(s.fills[0].Rate, ShouldEqual, 95)
+			So(s.fills[0].Timestamp, ShouldResemble, time.Unix(1, 0))
+			So(report.Balances[babel.BTC], ShouldEqual, 1)
+		})
+
+		Convey(`Trades without ids should be told apart by their contents`, func() {
+			runtime := NewRuntime(fake.New("fake", map[string]interface{}{}), &recorder{}, []babel.Pair{babel.BTC_USD}, map[string]interface{}{})
+			first := babel.Trade{Pair: babel.BTC_USD, Amount: 1, Rate: 100, Timestamp: time.Unix(1, 0)}
+			second := babel.Trade{Pair: babel.BTC_USD, Amount: 2, Rate: 101, Timestamp: time.Unix(2, 0)}
+
+			So(runtime.isNew(first), ShouldBeTrue)
+			So(runtime.isNew(second), ShouldBeTrue)
+			So(runtime.isNew(first), ShouldBeFalse)
+		})
+
+		Convey(`Strategies should be registered by name`, func() {
+			s, err := NewStrategy("buyhold", nil)
+			So(err, ShouldBeNil)
+			So(s, ShouldNotBeNil)
+
+			_, err = NewStrategy("unknown", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
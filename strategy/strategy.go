@@ -0,0 +1,80 @@
+/*
+A runtime for trading strategies.
+
+A Strategy receives callbacks for market data, public trades, order
+books and fills of its own orders, and places orders through the
+Context it is given. The Runtime drives a strategy from any Exchange,
+so the same strategy runs against live drivers, paper trading, and in
+the backtester.
+*/
+package strategy
+
+import (
+	"errors"
+
+	b "github.com/lox/babelcoin/core"
+)
+
+type Strategy interface {
+	// called when new market data is available for a pair
+	OnMarketData(ctx *Context, data b.MarketData)
+
+	// called for each public trade on the exchange
+	OnTrade(ctx *Context, trade b.Trade)
+
+	// called when an order book for a pair is refreshed
+	OnOrderBook(ctx *Context, pair b.Pair, book b.OrderBook)
+
+	// called when an order placed through the context is filled, the order
+	// is the state after the fill
+	OnFill(ctx *Context, fill b.Trade, order b.Order)
+}
+
+// a strategy with no-op callbacks, to embed in strategies that only need some
+type Base struct{}
+
+func (s Base) OnMarketData(ctx *Context, data b.MarketData)            {}
+func (s Base) OnTrade(ctx *Context, trade b.Trade)                     {}
+func (s Base) OnOrderBook(ctx *Context, pair b.Pair, book b.OrderBook) {}
+func (s Base) OnFill(ctx *Context, fill b.Trade, order b.Order)        {}
+
+// a function for creating a Strategy
+type StrategyFactory func(config map[string]interface{}) Strategy
+
+var strategies = map[string]StrategyFactory{}
+
+// return an instance of a strategy, given it's string name
+func NewStrategy(name string, config map[string]interface{}) (Strategy, error) {
+	factory, ok := strategies[name]
+	if !ok {
+		return nil, errors.New("No strategy registered for " + name)
+	}
+
+	return factory(config), nil
+}
+
+// called by strategies when initializing
+func AddStrategyFactory(name string, factory StrategyFactory) {
+	strategies[name] = factory
+}
+
+// buys with the entire counter balance on the first market data and holds,
+// the baseline that other strategies should beat
+type buyAndHold struct {
+	Base
+	bought map[b.Pair]bool
+}
+
+func (s *buyAndHold) OnMarketData(ctx *Context, data b.MarketData) {
+	if !s.bought[data.Pair] {
+		if _, err := ctx.Buy(data.Pair, -1, -1); err == nil {
+			s.bought[data.Pair] = true
+		}
+	}
+}
+
+func init() {
+	AddStrategyFactory("buyhold", func(config map[string]interface{}) Strategy {
+		return &buyAndHold{bought: map[b.Pair]bool{}}
+	})
+}
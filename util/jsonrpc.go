@@ -15,11 +15,18 @@ import (
 	"net/url"
 	"os"
 	"strconv"
-	"time"
+	"sync"
+
+	b "github.com/lox/babelcoin/core"
 )
 
 type JsonRPCClient struct {
 	Url, Key, Secret string
+
+	// the clock nonces are generated from, the system clock if nil
+	Clock     b.Clock
+	mutex     sync.Mutex
+	lastNonce int64
 }
 
 // generate hmac-sha512 hash, hex encoded
@@ -31,7 +38,7 @@ func (c *JsonRPCClient) sign(secret string, payload string) string {
 
 // returns a url encoded string to be signed an send
 func (c *JsonRPCClient) encodePostData(method string, params map[string]string) string {
-	nonce := time.Now().Unix()
+	nonce := c.nonce()
 	result := fmt.Sprintf("method=%s&nonce=%d", method, nonce)
 
 	// params are unordered, but after method and nonce
@@ -46,6 +53,25 @@ func (c *JsonRPCClient) encodePostData(method string, params map[string]string)
 	return result
 }
 
+// returns a nonce from the clock, nonces must increase so calls in the same
+// second are given the next one
+func (c *JsonRPCClient) nonce() int64 {
+	var clock b.Clock = b.RealClock{}
+	if c.Clock != nil {
+		clock = c.Clock
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	nonce := clock.Now().Unix()
+	if nonce <= c.lastNonce {
+		nonce = c.lastNonce + 1
+	}
+	c.lastNonce = nonce
+	return nonce
+}
+
 // marshal an api response into an object
 func (c *JsonRPCClient) marshalResponse(resp *http.Response, v interface{}) error {
 	// read the response
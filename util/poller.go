@@ -5,34 +5,59 @@ import (
 	. "github.com/lox/babelcoin/core"
 )
 
-// polls Exchange.MarketData periodically, writes data to a channel
-func MarketDataPoller(ex Exchange, pair Pair, freq time.Duration, channel chan<- MarketData) error {
-	ticker := time.NewTicker(freq)
+// polls Exchange.MarketData periodically, writes data to a channel until
+// stop is closed
+func MarketDataPoller(clock Clock, ex Exchange, pair Pair, freq time.Duration, channel chan<- MarketData, stop <-chan bool) error {
+	ticker := clock.NewTicker(freq)
 	data, err := ex.MarketData(pair)
 	if err != nil {
+		ticker.Stop()
 		return err
 	}
 
 	channel <- data
 	go func() {
-		for _ = range ticker.C {
-			data, _ = ex.MarketData(pair)
-			channel <- data
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				data, _ = ex.MarketData(pair)
+				select {
+				case channel <- data:
+				case <-stop:
+					return
+				}
+			case <-stop:
+				return
+			}
 		}
 	}()
 
 	return nil
 }
 
-// polls Exchange.History periodically, trades to channel. no de-duping occurs
-func HistoryPoller(ex Exchange, pairs []Pair, freq time.Duration, channel chan<- Trade) error {
-	ticker := time.NewTicker(freq)
+// polls Exchange.History periodically, trades to channel until stop is
+// closed. no de-duping occurs
+func HistoryPoller(clock Clock, ex Exchange, pairs []Pair, freq time.Duration, channel chan<- Trade, stop <-chan bool) error {
+	return HistoryPollerAfter(clock, ex, pairs, freq, clock.Now().AddDate(0, 0, -3), channel, stop)
+}
+
+// polls like HistoryPoller, with the first poll fetching trades after a time
+// rather than the last 3 days
+func HistoryPollerAfter(clock Clock, ex Exchange, pairs []Pair, freq time.Duration, after time.Time, channel chan<- Trade, stop <-chan bool) error {
+	ticker := clock.NewTicker(freq)
 
 	go func() {
-		after := time.Now().AddDate(0, 0, -3) // 3 days ago
+		defer ticker.Stop()
 		limit := 2000
 
-		for _ = range ticker.C {
+		for {
+			select {
+			case <-ticker.C():
+			case <-stop:
+				return
+			}
+
 			var trades = make(chan Trade)
 
 			go func() {
@@ -42,10 +67,19 @@ func HistoryPoller(ex Exchange, pairs []Pair, freq time.Duration, channel chan<-
 			}()
 
 			for trade := range trades {
-				channel <- trade
+				select {
+				case channel <- trade:
+				case <-stop:
+					// let the exchange finish writing the history
+					go func() {
+						for _ = range trades {
+						}
+					}()
+					return
+				}
 			}
 
-			after = time.Now().Add(-(time.Minute * 15))
+			after = clock.Now().Add(-(time.Minute * 15))
 			limit = 100
 		}
 	}()